@@ -3,7 +3,9 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"we-are-legion/config"
 	"we-are-legion/rag"
 
 	"github.com/openai/openai-go"
@@ -16,14 +18,13 @@ func GetBill() (*robby.Agent, error) {
 	model := os.Getenv("MODEL_RUNNER_CHAT_MODEL_BILL")
 	embeddingModel := os.Getenv("MODEL_RUNNER_EMBEDDING_MODEL")
 
-	chunks, err := rag.GetChunksOfCloneDocuments("bill")
+	retrieval := config.GetRetrievalConfig("bill")
+	chunks, err := rag.GetChunksOfCloneDocuments("bill", retrieval.ChunkSize, retrieval.ChunkOverlap)
 	if err != nil {
 		return nil, fmt.Errorf("error getting chunks for Bill: %w", err)
 	}
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📕 Bill, chat model:", model)
-	fmt.Println("📗 Bill, embedding model:", embeddingModel)
+	slog.Info("📕 chat agent", "agent", "Bill", "url", modelRunnerURL, "model", model, "embedding_model", embeddingModel)
 
 	bill, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -42,11 +43,14 @@ func GetBill() (*robby.Agent, error) {
 				Model: embeddingModel,
 			},
 		),
-		robby.WithRAGMemory(chunks),
 	)
 	if err != nil {
 		return nil, err
 	}
+	// NOTE: the embeddings of the chunks are created concurrently with the ones of the other clones (see rag.BuildMemory)
+	if err := rag.BuildMemory(context.Background(), "Bill", bill, chunks); err != nil {
+		return nil, fmt.Errorf("error building the RAG memory of Bill: %w", err)
+	}
 	return bill, nil
 
 }
@@ -73,5 +77,6 @@ func InitializeBillAgent() (*AgentConfig, error) {
 		Name:        "Bill",
 		Description: "A clone of Bob, with a different personality",
 		Agent:       bill,
+		Retrieval:   config.GetRetrievalConfig("bill"),
 	}, nil
 }
@@ -3,7 +3,9 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"we-are-legion/config"
 	"we-are-legion/rag"
 
 	"github.com/openai/openai-go"
@@ -16,14 +18,13 @@ func GetBob() (*robby.Agent, error) {
 	model := os.Getenv("MODEL_RUNNER_CHAT_MODEL_BOB")
 	embeddingModel := os.Getenv("MODEL_RUNNER_EMBEDDING_MODEL")
 
-	chunks, err := rag.GetChunksOfCloneDocuments("bob")
+	retrieval := config.GetRetrievalConfig("bob")
+	chunks, err := rag.GetChunksOfCloneDocuments("bob", retrieval.ChunkSize, retrieval.ChunkOverlap)
 	if err != nil {
 		return nil, fmt.Errorf("error getting chunks for Bob: %w", err)
 	}
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📕 Bob, chat model:", model)
-	fmt.Println("📗 Bob, embedding model:", embeddingModel)
+	slog.Info("📕 chat agent", "agent", "Bob", "url", modelRunnerURL, "model", model, "embedding_model", embeddingModel)
 
 	bob, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -42,11 +43,14 @@ func GetBob() (*robby.Agent, error) {
 				Model: embeddingModel,
 			},
 		),
-		robby.WithRAGMemory(chunks),
 	)
 	if err != nil {
 		return nil, err
 	}
+	// NOTE: the embeddings of the chunks are created concurrently with the ones of the other clones (see rag.BuildMemory)
+	if err := rag.BuildMemory(context.Background(), "Bob", bob, chunks); err != nil {
+		return nil, fmt.Errorf("error building the RAG memory of Bob: %w", err)
+	}
 	return bob, nil
 }
 
@@ -84,6 +88,7 @@ func InitializeBobAgent() (*AgentConfig, error) {
 		Name:        "Bob",
 		Description: "The original Bob agent",
 		Agent:       bob,
+		Retrieval:   config.GetRetrievalConfig("bob"),
 	}
 
 	return agentConfig, nil
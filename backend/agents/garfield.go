@@ -3,7 +3,9 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"we-are-legion/config"
 	"we-are-legion/rag"
 
 	"github.com/openai/openai-go"
@@ -16,14 +18,13 @@ func GetGarfield() (*robby.Agent, error) {
 	model := os.Getenv("MODEL_RUNNER_CHAT_MODEL_GARFIELD")
 	embeddingModel := os.Getenv("MODEL_RUNNER_EMBEDDING_MODEL")
 
-	chunks, err := rag.GetChunksOfCloneDocuments("garfield")
+	retrieval := config.GetRetrievalConfig("garfield")
+	chunks, err := rag.GetChunksOfCloneDocuments("garfield", retrieval.ChunkSize, retrieval.ChunkOverlap)
 	if err != nil {
 		return nil, fmt.Errorf("error getting chunks for Garfield: %w", err)
 	}
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📕 Garfield, chat model:", model)
-	fmt.Println("📗 Garfield, embedding model:", embeddingModel)
+	slog.Info("📕 chat agent", "agent", "Garfield", "url", modelRunnerURL, "model", model, "embedding_model", embeddingModel)
 
 	garfield, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -42,11 +43,14 @@ func GetGarfield() (*robby.Agent, error) {
 				Model: embeddingModel,
 			},
 		),
-		robby.WithRAGMemory(chunks),
 	)
 	if err != nil {
 		return nil, err
 	}
+	// NOTE: the embeddings of the chunks are created concurrently with the ones of the other clones (see rag.BuildMemory)
+	if err := rag.BuildMemory(context.Background(), "Garfield", garfield, chunks); err != nil {
+		return nil, fmt.Errorf("error building the RAG memory of Garfield: %w", err)
+	}
 	return garfield, nil
 
 }
@@ -72,6 +76,7 @@ func InitializeGarfieldAgent() (*AgentConfig, error) {
 		Name:        "Garfield",
 		Description: "A clone of Bob, with a different personality",
 		Agent:       garfield,
+		Retrieval:   config.GetRetrievalConfig("garfield"),
 	}, nil
 
 }
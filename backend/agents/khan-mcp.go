@@ -3,6 +3,7 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/openai/openai-go"
@@ -14,8 +15,7 @@ func GetKhan() (*robby.Agent, error) {
 	modelRunnerURL := os.Getenv("DMR_BASE_URL") + "/engines/llama.cpp/v1"
 	modelForTools := os.Getenv("MODEL_RUNNER_TOOLS_MODEL")
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📘 Khan, tool model:", modelForTools)
+	slog.Info("📘 tools agent", "agent", "Khan", "url", modelRunnerURL, "model", modelForTools)
 
 	khan, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -28,25 +28,22 @@ func GetKhan() (*robby.Agent, error) {
 				Messages: []openai.ChatCompletionMessageParamUnion{
 					openai.SystemMessage(`
 					Your name is Khan, 
-					Use the tool, only if the user specify he wants to use brae search.
-					Otherwise, ignore the tool.
+					Use the tools only if they are needed to answer the user question.
+					Otherwise, ignore the tools.
 					`),
 				},
 				Temperature: openai.Opt(0.0),
 				//ParallelToolCalls: openai.Bool(true),
 			},
 		),
-		robby.WithMCPClient(robby.WithSocatMCPToolkit()),
-		//robby.WithMCPClient(robby.WithDockerMCPToolkit()),
-		//robby.WithMCPTools([]string{"fetch"}), 
-
-		robby.WithMCPTools([]string{"brave_web_search"}), 
-		// NOTE: you must activate the fetch MCP server in Docker MCP Toolkit
-
+		// NOTE: the MCP tools are not set here:
+		// they come from the MCP hub (see config.yml), filtered by the allowlist of the selected clone.
+		// Khan is only called when web search is enabled (see the web_search option of the /chat body and of the sessions).
 	)
 	if err != nil {
 		return nil, err
 	}
+
 	return khan, nil
 }
 
@@ -59,10 +56,9 @@ func InitializeKhanAgent() (*AgentConfig, error) {
 
 	return &AgentConfig{
 		Name:        "Khan",
-		Description: "Khan is an agent that helps the user to search the web using Brave Search.",
+		Description: "Khan is an agent that helps the user to use the MCP tools (ex: search the web using Brave Search).",
 		Agent:       khan,
 		ToolAgent:   true, // Indicates that Khan has a tool agent
 	}, nil
 }
 
-
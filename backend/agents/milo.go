@@ -3,7 +3,9 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"we-are-legion/config"
 	"we-are-legion/rag"
 
 	"github.com/openai/openai-go"
@@ -16,14 +18,13 @@ func GetMilo() (*robby.Agent, error) {
 	model := os.Getenv("MODEL_RUNNER_CHAT_MODEL_MILO")
 	embeddingModel := os.Getenv("MODEL_RUNNER_EMBEDDING_MODEL")
 
-	chunks, err := rag.GetChunksOfCloneDocuments("milo")
+	retrieval := config.GetRetrievalConfig("milo")
+	chunks, err := rag.GetChunksOfCloneDocuments("milo", retrieval.ChunkSize, retrieval.ChunkOverlap)
 	if err != nil {
 		return nil, fmt.Errorf("error getting chunks for Milo: %w", err)
 	}
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📕 Milo, chat model:", model)
-	fmt.Println("📗 Milo, embedding model:", embeddingModel)
+	slog.Info("📕 chat agent", "agent", "Milo", "url", modelRunnerURL, "model", model, "embedding_model", embeddingModel)
 
 	milo, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -42,11 +43,14 @@ func GetMilo() (*robby.Agent, error) {
 				Model: embeddingModel,
 			},
 		),
-		robby.WithRAGMemory(chunks),
 	)
 	if err != nil {
 		return nil, err
 	}
+	// NOTE: the embeddings of the chunks are created concurrently with the ones of the other clones (see rag.BuildMemory)
+	if err := rag.BuildMemory(context.Background(), "Milo", milo, chunks); err != nil {
+		return nil, fmt.Errorf("error building the RAG memory of Milo: %w", err)
+	}
 	return milo, nil
 
 }
@@ -73,5 +77,6 @@ func InitializeMiloAgent() (*AgentConfig, error) {
 		Name:        "Milo",
 		Description: "A clone of Bob, with a different personality",
 		Agent:       milo,
+		Retrieval:   config.GetRetrievalConfig("milo"),
 	}, nil
 }
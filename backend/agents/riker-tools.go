@@ -3,6 +3,7 @@ package agents
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/openai/openai-go"
@@ -14,8 +15,7 @@ func GetRiker() (*robby.Agent, error) {
 	modelRunnerURL := os.Getenv("DMR_BASE_URL") + "/engines/llama.cpp/v1"
 	modelForTools := os.Getenv("MODEL_RUNNER_TOOLS_MODEL")
 
-	fmt.Println("🌍", modelRunnerURL)
-	fmt.Println("📘 Riker, tool model:", modelForTools)
+	slog.Info("📘 tools agent", "agent", "Riker", "url", modelRunnerURL, "model", modelForTools)
 
 	riker, err := robby.NewAgent(
 		robby.WithDMRClient(
@@ -114,7 +114,7 @@ func GetRikerToolsCatalog() []openai.ChatCompletionToolParam {
 						"description": "The topic name to detect in the user message. The topic can be one of the following: [docker, docker compose, docker bake, docker model runner].",
 					},
 				},
-				"required": []string{"message"},
+				"required": []string{"topic_name"},
 			},
 		},
 	}
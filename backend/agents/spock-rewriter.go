@@ -0,0 +1,51 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+func GetSpock() (*robby.Agent, error) {
+	// TODO: handle error
+	modelRunnerURL := os.Getenv("DMR_BASE_URL") + "/engines/llama.cpp/v1"
+	modelForTools := os.Getenv("MODEL_RUNNER_TOOLS_MODEL")
+
+	slog.Info("📘 rewriting agent", "agent", "Spock", "url", modelRunnerURL, "model", modelForTools)
+
+	spock, err := robby.NewAgent(
+		robby.WithDMRClient(
+			context.Background(),
+			modelRunnerURL,
+		),
+		robby.WithParams(
+			openai.ChatCompletionNewParams{
+				Model:       modelForTools,
+				Messages:    []openai.ChatCompletionMessageParamUnion{},
+				Temperature: openai.Opt(0.0),
+			},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return spock, nil
+}
+
+func InitializeSpockAgent() (*AgentConfig, error) {
+	spock, err := GetSpock()
+	if err != nil {
+		return nil, fmt.Errorf("error creating Spock agent: %w", err)
+	}
+
+	return &AgentConfig{
+		Name:        "Spock",
+		Description: "Spock is an agent that rewrites the follow-up questions of the user into standalone search queries.",
+		Agent:       spock,
+		ToolAgent:   true, // Spock does not answer the user
+	}, nil
+}
@@ -1,10 +1,17 @@
 package agents
 
-import "github.com/sea-monkeys/robby"
+import (
+	"we-are-legion/config"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
 
 type AgentConfig struct {
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
 	Agent       *robby.Agent `json:"agent"`
 	ToolAgent bool		 `json:"tool_agent,omitempty"` // Indicates if the agent has a tool agent
+	Retrieval config.RetrievalConfig `json:"retrieval,omitempty"` // Retrieval parameters of the RAG memory of the agent
+	Persona []openai.ChatCompletionMessageParamUnion `json:"-"` // Snapshot of the messages set at the initialization (the system message of the persona)
 }
@@ -0,0 +1,120 @@
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Request contains the tool calls of a /chat request waiting for the approval of the user.
+type Request struct {
+	ID      string
+	ctx     context.Context
+	mutex   sync.Mutex
+	pending map[string]chan bool // tool call id -> decision (true: approved)
+}
+
+var (
+	requests      = map[string]*Request{}
+	requestsMutex sync.Mutex
+)
+
+// ErrDuplicateRequestID is returned by NewRequest when the id is the one of an active request.
+var ErrDuplicateRequestID = errors.New("duplicate request id")
+
+// NewRequestID returns a random id for a /chat request.
+func NewRequestID() string {
+	data := make([]byte, 8)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+// NewRequest registers a /chat request, the pending tool calls are rejected when the context is done.
+// The id can be sent by the client: it fails with ErrDuplicateRequestID if it is the id of an active request
+// (the approvals of a request must not be resolved by another one). Close must be called at the end of the request.
+func NewRequest(ctx context.Context, id string) (*Request, error) {
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+	if _, exists := requests[id]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateRequestID, id)
+	}
+	request := &Request{ID: id, ctx: ctx, pending: map[string]chan bool{}}
+	requests[id] = request
+	return request, nil
+}
+
+// Close unregisters the request.
+func (request *Request) Close() {
+	requestsMutex.Lock()
+	if requests[request.ID] == request {
+		delete(requests, request.ID)
+	}
+	requestsMutex.Unlock()
+}
+
+// Add registers a tool call waiting for a decision, before it is sent to the user (see Wait).
+func (request *Request) Add(toolCallID string) {
+	request.mutex.Lock()
+	defer request.mutex.Unlock()
+	request.pending[toolCallID] = make(chan bool, 1)
+}
+
+// Wait waits for the decision of the user on a tool call added with Add.
+// The tool call is rejected after the timeout, or if the request is cancelled.
+func (request *Request) Wait(toolCallID string, timeout time.Duration) (bool, error) {
+	request.mutex.Lock()
+	decision, ok := request.pending[toolCallID]
+	request.mutex.Unlock()
+	if !ok {
+		return false, errors.New("unknown tool call: " + toolCallID)
+	}
+	defer func() {
+		request.mutex.Lock()
+		delete(request.pending, toolCallID)
+		request.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case approved := <-decision:
+		return approved, nil
+	case <-timer.C:
+		return false, errors.New("approval timeout")
+	case <-request.ctx.Done():
+		return false, request.ctx.Err()
+	}
+}
+
+// Resolve approves or rejects the pending tool calls of a request
+// (only the given tool call if the id is not empty). It returns the number of resolved tool calls.
+func Resolve(requestID string, toolCallID string, approved bool) (int, error) {
+	requestsMutex.Lock()
+	request, ok := requests[requestID]
+	requestsMutex.Unlock()
+	if !ok {
+		return 0, errors.New("unknown request: " + requestID)
+	}
+
+	request.mutex.Lock()
+	defer request.mutex.Unlock()
+	resolved := 0
+	for id, decision := range request.pending {
+		if toolCallID != "" && id != toolCallID {
+			continue
+		}
+		select {
+		case decision <- approved:
+			resolved++
+		default: // NOTE: already resolved
+		}
+	}
+	if resolved == 0 {
+		return 0, errors.New("no pending tool call for the request: " + requestID)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,93 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewRequestRejectsDuplicateIDs(t *testing.T) {
+	first, err := NewRequest(context.Background(), "test-duplicate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRequest(context.Background(), "test-duplicate"); !errors.Is(err, ErrDuplicateRequestID) {
+		t.Errorf("NewRequest() with an active id: %v, want ErrDuplicateRequestID", err)
+	}
+	first.Close()
+	second, err := NewRequest(context.Background(), "test-duplicate")
+	if err != nil {
+		t.Errorf("NewRequest() with the id of a closed request: %v", err)
+	}
+	second.Close()
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		pending      []string
+		toolCallID   string
+		approved     bool
+		wantResolved int
+		wantError    bool
+		wantDecision map[string]bool
+	}{
+		{"approve all", []string{"call_1", "call_2"}, "", true, 2, false, map[string]bool{"call_1": true, "call_2": true}},
+		{"reject one", []string{"call_1", "call_2"}, "call_2", false, 1, false, map[string]bool{"call_2": false}},
+		{"unknown tool call", []string{"call_1"}, "call_3", true, 0, true, nil},
+		{"no pending tool call", nil, "", true, 0, true, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := NewRequest(context.Background(), "test-resolve")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer request.Close()
+			for _, toolCallID := range test.pending {
+				request.Add(toolCallID)
+			}
+
+			resolved, err := Resolve("test-resolve", test.toolCallID, test.approved)
+			if resolved != test.wantResolved || (err != nil) != test.wantError {
+				t.Fatalf("Resolve() = %d, %v, want %d (error: %v)", resolved, err, test.wantResolved, test.wantError)
+			}
+			for toolCallID, want := range test.wantDecision {
+				approved, err := request.Wait(toolCallID, time.Second)
+				if err != nil || approved != want {
+					t.Errorf("Wait(%s) = %v, %v, want %v", toolCallID, approved, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveUnknownRequest(t *testing.T) {
+	if _, err := Resolve("test-unknown", "", true); err == nil {
+		t.Error("Resolve() of an unknown request must fail")
+	}
+}
+
+func TestWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	request, err := NewRequest(ctx, "test-wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer request.Close()
+
+	request.Add("timeout")
+	if approved, err := request.Wait("timeout", 10*time.Millisecond); approved || err == nil {
+		t.Errorf("Wait() after the timeout = %v, %v, want a rejection", approved, err)
+	}
+	if _, err := request.Wait("unknown", time.Second); err == nil {
+		t.Error("Wait() of an unknown tool call must fail")
+	}
+
+	request.Add("cancelled")
+	cancel()
+	if approved, err := request.Wait("cancelled", time.Minute); approved || !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() of a cancelled request = %v, %v, want context.Canceled", approved, err)
+	}
+}
@@ -0,0 +1,59 @@
+package cancellation
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrCancelled is the cause of the cancellation of a request cancelled with Cancel (see context.Cause).
+var ErrCancelled = errors.New("request cancelled")
+
+// activeRequest is the cancel function of an active request
+// (a pointer: the request unregisters its own cancel function, not the one of a later request with the same id).
+type activeRequest struct {
+	cancel context.CancelCauseFunc
+}
+
+// NOTE: the key is the id of the /chat request (see the X-Request-Id header)
+var (
+	requests      = map[string]*activeRequest{}
+	requestsMutex sync.Mutex
+)
+
+// WithCancel returns a copy of the context of a /chat request, cancelled by Cancel with the id of the request,
+// and the function to call at the end of the request (it cancels the context and unregisters the request).
+func WithCancel(ctx context.Context, id string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	request := &activeRequest{cancel: cancel}
+
+	requestsMutex.Lock()
+	requests[id] = request
+	requestsMutex.Unlock()
+
+	return ctx, func() {
+		requestsMutex.Lock()
+		if requests[id] == request {
+			delete(requests, id)
+		}
+		requestsMutex.Unlock()
+		cancel(context.Canceled)
+	}
+}
+
+// Cancel cancels the active request with the given id (false if no active request has this id).
+func Cancel(id string) bool {
+	requestsMutex.Lock()
+	request, ok := requests[id]
+	requestsMutex.Unlock()
+	if !ok {
+		return false
+	}
+	request.cancel(ErrCancelled)
+	return true
+}
+
+// IsCancelled returns true if the context has been cancelled by Cancel.
+func IsCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
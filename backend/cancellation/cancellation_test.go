@@ -0,0 +1,72 @@
+package cancellation
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestCancel(t *testing.T) {
+	first, endFirst := WithCancel(context.Background(), "first")
+	defer endFirst()
+	second, endSecond := WithCancel(context.Background(), "second")
+	defer endSecond()
+
+	if Cancel("unknown") {
+		t.Error("Cancel() of an unknown request must return false")
+	}
+	if !Cancel("first") {
+		t.Fatal("Cancel() of an active request must return true")
+	}
+	if first.Err() == nil || !IsCancelled(first) {
+		t.Errorf("the cancelled request is not cancelled: %v", context.Cause(first))
+	}
+	if second.Err() != nil {
+		t.Errorf("the other requests must not be cancelled: %v", second.Err())
+	}
+}
+
+func TestEndOfRequest(t *testing.T) {
+	ctx, end := WithCancel(context.Background(), "ended")
+	end()
+	if ctx.Err() == nil || IsCancelled(ctx) {
+		t.Errorf("the context of an ended request is done without being cancelled: %v", context.Cause(ctx))
+	}
+	if Cancel("ended") {
+		t.Error("an ended request can not be cancelled")
+	}
+
+	// NOTE: a cancellation does not outlive the request, a later request with the same id is not cancelled
+	later, endLater := WithCancel(context.Background(), "ended")
+	defer endLater()
+	if later.Err() != nil {
+		t.Errorf("a later request with the same id is cancelled: %v", later.Err())
+	}
+}
+
+func TestParentContext(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, end := WithCancel(parent, "parent")
+	defer end()
+	cancelParent()
+	if ctx.Err() == nil || IsCancelled(ctx) {
+		t.Errorf("the request must be done with its parent (ex: the shutdown), without being cancelled: %v", context.Cause(ctx))
+	}
+}
+
+func TestConcurrentCancellations(t *testing.T) {
+	var waitGroup sync.WaitGroup
+	for range 10 {
+		waitGroup.Add(2)
+		go func() {
+			defer waitGroup.Done()
+			_, end := WithCancel(context.Background(), "concurrent")
+			end()
+		}()
+		go func() {
+			defer waitGroup.Done()
+			Cancel("concurrent")
+		}()
+	}
+	waitGroup.Wait()
+}
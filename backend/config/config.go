@@ -0,0 +1,686 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RetrievalConfig contains the parameters of the RAG retrieval.
+type RetrievalConfig struct {
+	SimilarityThreshold float64 `yaml:"similarity_threshold" json:"similarity_threshold"`
+	ChunkSize           int     `yaml:"chunk_size" json:"chunk_size"`
+	ChunkOverlap        int     `yaml:"chunk_overlap" json:"chunk_overlap"`
+	TopK                int     `yaml:"top_k" json:"top_k"`                           // 0: all the chunks above the threshold
+	MaxContextTokens    int     `yaml:"max_context_tokens" json:"max_context_tokens"` // 0: no limit
+}
+
+// RetrievalOverride contains the retrieval parameters set by an agent (config.yml) or by a request (the /chat body).
+// A nil value means "not set" (see RetrievalConfig.Merge), a zero value is a value (ex: top_k: 0 for no limit).
+// Only similarity_threshold, top_k and max_context_tokens apply per request:
+// chunk_size and chunk_overlap are used to build the RAG memories at startup (see ValidateRequest).
+type RetrievalOverride struct {
+	SimilarityThreshold *float64 `yaml:"similarity_threshold,omitempty" json:"similarity_threshold,omitempty"`
+	ChunkSize           *int     `yaml:"chunk_size,omitempty" json:"chunk_size,omitempty"`
+	ChunkOverlap        *int     `yaml:"chunk_overlap,omitempty" json:"chunk_overlap,omitempty"`
+	TopK                *int     `yaml:"top_k,omitempty" json:"top_k,omitempty"`
+	MaxContextTokens    *int     `yaml:"max_context_tokens,omitempty" json:"max_context_tokens,omitempty"`
+}
+
+// Merge returns a copy of the retrieval config with the values set in the override.
+func (retrieval RetrievalConfig) Merge(override RetrievalOverride) RetrievalConfig {
+	if override.SimilarityThreshold != nil {
+		retrieval.SimilarityThreshold = *override.SimilarityThreshold
+	}
+	if override.ChunkSize != nil {
+		retrieval.ChunkSize = *override.ChunkSize
+	}
+	if override.ChunkOverlap != nil {
+		retrieval.ChunkOverlap = *override.ChunkOverlap
+	}
+	if override.TopK != nil {
+		retrieval.TopK = *override.TopK
+	}
+	if override.MaxContextTokens != nil {
+		retrieval.MaxContextTokens = *override.MaxContextTokens
+	}
+	return retrieval
+}
+
+// ValidateRequest checks the retrieval parameters of a request (the /chat body):
+// the chunking parameters can not be set, the RAG memories are already built.
+func (override RetrievalOverride) ValidateRequest() error {
+	if override.ChunkSize != nil || override.ChunkOverlap != nil {
+		return errors.New("chunk_size and chunk_overlap can not be set per request (the RAG memories are built at startup)")
+	}
+	return nil
+}
+
+// Validate checks the retrieval parameters (once merged, see Merge).
+func (retrieval RetrievalConfig) Validate() error {
+	switch {
+	case retrieval.SimilarityThreshold < 0 || retrieval.SimilarityThreshold > 1:
+		return fmt.Errorf("similarity_threshold must be between 0 and 1 (%g)", retrieval.SimilarityThreshold)
+	case retrieval.ChunkSize <= 0:
+		return fmt.Errorf("chunk_size must be positive (%d)", retrieval.ChunkSize)
+	case retrieval.ChunkOverlap < 0 || retrieval.ChunkOverlap >= retrieval.ChunkSize:
+		return fmt.Errorf("chunk_overlap must be between 0 and chunk_size - 1 (%d)", retrieval.ChunkOverlap)
+	case retrieval.TopK < 0:
+		return fmt.Errorf("top_k must not be negative (%d)", retrieval.TopK)
+	case retrieval.MaxContextTokens < 0:
+		return fmt.Errorf("max_context_tokens must not be negative (%d)", retrieval.MaxContextTokens)
+	}
+	return nil
+}
+
+// MCPServerConfig describes how to connect to a MCP server.
+//   - stdio: runs the command and speaks MCP over its stdin/stdout.
+//   - socat: connects to a TCP address with socat (ex: the Docker MCP Toolkit on host.docker.internal:8811).
+//   - http: streamable HTTP transport (url).
+//   - sse: HTTP with Server-Sent Events transport (url).
+type MCPServerConfig struct {
+	Transport string   `yaml:"transport"`
+	Command   []string `yaml:"command,omitempty"`
+	Address   string   `yaml:"address,omitempty"`
+	URL       string   `yaml:"url,omitempty"`
+}
+
+// MCPConfig contains the MCP servers, and the tools allowed for the agents without tool allowlist.
+// The servers are pinged every health check interval, and restarted if they do not answer.
+// A server which does not answer before the connect timeout is skipped (it is reconnected by the health checks).
+// WebSearch is the default value of the web search option (the MCP tools are used only if it is enabled,
+// it can be overridden by the session and by the request).
+type MCPConfig struct {
+	Servers             map[string]MCPServerConfig `yaml:"servers"`
+	DefaultTools        []string                   `yaml:"default_tools"`
+	HealthCheckInterval time.Duration              `yaml:"health_check_interval"`
+	ConnectTimeout      time.Duration              `yaml:"connect_timeout"`
+	WebSearch           bool                       `yaml:"web_search"`
+	Cache               MCPCacheConfig             `yaml:"cache"`
+	Results             MCPResultsConfig           `yaml:"results"`
+}
+
+// MCPResultsConfig contains the parameters of the post-processing of the MCP tool results before they are added to the prompt:
+// the search results (title, URL, snippet) are extracted, capped to max_results and max_tokens,
+// and optionally summarized by the tools model. The URLs are kept as citations.
+type MCPResultsConfig struct {
+	MaxResults int  `yaml:"max_results"`
+	MaxTokens  int  `yaml:"max_tokens"`
+	Summarize  bool `yaml:"summarize"`
+}
+
+// MCPCacheConfig contains the parameters of the cache of the MCP tool results
+// (the key of an entry is the tool name and its normalized arguments).
+// Only the tools with a cacheable setting are cached (the other tools can have side effects).
+// The least recently used entries are evicted when the cache exceeds the max entries or the max bytes.
+type MCPCacheConfig struct {
+	Disabled   bool                       `yaml:"disabled"`
+	TTL        time.Duration              `yaml:"ttl"`
+	MaxEntries int                        `yaml:"max_entries"`
+	MaxBytes   int                        `yaml:"max_bytes"`
+	Tools      map[string]ToolCacheConfig `yaml:"tools"`
+}
+
+// ToolCacheConfig is the cache setting of a MCP tool:
+//   - ttl overrides the TTL of the cache for this tool.
+//   - ignore_case lowercases the string arguments in the key (ex: search queries).
+type ToolCacheConfig struct {
+	Cacheable  bool          `yaml:"cacheable"`
+	TTL        time.Duration `yaml:"ttl,omitempty"`
+	IgnoreCase bool          `yaml:"ignore_case,omitempty"`
+}
+
+// AgentSettings contains the settings of an agent (the key of the agent in the configuration is its catalog key, ex: garfield).
+// The MCP tools are the allowlist of the tools Khan can call when the agent is selected:
+// a tool name (brave_web_search), all the tools of a server (filesystem/*) or a tool of a server (filesystem/read_file).
+type AgentSettings struct {
+	Retrieval RetrievalOverride `yaml:"retrieval"`
+	MCPTools  []string          `yaml:"mcp_tools,omitempty"`
+}
+
+// Approval policies of the tool calls.
+const (
+	PolicyAuto = "auto" // the tool call is executed immediately
+	PolicyAsk  = "ask"  // the tool call waits for the approval of the user (POST /chat/{requestId}/approve or /reject)
+	PolicyDeny = "deny" // the tool call is never executed
+)
+
+// ApprovalConfig contains the approval policies of the tool calls.
+// The key of a policy is a tool name, all the tools of a server (filesystem/*) or a tool of a server (filesystem/write_file),
+// the tools of Riker belong to the "riker" server. The pending tool calls are rejected after the timeout.
+type ApprovalConfig struct {
+	Default string            `yaml:"default"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Tools   map[string]string `yaml:"tools"`
+}
+
+// ValidationConfig contains the parameters of the validation of the tool call arguments against the tool schemas.
+// Retries is the number of new tool calls detections with the validation errors fed back to the model (0: no retry).
+type ValidationConfig struct {
+	Retries int `yaml:"retries"`
+}
+
+// QueryRewriteConfig contains the parameters of the rewriting of the user question by Spock before the similarity search:
+// the question is turned into at most max_queries standalone search queries, with the recent history of the conversation.
+type QueryRewriteConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	MaxQueries int  `yaml:"max_queries"`
+}
+
+// ContextConfig contains the parameters of the assembly of the context of the prompt:
+// the RAG documents and the web results are ranked together (reciprocal rank fusion, with a weight per provenance)
+// and the first ones fitting in max_tokens are kept (0: no limit).
+type ContextConfig struct {
+	MaxTokens  int     `yaml:"max_tokens"`
+	DocsWeight float64 `yaml:"docs_weight"`
+	WebWeight  float64 `yaml:"web_weight"`
+}
+
+// AgentLoopConfig contains the parameters of the agent loop of the selected clone:
+// the clone can call tools up to max_steps times before answering.
+// The tools are search_docs (its docs), ask_clone (ask another clone) and mcp (the MCP tools, if web search is enabled).
+// A clone asked with ask_clone can ask another clone, up to max_clone_depth delegations.
+// The agent loop can also be enabled per request (agent_loop in the /chat body).
+type AgentLoopConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	MaxSteps      int      `yaml:"max_steps"`
+	Tools         []string `yaml:"tools"`
+	MaxCloneDepth int      `yaml:"max_clone_depth"`
+}
+
+// StartupConfig contains the parameters of the initialization of the agents (see workflow.InitializeAgents):
+// the agents are initialized concurrently, with at most embedding_parallelism embeddings of the documents at the same time
+// (shared by all the RAG memories). The unavailable agents are initialized again every retry_interval.
+type StartupConfig struct {
+	EmbeddingParallelism int           `yaml:"embedding_parallelism"`
+	RetryInterval        time.Duration `yaml:"retry_interval"`
+}
+
+// ShutdownConfig contains the parameters of the graceful shutdown of the server (SIGINT or SIGTERM):
+// the server stops accepting requests, the active streams have drain_timeout to finish,
+// then they are cancelled with a final event (the stop grace period of compose.yml must be longer).
+// The sessions and the token usage are saved to state_file, they are restored at the next startup.
+type ShutdownConfig struct {
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	StateFile    string        `yaml:"state_file"`
+}
+
+// SessionsConfig contains the limits of the sessions of the frontend (see the session package):
+// a session not used for the TTL is evicted, and the least recently used session is evicted above max_sessions.
+// The token usage of an evicted session is forgotten.
+type SessionsConfig struct {
+	TTL         time.Duration `yaml:"ttl"`
+	MaxSessions int           `yaml:"max_sessions"`
+}
+
+// Config is the configuration of the backend, loaded from a YAML file (see Get).
+type Config struct {
+	Retrieval    RetrievalConfig          `yaml:"retrieval"` // default retrieval parameters of the agents
+	MCP          MCPConfig                `yaml:"mcp"`
+	Approval     ApprovalConfig           `yaml:"approval"`
+	Validation   ValidationConfig         `yaml:"validation"`
+	QueryRewrite QueryRewriteConfig       `yaml:"query_rewrite"`
+	Context      ContextConfig            `yaml:"context"`
+	AgentLoop    AgentLoopConfig          `yaml:"agent_loop"`
+	Startup      StartupConfig            `yaml:"startup"`
+	Shutdown     ShutdownConfig           `yaml:"shutdown"`
+	Sessions     SessionsConfig           `yaml:"sessions"`
+	Agents       map[string]AgentSettings `yaml:"agents"`
+}
+
+// DefaultConfig returns the configuration used when there is no configuration file.
+func DefaultConfig() Config {
+	return Config{
+		Retrieval:    DefaultRetrievalConfig(),
+		MCP:          DefaultMCPConfig(),
+		Approval:     DefaultApprovalConfig(),
+		QueryRewrite: DefaultQueryRewriteConfig(),
+		Context:      DefaultContextConfig(),
+		AgentLoop:    DefaultAgentLoopConfig(),
+		Startup:      DefaultStartupConfig(),
+		Shutdown:     DefaultShutdownConfig(),
+		Sessions:     DefaultSessionsConfig(),
+		Agents:       map[string]AgentSettings{},
+	}
+}
+
+// DefaultRetrievalConfig returns the retrieval parameters used when they are not set in the configuration.
+func DefaultRetrievalConfig() RetrievalConfig {
+	return RetrievalConfig{
+		SimilarityThreshold: 0.7,
+		ChunkSize:           512,
+		ChunkOverlap:        210,
+	}
+}
+
+// DefaultMCPConfig returns the MCP configuration used when there is no MCP server in the configuration:
+// the Docker MCP Toolkit with socat and the Brave web search.
+func DefaultMCPConfig() MCPConfig {
+	return MCPConfig{
+		Servers: map[string]MCPServerConfig{
+			"docker-mcp-toolkit": {
+				Transport: "socat",
+				Address:   "host.docker.internal:8811",
+			},
+		},
+		DefaultTools:        []string{"brave_web_search"},
+		HealthCheckInterval: 30 * time.Second,
+		ConnectTimeout:      10 * time.Second,
+		Results: MCPResultsConfig{
+			MaxResults: 8,
+			MaxTokens:  1500,
+		},
+		Cache: MCPCacheConfig{
+			TTL:        10 * time.Minute,
+			MaxEntries: 256,
+			MaxBytes:   4 * 1024 * 1024,
+			Tools: map[string]ToolCacheConfig{
+				"brave_web_search": {Cacheable: true, IgnoreCase: true},
+			},
+		},
+	}
+}
+
+// DefaultApprovalConfig returns the approval configuration used when it is not set: all the tool calls are executed immediately.
+func DefaultApprovalConfig() ApprovalConfig {
+	return ApprovalConfig{
+		Default: PolicyAuto,
+		Timeout: 2 * time.Minute,
+		Tools:   map[string]string{},
+	}
+}
+
+// DefaultQueryRewriteConfig returns the parameters of the query rewriting used when they are not set (the query rewriting is disabled).
+func DefaultQueryRewriteConfig() QueryRewriteConfig {
+	return QueryRewriteConfig{MaxQueries: 1}
+}
+
+// DefaultContextConfig returns the parameters of the context used when they are not set: no budget, same weight for the docs and the web.
+func DefaultContextConfig() ContextConfig {
+	return ContextConfig{DocsWeight: 1, WebWeight: 1}
+}
+
+// DefaultAgentLoopConfig returns the parameters of the agent loop used when they are not set (the agent loop is disabled).
+func DefaultAgentLoopConfig() AgentLoopConfig {
+	return AgentLoopConfig{
+		MaxSteps:      4,
+		Tools:         []string{"search_docs", "ask_clone", "mcp"},
+		MaxCloneDepth: 1,
+	}
+}
+
+// DefaultStartupConfig returns the parameters of the initialization of the agents used when they are not set.
+func DefaultStartupConfig() StartupConfig {
+	return StartupConfig{
+		EmbeddingParallelism: 4,
+		RetryInterval:        30 * time.Second,
+	}
+}
+
+// DefaultShutdownConfig returns the parameters of the graceful shutdown used when they are not set.
+func DefaultShutdownConfig() ShutdownConfig {
+	return ShutdownConfig{DrainTimeout: 25 * time.Second, StateFile: "data/state.json"}
+}
+
+// DefaultSessionsConfig returns the limits of the sessions used when they are not set.
+func DefaultSessionsConfig() SessionsConfig {
+	return SessionsConfig{TTL: 24 * time.Hour, MaxSessions: 1000}
+}
+
+// merge sets the MCP parameters of the file: the servers, the tools and the maps of the file replace the default ones.
+func (mcp *MCPConfig) merge(file MCPConfig) {
+	if len(file.Servers) > 0 {
+		mcp.Servers = file.Servers
+	}
+	if file.DefaultTools != nil {
+		mcp.DefaultTools = file.DefaultTools
+	}
+	if file.HealthCheckInterval != 0 {
+		mcp.HealthCheckInterval = file.HealthCheckInterval
+	}
+	if file.ConnectTimeout != 0 {
+		mcp.ConnectTimeout = file.ConnectTimeout
+	}
+	mcp.WebSearch = file.WebSearch
+	mcp.Cache.Disabled = file.Cache.Disabled
+	if file.Cache.TTL != 0 {
+		mcp.Cache.TTL = file.Cache.TTL
+	}
+	if file.Cache.MaxEntries != 0 {
+		mcp.Cache.MaxEntries = file.Cache.MaxEntries
+	}
+	if file.Cache.MaxBytes != 0 {
+		mcp.Cache.MaxBytes = file.Cache.MaxBytes
+	}
+	if file.Cache.Tools != nil {
+		mcp.Cache.Tools = file.Cache.Tools
+	}
+	if file.Results.MaxResults != 0 {
+		mcp.Results.MaxResults = file.Results.MaxResults
+	}
+	if file.Results.MaxTokens != 0 {
+		mcp.Results.MaxTokens = file.Results.MaxTokens
+	}
+	mcp.Results.Summarize = file.Results.Summarize
+}
+
+func (mcp MCPConfig) validate() error {
+	for serverName, server := range mcp.Servers {
+		switch {
+		case server.Transport == "stdio" && len(server.Command) == 0:
+			return fmt.Errorf("servers.%s: the stdio transport needs a command", serverName)
+		case server.Transport == "socat" && server.Address == "":
+			return fmt.Errorf("servers.%s: the socat transport needs an address", serverName)
+		case (server.Transport == "http" || server.Transport == "sse") && server.URL == "":
+			return fmt.Errorf("servers.%s: the %s transport needs an url", serverName, server.Transport)
+		case !slices.Contains([]string{"stdio", "socat", "http", "sse"}, server.Transport):
+			return fmt.Errorf("servers.%s: unknown transport %q", serverName, server.Transport)
+		}
+	}
+	switch {
+	case mcp.HealthCheckInterval < 0:
+		return fmt.Errorf("health_check_interval must not be negative (%s)", mcp.HealthCheckInterval)
+	case mcp.ConnectTimeout < 0:
+		return fmt.Errorf("connect_timeout must not be negative (%s)", mcp.ConnectTimeout)
+	case mcp.Cache.TTL < 0 || mcp.Cache.MaxEntries < 0 || mcp.Cache.MaxBytes < 0:
+		return errors.New("cache: ttl, max_entries and max_bytes must not be negative")
+	case mcp.Results.MaxResults < 0 || mcp.Results.MaxTokens < 0:
+		return errors.New("results: max_results and max_tokens must not be negative")
+	}
+	return nil
+}
+
+// merge sets the approval policies of the file: the policies of the tools are added to the default ones.
+func (approval *ApprovalConfig) merge(file ApprovalConfig) {
+	if file.Default != "" {
+		approval.Default = file.Default
+	}
+	if file.Timeout != 0 {
+		approval.Timeout = file.Timeout
+	}
+	for pattern, policy := range file.Tools {
+		approval.Tools[pattern] = policy
+	}
+}
+
+func (approval ApprovalConfig) validate() error {
+	policies := []string{PolicyAuto, PolicyAsk, PolicyDeny}
+	if !slices.Contains(policies, approval.Default) {
+		return fmt.Errorf("unknown default policy %q (auto, ask or deny)", approval.Default)
+	}
+	for pattern, policy := range approval.Tools {
+		if !slices.Contains(policies, policy) {
+			return fmt.Errorf("tools.%s: unknown policy %q (auto, ask or deny)", pattern, policy)
+		}
+	}
+	if approval.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative (%s)", approval.Timeout)
+	}
+	return nil
+}
+
+func (validation ValidationConfig) validate() error {
+	if validation.Retries < 0 {
+		return fmt.Errorf("retries must not be negative (%d)", validation.Retries)
+	}
+	return nil
+}
+
+// merge sets the parameters of the query rewriting of the file.
+func (queryRewrite *QueryRewriteConfig) merge(file QueryRewriteConfig) {
+	queryRewrite.Enabled = file.Enabled
+	if file.MaxQueries != 0 {
+		queryRewrite.MaxQueries = file.MaxQueries
+	}
+}
+
+func (queryRewrite QueryRewriteConfig) validate() error {
+	if queryRewrite.MaxQueries < 1 {
+		return fmt.Errorf("max_queries must be positive (%d)", queryRewrite.MaxQueries)
+	}
+	return nil
+}
+
+// merge sets the parameters of the context of the file (max_tokens: 0 is no limit).
+func (context *ContextConfig) merge(file ContextConfig) {
+	context.MaxTokens = file.MaxTokens
+	if file.DocsWeight != 0 {
+		context.DocsWeight = file.DocsWeight
+	}
+	if file.WebWeight != 0 {
+		context.WebWeight = file.WebWeight
+	}
+}
+
+func (context ContextConfig) validate() error {
+	switch {
+	case context.MaxTokens < 0:
+		return fmt.Errorf("max_tokens must not be negative (%d)", context.MaxTokens)
+	case context.DocsWeight < 0 || context.WebWeight < 0:
+		return errors.New("docs_weight and web_weight must not be negative")
+	}
+	return nil
+}
+
+// merge sets the parameters of the agent loop of the file.
+func (agentLoop *AgentLoopConfig) merge(file AgentLoopConfig) {
+	agentLoop.Enabled = file.Enabled
+	if file.MaxSteps != 0 {
+		agentLoop.MaxSteps = file.MaxSteps
+	}
+	if file.Tools != nil {
+		agentLoop.Tools = file.Tools
+	}
+	if file.MaxCloneDepth != 0 {
+		agentLoop.MaxCloneDepth = file.MaxCloneDepth
+	}
+}
+
+func (agentLoop AgentLoopConfig) validate() error {
+	switch {
+	case agentLoop.MaxSteps < 0:
+		return fmt.Errorf("max_steps must not be negative (%d)", agentLoop.MaxSteps)
+	case agentLoop.MaxCloneDepth < 0:
+		return fmt.Errorf("max_clone_depth must not be negative (%d)", agentLoop.MaxCloneDepth)
+	}
+	for _, tool := range agentLoop.Tools {
+		if !slices.Contains([]string{"search_docs", "ask_clone", "mcp"}, tool) {
+			return fmt.Errorf("unknown tool %q (search_docs, ask_clone or mcp)", tool)
+		}
+	}
+	return nil
+}
+
+// merge sets the parameters of the initialization of the agents of the file.
+func (startup *StartupConfig) merge(file StartupConfig) {
+	if file.EmbeddingParallelism != 0 {
+		startup.EmbeddingParallelism = file.EmbeddingParallelism
+	}
+	if file.RetryInterval != 0 {
+		startup.RetryInterval = file.RetryInterval
+	}
+}
+
+func (startup StartupConfig) validate() error {
+	switch {
+	case startup.EmbeddingParallelism < 1:
+		return fmt.Errorf("embedding_parallelism must be positive (%d)", startup.EmbeddingParallelism)
+	case startup.RetryInterval <= 0:
+		return fmt.Errorf("retry_interval must be positive (%s)", startup.RetryInterval)
+	}
+	return nil
+}
+
+// merge sets the parameters of the graceful shutdown of the file.
+func (shutdown *ShutdownConfig) merge(file ShutdownConfig) {
+	if file.DrainTimeout != 0 {
+		shutdown.DrainTimeout = file.DrainTimeout
+	}
+	if file.StateFile != "" {
+		shutdown.StateFile = file.StateFile
+	}
+}
+
+func (shutdown ShutdownConfig) validate() error {
+	if shutdown.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout must not be negative (%s)", shutdown.DrainTimeout)
+	}
+	return nil
+}
+
+// merge sets the limits of the sessions of the file.
+func (sessions *SessionsConfig) merge(file SessionsConfig) {
+	if file.TTL != 0 {
+		sessions.TTL = file.TTL
+	}
+	if file.MaxSessions != 0 {
+		sessions.MaxSessions = file.MaxSessions
+	}
+}
+
+func (sessions SessionsConfig) validate() error {
+	switch {
+	case sessions.TTL < 0:
+		return fmt.Errorf("ttl must not be negative (%s)", sessions.TTL)
+	case sessions.MaxSessions < 0:
+		return fmt.Errorf("max_sessions must not be negative (%d)", sessions.MaxSessions)
+	}
+	return nil
+}
+
+// validate checks every section of the configuration, and the retrieval parameters of every agent once merged with the default ones.
+func (cfg Config) validate() error {
+	sections := []struct {
+		name     string
+		validate func() error
+	}{
+		{"retrieval", cfg.Retrieval.Validate},
+		{"mcp", cfg.MCP.validate},
+		{"approval", cfg.Approval.validate},
+		{"validation", cfg.Validation.validate},
+		{"query_rewrite", cfg.QueryRewrite.validate},
+		{"context", cfg.Context.validate},
+		{"agent_loop", cfg.AgentLoop.validate},
+		{"startup", cfg.Startup.validate},
+		{"shutdown", cfg.Shutdown.validate},
+		{"sessions", cfg.Sessions.validate},
+	}
+	for _, section := range sections {
+		if err := section.validate(); err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+	}
+	for agentName, settings := range cfg.Agents {
+		if err := cfg.Retrieval.Merge(settings.Retrieval).Validate(); err != nil {
+			return fmt.Errorf("agents.%s.retrieval: %w", agentName, err)
+		}
+	}
+	return nil
+}
+
+var (
+	currentConfig Config
+	loadError     error
+	loadOnce      sync.Once
+)
+
+// Path returns the path of the configuration file (CONFIG_PATH environment variable, default: config.yml).
+func Path() string {
+	path := os.Getenv("CONFIG_PATH")
+	if path == "" {
+		path = "config.yml"
+	}
+	return path
+}
+
+// Load reads the configuration file. A missing file is not an error: the default values are used.
+// Every section of the file is merged with its default values, then validated:
+// an invalid configuration is an error (the backend does not start, see Init).
+func Load(path string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("error reading the configuration: %w", err)
+	}
+	// NOTE: the retrieval parameters are decoded over the default ones, a zero value in the file is a value (ex: top_k: 0)
+	fileConfig := Config{Retrieval: DefaultRetrievalConfig()}
+	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
+		return cfg, fmt.Errorf("error parsing the configuration: %w", err)
+	}
+	cfg.Retrieval = fileConfig.Retrieval
+	cfg.MCP.merge(fileConfig.MCP)
+	cfg.Approval.merge(fileConfig.Approval)
+	cfg.Validation = fileConfig.Validation
+	cfg.QueryRewrite.merge(fileConfig.QueryRewrite)
+	cfg.Context.merge(fileConfig.Context)
+	cfg.AgentLoop.merge(fileConfig.AgentLoop)
+	cfg.Startup.merge(fileConfig.Startup)
+	cfg.Shutdown.merge(fileConfig.Shutdown)
+	cfg.Sessions.merge(fileConfig.Sessions)
+	for agentName, settings := range fileConfig.Agents {
+		cfg.Agents[agentName] = settings
+	}
+	if err := cfg.validate(); err != nil {
+		return DefaultConfig(), fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+func load() {
+	currentConfig, loadError = Load(Path())
+}
+
+// Init loads the configuration, it must be called at startup: an unreadable or invalid configuration file is an error.
+func Init() error {
+	loadOnce.Do(load)
+	if loadError != nil {
+		return loadError
+	}
+	slog.Info("⚙️ configuration loaded", "path", Path())
+	return nil
+}
+
+// Get returns the configuration, it is loaded on the first call (see Init).
+func Get() Config {
+	loadOnce.Do(load)
+	return currentConfig
+}
+
+// GetRetrievalConfig returns the retrieval parameters of an agent (the defaults merged with the agent settings).
+func GetRetrievalConfig(agentName string) RetrievalConfig {
+	cfg := Get()
+	return cfg.Retrieval.Merge(cfg.Agents[agentName].Retrieval)
+}
+
+// GetMCPTools returns the allowlist of the MCP tools of an agent (the default tools if the agent has no allowlist).
+func GetMCPTools(agentName string) []string {
+	cfg := Get()
+	if tools := cfg.Agents[agentName].MCPTools; tools != nil {
+		return tools
+	}
+	return cfg.MCP.DefaultTools
+}
+
+// GetToolPolicy returns the approval policy of a tool of a server:
+// the policy of server/tool, then of the tool name, then of server/*, then the default policy.
+func GetToolPolicy(serverName string, toolName string) string {
+	approval := Get().Approval
+	for _, key := range []string{serverName + "/" + toolName, toolName, serverName + "/*"} {
+		if policy, ok := approval.Tools[key]; ok {
+			return policy
+		}
+	}
+	if approval.Default == "" {
+		return PolicyAuto
+	}
+	return approval.Default
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetrievalMerge(t *testing.T) {
+	zero, four, threshold := 0, 4, 0.5
+	base := RetrievalConfig{SimilarityThreshold: 0.7, ChunkSize: 512, ChunkOverlap: 210, TopK: 8, MaxContextTokens: 1000}
+	tests := []struct {
+		name     string
+		override RetrievalOverride
+		want     RetrievalConfig
+	}{
+		{"nothing set", RetrievalOverride{}, base},
+		{"zero is a value", RetrievalOverride{TopK: &zero, MaxContextTokens: &zero},
+			RetrievalConfig{SimilarityThreshold: 0.7, ChunkSize: 512, ChunkOverlap: 210}},
+		{"values set", RetrievalOverride{SimilarityThreshold: &threshold, TopK: &four},
+			RetrievalConfig{SimilarityThreshold: 0.5, ChunkSize: 512, ChunkOverlap: 210, TopK: 4, MaxContextTokens: 1000}},
+	}
+	for _, test := range tests {
+		if got := base.Merge(test.override); got != test.want {
+			t.Errorf("%s: Merge() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRetrievalValidate(t *testing.T) {
+	valid := DefaultRetrievalConfig()
+	tests := []struct {
+		name      string
+		retrieval func(retrieval RetrievalConfig) RetrievalConfig
+		wantError string
+	}{
+		{"default", func(r RetrievalConfig) RetrievalConfig { return r }, ""},
+		{"threshold of 0 and 1", func(r RetrievalConfig) RetrievalConfig { r.SimilarityThreshold = 1; return r }, ""},
+		{"threshold above 1", func(r RetrievalConfig) RetrievalConfig { r.SimilarityThreshold = 1.2; return r }, "similarity_threshold"},
+		{"negative threshold", func(r RetrievalConfig) RetrievalConfig { r.SimilarityThreshold = -0.1; return r }, "similarity_threshold"},
+		{"zero chunk size", func(r RetrievalConfig) RetrievalConfig { r.ChunkSize = 0; return r }, "chunk_size"},
+		{"overlap as big as the chunks", func(r RetrievalConfig) RetrievalConfig { r.ChunkOverlap = r.ChunkSize; return r }, "chunk_overlap"},
+		{"negative top_k", func(r RetrievalConfig) RetrievalConfig { r.TopK = -1; return r }, "top_k"},
+		{"negative max_context_tokens", func(r RetrievalConfig) RetrievalConfig { r.MaxContextTokens = -1; return r }, "max_context_tokens"},
+	}
+	for _, test := range tests {
+		err := test.retrieval(valid).Validate()
+		checkError(t, test.name, err, test.wantError)
+	}
+}
+
+func TestRetrievalValidateRequest(t *testing.T) {
+	size, threshold, topK := 256, 0.5, 3
+	tests := []struct {
+		name      string
+		override  RetrievalOverride
+		wantError string
+	}{
+		{"nothing set", RetrievalOverride{}, ""},
+		{"parameters of the search", RetrievalOverride{SimilarityThreshold: &threshold, TopK: &topK, MaxContextTokens: &topK}, ""},
+		{"chunk size", RetrievalOverride{TopK: &topK, ChunkSize: &size}, "chunk_size and chunk_overlap"},
+		{"chunk overlap", RetrievalOverride{ChunkOverlap: &size}, "chunk_size and chunk_overlap"},
+	}
+	for _, test := range tests {
+		checkError(t, test.name, test.override.ValidateRequest(), test.wantError)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		check     func(t *testing.T, cfg Config)
+		wantError string
+	}{
+		{
+			name: "empty file",
+			yaml: "",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Retrieval != DefaultRetrievalConfig() || cfg.Startup != DefaultStartupConfig() {
+					t.Errorf("the default values are not kept: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "partial sections",
+			yaml: "retrieval:\n  top_k: 3\n  similarity_threshold: 0\nmcp:\n  health_check_interval: 5s\nshutdown:\n  drain_timeout: 1s\n",
+			check: func(t *testing.T, cfg Config) {
+				want := RetrievalConfig{ChunkSize: 512, ChunkOverlap: 210, TopK: 3}
+				if cfg.Retrieval != want {
+					t.Errorf("Retrieval = %+v, want %+v", cfg.Retrieval, want)
+				}
+				if cfg.MCP.HealthCheckInterval != 5*time.Second || len(cfg.MCP.Servers) != 1 {
+					t.Errorf("MCP = %+v", cfg.MCP)
+				}
+				if cfg.Shutdown.DrainTimeout != time.Second || cfg.Startup != DefaultStartupConfig() {
+					t.Errorf("Shutdown = %+v, Startup = %+v", cfg.Shutdown, cfg.Startup)
+				}
+			},
+		},
+		{
+			name: "agent overrides",
+			yaml: "agents:\n  garfield:\n    retrieval:\n      top_k: 0\n      chunk_size: 384\n",
+			check: func(t *testing.T, cfg Config) {
+				retrieval := cfg.Retrieval.Merge(cfg.Agents["garfield"].Retrieval)
+				if retrieval.TopK != 0 || retrieval.ChunkSize != 384 || retrieval.ChunkOverlap != 210 {
+					t.Errorf("retrieval of garfield = %+v", retrieval)
+				}
+			},
+		},
+		{
+			name: "approval policies are added to the default ones",
+			yaml: "approval:\n  tools:\n    filesystem/*: ask\n",
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Approval.Default != PolicyAuto || cfg.Approval.Tools["filesystem/*"] != PolicyAsk {
+					t.Errorf("Approval = %+v", cfg.Approval)
+				}
+			},
+		},
+		{
+			name: "query rewriting",
+			yaml: "query_rewrite:\n  enabled: true\n",
+			check: func(t *testing.T, cfg Config) {
+				if want := (QueryRewriteConfig{Enabled: true, MaxQueries: 1}); cfg.QueryRewrite != want {
+					t.Errorf("QueryRewrite = %+v, want %+v", cfg.QueryRewrite, want)
+				}
+			},
+		},
+		{name: "negative max queries", yaml: "query_rewrite:\n  max_queries: -1\n", wantError: "query_rewrite: max_queries"},
+		{name: "invalid threshold", yaml: "retrieval:\n  similarity_threshold: 1.5\n", wantError: "retrieval: similarity_threshold"},
+		{name: "invalid agent retrieval", yaml: "agents:\n  bob:\n    retrieval:\n      top_k: -1\n", wantError: "agents.bob.retrieval: top_k"},
+		{name: "unknown transport", yaml: "mcp:\n  servers:\n    stub:\n      transport: grpc\n", wantError: "unknown transport"},
+		{name: "stdio without command", yaml: "mcp:\n  servers:\n    stub:\n      transport: stdio\n", wantError: "needs a command"},
+		{name: "negative retries", yaml: "validation:\n  retries: -1\n", wantError: "validation: retries"},
+		{name: "unknown agent loop tool", yaml: "agent_loop:\n  tools: [search_web]\n", wantError: "unknown tool"},
+		{name: "negative embedding parallelism", yaml: "startup:\n  embedding_parallelism: -2\n", wantError: "embedding_parallelism"},
+		{name: "unknown default policy", yaml: "approval:\n  default: never\n", wantError: "unknown default policy"},
+		{name: "unknown tool policy", yaml: "approval:\n  tools:\n    crash: Deny\n", wantError: "tools.crash: unknown policy"},
+		{name: "invalid YAML", yaml: "retrieval: [", wantError: "error parsing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			if err := os.WriteFile(path, []byte(test.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			checkError(t, test.name, err, test.wantError)
+			if err == nil && test.check != nil {
+				test.check(t, cfg)
+			}
+		})
+	}
+}
+
+// NOTE: the configuration of the repository must be valid
+func TestLoadConfigFile(t *testing.T) {
+	if _, err := Load("../config.yml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Retrieval != DefaultRetrievalConfig() {
+		t.Errorf("Retrieval = %+v, want the default values", cfg.Retrieval)
+	}
+}
+
+func checkError(t *testing.T, name string, err error, wantError string) {
+	t.Helper()
+	switch {
+	case wantError == "" && err != nil:
+		t.Errorf("%s: unexpected error: %v", name, err)
+	case wantError != "" && err == nil:
+		t.Errorf("%s: expected an error containing %q", name, wantError)
+	case wantError != "" && !strings.Contains(err.Error(), wantError):
+		t.Errorf("%s: error %q does not contain %q", name, err, wantError)
+	}
+}
@@ -0,0 +1,296 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+	"gopkg.in/yaml.v3"
+)
+
+// AnswerCase is a prompt of the answers evaluation dataset.
+// The cases are replayed in order through the /chat endpoint, every case is a new conversation
+// except the cases with the same session id, which are parts of the same conversation (see SessionID).
+type AnswerCase struct {
+	Name          string   `yaml:"name" json:"name"`
+	Prompt        string   `yaml:"prompt" json:"prompt"`
+	SessionID     string   `yaml:"session_id,omitempty" json:"session_id,omitempty"`
+	ExpectedClone string   `yaml:"expected_clone,omitempty" json:"expected_clone,omitempty"`
+	Keywords      []string `yaml:"keywords,omitempty" json:"keywords,omitempty"`
+	Regex         []string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	JudgeCriteria string   `yaml:"judge_criteria,omitempty" json:"judge_criteria,omitempty"`
+	MinJudgeScore int      `yaml:"min_judge_score,omitempty" json:"min_judge_score,omitempty"`
+}
+
+type AnswersDataset struct {
+	Cases []AnswerCase `yaml:"cases"`
+}
+
+// AnswerResult is the result of the evaluation of one case.
+type AnswerResult struct {
+	AnswerCase
+	Clone           string        `json:"clone"`
+	Answer          string        `json:"answer"`
+	Labels          []string      `json:"labels"`
+	Duration        time.Duration `json:"duration_ns"`
+	MissingKeywords []string      `json:"missing_keywords,omitempty"`
+	FailedRegex     []string      `json:"failed_regex,omitempty"`
+	JudgeScore      int           `json:"judge_score,omitempty"`
+	JudgeReason     string        `json:"judge_reason,omitempty"`
+	Failures        []string      `json:"failures,omitempty"`
+	Passed          bool          `json:"passed"`
+}
+
+// AnswersReport is the result of the answers evaluation.
+// The models are recorded to compare the reports between model or prompt changes.
+type AnswersReport struct {
+	Date    time.Time         `json:"date"`
+	URL     string            `json:"url"`
+	Models  map[string]string `json:"models"`
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Results []AnswerResult    `json:"results"`
+}
+
+func LoadAnswersDataset(path string) (AnswersDataset, error) {
+	var dataset AnswersDataset
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dataset, fmt.Errorf("error reading the answers dataset: %w", err)
+	}
+	err = yaml.Unmarshal(data, &dataset)
+	if err != nil {
+		return dataset, fmt.Errorf("error parsing the answers dataset: %w", err)
+	}
+	return dataset, nil
+}
+
+var (
+	labelRegex           = regexp.MustCompile(`(?s)<(\w+)>(.*?)</\w+>(<br>)?`)
+	generatingCloneRegex = regexp.MustCompile(`Generating response with (\w+)`)
+)
+
+// ParseChatStream splits the stream of the /chat endpoint into the labels (<info>...</info>)
+// and the answer of the clone, and extracts the name of the clone which generated the answer.
+func ParseChatStream(stream string) (clone string, answer string, labels []string) {
+	for _, match := range labelRegex.FindAllStringSubmatch(stream, -1) {
+		labels = append(labels, match[1]+": "+match[2])
+		if cloneMatch := generatingCloneRegex.FindStringSubmatch(match[2]); cloneMatch != nil {
+			clone = cloneMatch[1]
+		}
+	}
+	answer = strings.TrimSpace(labelRegex.ReplaceAllString(stream, ""))
+	return clone, answer, labels
+}
+
+// NewRunID returns a random id of an evaluation run, the prefix of its session ids.
+func NewRunID() string {
+	data := make([]byte, 4)
+	rand.Read(data)
+	return "eval-" + hex.EncodeToString(data)
+}
+
+// SessionID returns the session id of a case for an evaluation run: the sessions are unique per run,
+// and per case unless the case continues a conversation (the cases with the same session id share their session).
+func SessionID(runID string, index int, answerCase AnswerCase) string {
+	if answerCase.SessionID != "" {
+		return runID + "-" + answerCase.SessionID
+	}
+	return fmt.Sprintf("%s-case-%d", runID, index+1)
+}
+
+// Chat sends a prompt to the /chat endpoint and returns the whole stream.
+func Chat(url string, prompt string, sessionID string) (string, error) {
+	body, err := json.Marshal(map[string]string{"message": prompt, "sessionId": sessionID})
+	if err != nil {
+		return "", err
+	}
+	response, err := http.Post(url+"/chat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", response.Status)
+	}
+	stream, err := io.ReadAll(response.Body)
+	return string(stream), err
+}
+
+// GetJudge creates the agent used as LLM-as-judge.
+func GetJudge(model string) (*robby.Agent, error) {
+	modelRunnerURL := os.Getenv("DMR_BASE_URL") + "/engines/llama.cpp/v1"
+
+	return robby.NewAgent(
+		robby.WithDMRClient(
+			context.Background(),
+			modelRunnerURL,
+		),
+		robby.WithParams(
+			openai.ChatCompletionNewParams{
+				Model:       model,
+				Messages:    []openai.ChatCompletionMessageParamUnion{},
+				Temperature: openai.Opt(0.0),
+			},
+		),
+	)
+}
+
+var judgeScoreRegex = regexp.MustCompile(`SCORE:\s*([1-5])`)
+
+// Judge asks the judge agent to score the answer from 1 to 5 according to the criteria.
+func Judge(judge *robby.Agent, answerCase AnswerCase, answer string) (int, string, error) {
+	judge.Params.Messages = []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(`
+		You are evaluating the answer of an assistant.
+		Score the answer from 1 (bad) to 5 (excellent) according to the criteria.
+		Explain your score in one sentence, then write the score on the last line like this: SCORE: <score>
+		`),
+		openai.UserMessage(
+			"Question:\n" + answerCase.Prompt +
+				"\n\nCriteria:\n" + answerCase.JudgeCriteria +
+				"\n\nAnswer:\n" + answer,
+		),
+	}
+	verdict, err := judge.ChatCompletion()
+	if err != nil {
+		return 0, "", err
+	}
+	match := judgeScoreRegex.FindStringSubmatch(verdict)
+	if match == nil {
+		return 0, verdict, fmt.Errorf("no score found in the verdict")
+	}
+	score, _ := strconv.Atoi(match[1])
+	reason := strings.TrimSpace(judgeScoreRegex.ReplaceAllString(verdict, ""))
+	return score, reason, nil
+}
+
+// RunAnswersEvaluation replays the cases of the dataset through the /chat endpoint
+// and checks the selected clone, the keywords, the regular expressions and (optionally) the judge score.
+func RunAnswersEvaluation(url string, dataset AnswersDataset, judge *robby.Agent) AnswersReport {
+	report := AnswersReport{
+		Date: time.Now(),
+		URL:  url,
+		Models: map[string]string{
+			"bob":       os.Getenv("MODEL_RUNNER_CHAT_MODEL_BOB"),
+			"bill":      os.Getenv("MODEL_RUNNER_CHAT_MODEL_BILL"),
+			"garfield":  os.Getenv("MODEL_RUNNER_CHAT_MODEL_GARFIELD"),
+			"milo":      os.Getenv("MODEL_RUNNER_CHAT_MODEL_MILO"),
+			"tools":     os.Getenv("MODEL_RUNNER_TOOLS_MODEL"),
+			"embedding": os.Getenv("MODEL_RUNNER_EMBEDDING_MODEL"),
+		},
+	}
+	if judge != nil {
+		report.Models["judge"] = judge.Params.Model
+	}
+
+	// NOTE: the conversations of a run do not continue the conversations of the previous runs
+	runID := NewRunID()
+	for index, answerCase := range dataset.Cases {
+		result := AnswerResult{AnswerCase: answerCase}
+		fmt.Println("🧪", answerCase.Name)
+
+		start := time.Now()
+		stream, err := Chat(url, answerCase.Prompt, SessionID(runID, index, answerCase))
+		result.Duration = time.Since(start)
+		if err != nil {
+			result.Failures = append(result.Failures, "chat error: "+err.Error())
+			report.Failed++
+			report.Results = append(report.Results, result)
+			continue
+		}
+		result.Clone, result.Answer, result.Labels = ParseChatStream(stream)
+
+		if answerCase.ExpectedClone != "" && !strings.EqualFold(result.Clone, answerCase.ExpectedClone) {
+			result.Failures = append(result.Failures, fmt.Sprintf("expected clone %s, got %s", answerCase.ExpectedClone, result.Clone))
+		}
+
+		for _, keyword := range answerCase.Keywords {
+			if !strings.Contains(strings.ToLower(result.Answer), strings.ToLower(keyword)) {
+				result.MissingKeywords = append(result.MissingKeywords, keyword)
+			}
+		}
+		if len(result.MissingKeywords) > 0 {
+			result.Failures = append(result.Failures, "missing keywords: "+strings.Join(result.MissingKeywords, ", "))
+		}
+
+		for _, expression := range answerCase.Regex {
+			matched, err := regexp.MatchString(expression, result.Answer)
+			if err != nil || !matched {
+				result.FailedRegex = append(result.FailedRegex, expression)
+			}
+		}
+		if len(result.FailedRegex) > 0 {
+			result.Failures = append(result.Failures, "failed regex: "+strings.Join(result.FailedRegex, ", "))
+		}
+
+		if judge != nil && answerCase.JudgeCriteria != "" {
+			result.JudgeScore, result.JudgeReason, err = Judge(judge, answerCase, result.Answer)
+			if err != nil {
+				result.Failures = append(result.Failures, "judge error: "+err.Error())
+			} else if answerCase.MinJudgeScore > 0 && result.JudgeScore < answerCase.MinJudgeScore {
+				result.Failures = append(result.Failures, fmt.Sprintf("judge score %d < %d", result.JudgeScore, answerCase.MinJudgeScore))
+			}
+		}
+
+		result.Passed = len(result.Failures) == 0
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Markdown renders the report as a Markdown document.
+func (report AnswersReport) Markdown() string {
+	var markdown strings.Builder
+	markdown.WriteString("# Answers evaluation\n\n")
+	markdown.WriteString("- Date: " + report.Date.Format(time.RFC3339) + "\n")
+	markdown.WriteString("- URL: " + report.URL + "\n")
+	for _, name := range []string{"bob", "bill", "garfield", "milo", "tools", "embedding", "judge"} {
+		if model, ok := report.Models[name]; ok {
+			markdown.WriteString("- Model " + name + ": `" + model + "`\n")
+		}
+	}
+	fmt.Fprintf(&markdown, "- Passed: %d / %d\n\n", report.Passed, report.Passed+report.Failed)
+
+	markdown.WriteString("| Case | Expected clone | Clone | Judge | Duration | Result |\n")
+	markdown.WriteString("|------|----------------|-------|-------|----------|--------|\n")
+	for _, result := range report.Results {
+		status := "✅"
+		if !result.Passed {
+			status = "❌ " + strings.Join(result.Failures, "; ")
+		}
+		judgeScore := ""
+		if result.JudgeScore > 0 {
+			judgeScore = strconv.Itoa(result.JudgeScore)
+		}
+		fmt.Fprintf(&markdown, "| %s | %s | %s | %s | %.1fs | %s |\n",
+			result.Name, result.ExpectedClone, result.Clone, judgeScore, result.Duration.Seconds(), status)
+	}
+
+	for _, result := range report.Results {
+		markdown.WriteString("\n## " + result.Name + "\n\n")
+		markdown.WriteString("**Prompt:** " + result.Prompt + "\n\n")
+		if result.JudgeReason != "" {
+			markdown.WriteString("**Judge:** " + result.JudgeReason + "\n\n")
+		}
+		markdown.WriteString("**Answer:**\n\n" + result.Answer + "\n")
+	}
+	return markdown.String()
+}
@@ -0,0 +1,101 @@
+package eval
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// capturedStream is a stream of the /chat endpoint (a web search with Garfield), the answer is shortened.
+const capturedStream = `<step>Detecting the tool calls (Riker, Khan)...</step>` +
+	`<yellow>Selecting Bob clone...</yellow>` +
+	`<enhancement>Hey, it's Garfield, ai/qwen2.5:latest</enhancement>` +
+	`<step>Searching similarities...</step>` +
+	`<white>3 documents found</white>` +
+	`<info>2 web sources, ~150 tokens</info>` +
+	`<info>Generating response with Garfield...</info><br>` +
+	"To pull a model, use:\n\n```bash\ndocker model pull ai/smollm2\n```\n" +
+	"Then `docker model list` shows it." +
+	"\n\n**Sources:**\n1. [Docker Model Runner](https://docs.docker.com/ai/model-runner/)\n" +
+	`<usage>1234 tokens (1100 prompt + 134 completion)</usage>`
+
+func TestParseChatStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		stream     string
+		wantClone  string
+		wantAnswer string
+		wantLabels []string
+	}{
+		{"captured stream", capturedStream, "Garfield",
+			"To pull a model, use:\n\n```bash\ndocker model pull ai/smollm2\n```\nThen `docker model list` shows it." +
+				"\n\n**Sources:**\n1. [Docker Model Runner](https://docs.docker.com/ai/model-runner/)",
+			[]string{
+				"step: Detecting the tool calls (Riker, Khan)...",
+				"yellow: Selecting Bob clone...",
+				"enhancement: Hey, it's Garfield, ai/qwen2.5:latest",
+				"step: Searching similarities...",
+				"white: 3 documents found",
+				"info: 2 web sources, ~150 tokens",
+				"info: Generating response with Garfield...",
+				"usage: 1234 tokens (1100 prompt + 134 completion)",
+			}},
+		{"multi-line label", "<error>Query rewriting failed:\ntimeout</error><info>Generating response with Bob...</info><br>Hello",
+			"Bob", "Hello", []string{"error: Query rewriting failed:\ntimeout", "info: Generating response with Bob..."}},
+		{"warming up", "<warning>Bob is warming up, please retry in a few seconds</warning>",
+			"", "", []string{"warning: Bob is warming up, please retry in a few seconds"}},
+		{"no labels", "  just an answer\n", "", "just an answer", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clone, answer, labels := ParseChatStream(test.stream)
+			if clone != test.wantClone {
+				t.Errorf("clone = %q, want %q", clone, test.wantClone)
+			}
+			if answer != test.wantAnswer {
+				t.Errorf("answer = %q, want %q", answer, test.wantAnswer)
+			}
+			if !slices.Equal(labels, test.wantLabels) {
+				t.Errorf("labels = %q, want %q", labels, test.wantLabels)
+			}
+		})
+	}
+}
+
+func TestSessionID(t *testing.T) {
+	cases := []AnswerCase{
+		{Name: "first"},
+		{Name: "topic", SessionID: "model-runner"},
+		{Name: "follow-up", SessionID: "model-runner"},
+		{Name: "first"},
+	}
+	firstRun, secondRun := NewRunID(), NewRunID()
+	if firstRun == secondRun || !strings.HasPrefix(firstRun, "eval-") {
+		t.Fatalf("run ids = %q and %q, want unique ids", firstRun, secondRun)
+	}
+	sessions := []string{}
+	for index, answerCase := range cases {
+		sessions = append(sessions, SessionID(firstRun, index, answerCase))
+	}
+	tests := []struct {
+		name   string
+		first  int
+		second int
+		shared bool
+	}{
+		{"cases without session id", 0, 3, false},
+		{"case without session id and conversation", 0, 1, false},
+		{"follow-up", 1, 2, true},
+	}
+	for _, test := range tests {
+		if shared := sessions[test.first] == sessions[test.second]; shared != test.shared {
+			t.Errorf("%s: sessions %q and %q, shared = %v, want %v", test.name, sessions[test.first], sessions[test.second], shared, test.shared)
+		}
+	}
+	// NOTE: the conversations of the next run are new sessions
+	for index, answerCase := range cases {
+		if session := SessionID(secondRun, index, answerCase); slices.Contains(sessions, session) {
+			t.Errorf("the session %q of the second run is a session of the first run", session)
+		}
+	}
+}
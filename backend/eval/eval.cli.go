@@ -0,0 +1,130 @@
+package eval
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"we-are-legion/config"
+	"we-are-legion/rag"
+	"we-are-legion/workflow"
+
+	"github.com/sea-monkeys/robby"
+)
+
+/*
+Run executes the evaluation sub-commands:
+  - eval rag: evaluates the retrieval of the RAG memories of the clones.
+  - eval answers: replays a dataset of prompts through the /chat endpoint and checks the answers.
+*/
+func Run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: eval rag|answers [options]")
+	}
+	switch args[0] {
+	case "rag":
+		return runRAG(args[1:])
+	case "answers":
+		return runAnswers(args[1:])
+	default:
+		return fmt.Errorf("unknown evaluation: %s", args[0])
+	}
+}
+
+func runRAG(args []string) error {
+	flags := flag.NewFlagSet("eval rag", flag.ContinueOnError)
+	datasetPath := flags.String("dataset", "eval/datasets/rag.yml", "path of the YAML dataset of questions")
+	k := flags.Int("k", 5, "number of chunks to retrieve per question")
+	threshold := flags.Float64("threshold", config.Get().Retrieval.SimilarityThreshold, "minimum cosine similarity of the retrieved chunks")
+	jsonOutput := flags.String("json", "", "write the full report to this JSON file")
+	seed := flags.Bool("seed", false, "generate the dataset from the *-qa.md files of the clones, then exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *seed {
+		dataset, err := SeedRAGDataset(rag.DocsPath())
+		if err != nil {
+			return fmt.Errorf("error seeding the RAG dataset: %w", err)
+		}
+		fmt.Println("🌱 questions:", len(dataset.Questions), "->", *datasetPath)
+		fmt.Println("✍️ paraphrase the questions before running the evaluation (they are the section titles)")
+		return SaveRAGDataset(*datasetPath, dataset)
+	}
+
+	dataset, err := LoadRAGDataset(*datasetPath)
+	if err != nil {
+		return err
+	}
+	if verbatim := dataset.VerbatimQuestions(); len(verbatim) > 0 {
+		fmt.Printf("⚠️ %d questions are the titles of their expected sections, the recall of these questions is not meaningful\n", len(verbatim))
+	}
+
+	clones, err := workflow.InitializeClones()
+	if err != nil {
+		return err
+	}
+
+	report := RunRAGEvaluation(clones, dataset, *k, *threshold)
+	report.Print()
+
+	if *jsonOutput != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(*jsonOutput, data, 0644)
+	}
+	return nil
+}
+
+func runAnswers(args []string) error {
+	flags := flag.NewFlagSet("eval answers", flag.ContinueOnError)
+	datasetPath := flags.String("dataset", "eval/datasets/answers.yml", "path of the YAML dataset of prompts")
+	url := flags.String("url", "http://localhost:5050", "URL of the backend")
+	judgeModel := flags.String("judge-model", "", "model used as LLM-as-judge (disabled if empty)")
+	jsonOutput := flags.String("json", "", "write the report to this JSON file")
+	markdownOutput := flags.String("markdown", "", "write the report to this Markdown file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dataset, err := LoadAnswersDataset(*datasetPath)
+	if err != nil {
+		return err
+	}
+
+	var judge *robby.Agent
+	if *judgeModel != "" {
+		judge, err = GetJudge(*judgeModel)
+		if err != nil {
+			return fmt.Errorf("error creating the judge agent: %w", err)
+		}
+	}
+
+	report := RunAnswersEvaluation(*url, dataset, judge)
+	fmt.Printf("📊 Answers evaluation: %d passed, %d failed\n", report.Passed, report.Failed)
+	for _, result := range report.Results {
+		if !result.Passed {
+			fmt.Println("❌", result.Name+":", strings.Join(result.Failures, "; "))
+		}
+	}
+
+	if *jsonOutput != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*jsonOutput, data, 0644); err != nil {
+			return err
+		}
+	}
+	if *markdownOutput != "" {
+		if err := os.WriteFile(*markdownOutput, []byte(report.Markdown()), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/rag"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RAGQuestion is a question of the RAG evaluation dataset.
+// An expected source is a document path relative to the docs folder (ex: bill/docker-compose-qa.md),
+// optionally followed by a section title (ex: bill/docker-compose-qa.md#What are profiles in Docker Compose?).
+// If the clone is empty, the question is searched in the RAG memories of all the clones.
+type RAGQuestion struct {
+	Question        string   `yaml:"question" json:"question"`
+	Clone           string   `yaml:"clone,omitempty" json:"clone,omitempty"`
+	ExpectedSources []string `yaml:"expected_sources" json:"expected_sources"`
+}
+
+type RAGDataset struct {
+	Questions []RAGQuestion `yaml:"questions"`
+}
+
+// RAGQuestionResult is the result of the retrieval for one question.
+type RAGQuestionResult struct {
+	RAGQuestion
+	Retrieved    []rag.Similarity `json:"retrieved"`
+	Found        []string         `json:"found"`
+	Missed       []string         `json:"missed"`
+	Recall       float64          `json:"recall"`
+	ReciprocalRk float64          `json:"reciprocal_rank"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// RAGReport is the result of the RAG evaluation.
+type RAGReport struct {
+	K         int                 `json:"k"`
+	Threshold float64             `json:"threshold"`
+	RecallAtK float64             `json:"recall_at_k"`
+	MRR       float64             `json:"mrr"`
+	Results   []RAGQuestionResult `json:"results"`
+}
+
+func LoadRAGDataset(path string) (RAGDataset, error) {
+	var dataset RAGDataset
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dataset, fmt.Errorf("error reading the RAG dataset: %w", err)
+	}
+	err = yaml.Unmarshal(data, &dataset)
+	if err != nil {
+		return dataset, fmt.Errorf("error parsing the RAG dataset: %w", err)
+	}
+	return dataset, nil
+}
+
+// SeedRAGDataset creates a RAG dataset from the "## " headings of the *-qa.md files of the clones:
+// every heading is a question, and its section is the expected source.
+// The headings are only placeholders: a question identical to its section title is found by construction,
+// the questions must be paraphrased before running the evaluation (see VerbatimQuestions).
+func SeedRAGDataset(docsPath string) (RAGDataset, error) {
+	dataset := RAGDataset{}
+	_, err := rag.ForEachFile(docsPath, ".md", func(path string) error {
+		if !strings.HasSuffix(path, "-qa.md") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		source, _ := filepath.Rel(docsPath, path)
+		clone := strings.Split(filepath.ToSlash(source), "/")[0]
+		for _, section := range rag.GetSections(string(data)) {
+			dataset.Questions = append(dataset.Questions, RAGQuestion{
+				Question:        section.Title,
+				Clone:           clone,
+				ExpectedSources: []string{source + "#" + section.Title},
+			})
+		}
+		return nil
+	})
+	return dataset, err
+}
+
+// VerbatimQuestions returns the questions identical to the title of one of their expected sections
+// (ex: the questions of a seeded dataset that have not been paraphrased).
+func (dataset RAGDataset) VerbatimQuestions() []string {
+	questions := []string{}
+	for _, question := range dataset.Questions {
+		for _, expectedSource := range question.ExpectedSources {
+			_, section, withSection := strings.Cut(expectedSource, "#")
+			if withSection && strings.EqualFold(strings.TrimSpace(question.Question), strings.TrimSpace(section)) {
+				questions = append(questions, question.Question)
+				break
+			}
+		}
+	}
+	return questions
+}
+
+// SaveRAGDataset writes the dataset to a YAML file.
+func SaveRAGDataset(path string, dataset RAGDataset) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := yaml.NewEncoder(file)
+	encoder.SetIndent(2)
+	return encoder.Encode(dataset)
+}
+
+// matchSource checks if a retrieved chunk matches an expected source (document or document#section).
+func matchSource(chunk rag.Chunk, expectedSource string) bool {
+	document, section, withSection := strings.Cut(expectedSource, "#")
+	if chunk.Source != document {
+		return false
+	}
+	if !withSection {
+		return true
+	}
+	for _, chunkSection := range chunk.Sections {
+		if strings.EqualFold(chunkSection, section) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunRAGEvaluation runs the retrieval of every question of the dataset against the RAG memories of the clones,
+// and computes the recall@k and the MRR (Mean Reciprocal Rank).
+func RunRAGEvaluation(clones map[string]*agents.AgentConfig, dataset RAGDataset, k int, threshold float64) RAGReport {
+	report := RAGReport{K: k, Threshold: threshold}
+
+	for _, question := range dataset.Questions {
+		result := RAGQuestionResult{RAGQuestion: question}
+
+		cloneNames := []string{question.Clone}
+		if question.Clone == "" {
+			cloneNames = []string{}
+			for cloneName := range clones {
+				cloneNames = append(cloneNames, cloneName)
+			}
+			// NOTE: sorted to get the same report at every run (the scores of the clones can be equal)
+			sort.Strings(cloneNames)
+		}
+
+		for _, cloneName := range cloneNames {
+			clone, ok := clones[cloneName]
+			if !ok {
+				result.Error = "unknown clone: " + cloneName
+				break
+			}
+			similarities, err := rag.SearchTopNSimilarities(context.Background(), clone.Agent, question.Question, threshold, k)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			result.Retrieved = append(result.Retrieved, similarities...)
+		}
+		// NOTE: keep the k best chunks when searching in several clones
+		sort.SliceStable(result.Retrieved, func(i, j int) bool {
+			return result.Retrieved[i].Score > result.Retrieved[j].Score
+		})
+		if len(result.Retrieved) > k {
+			result.Retrieved = result.Retrieved[:k]
+		}
+
+		for _, expectedSource := range question.ExpectedSources {
+			found := false
+			for _, similarity := range result.Retrieved {
+				if matchSource(similarity.Chunk, expectedSource) {
+					found = true
+					break
+				}
+			}
+			if found {
+				result.Found = append(result.Found, expectedSource)
+			} else {
+				result.Missed = append(result.Missed, expectedSource)
+			}
+		}
+		if len(question.ExpectedSources) > 0 {
+			result.Recall = float64(len(result.Found)) / float64(len(question.ExpectedSources))
+		}
+
+	rankLoop:
+		for rank, similarity := range result.Retrieved {
+			for _, expectedSource := range question.ExpectedSources {
+				if matchSource(similarity.Chunk, expectedSource) {
+					result.ReciprocalRk = 1.0 / float64(rank+1)
+					break rankLoop
+				}
+			}
+		}
+
+		report.RecallAtK += result.Recall
+		report.MRR += result.ReciprocalRk
+		report.Results = append(report.Results, result)
+	}
+
+	if len(report.Results) > 0 {
+		report.RecallAtK /= float64(len(report.Results))
+		report.MRR /= float64(len(report.Results))
+	}
+	return report
+}
+
+// Print displays the report: the global metrics, then the questions with missed sources.
+func (report RAGReport) Print() {
+	fmt.Println("📊 RAG evaluation")
+	fmt.Println("  questions:", len(report.Results))
+	fmt.Println("  k:", report.K, "threshold:", report.Threshold)
+	fmt.Printf("  recall@%d: %.3f\n", report.K, report.RecallAtK)
+	fmt.Printf("  MRR: %.3f\n", report.MRR)
+
+	misses := 0
+	for _, result := range report.Results {
+		if len(result.Missed) == 0 && result.Error == "" {
+			continue
+		}
+		misses++
+		fmt.Println("❌", result.Question, "["+result.Clone+"]")
+		if result.Error != "" {
+			fmt.Println("   error:", result.Error)
+		}
+		for _, missed := range result.Missed {
+			fmt.Println("   missed:", missed)
+		}
+		for rank, similarity := range result.Retrieved {
+			fmt.Printf("   %d. %.3f %s %v\n", rank+1, similarity.Score, similarity.Source, similarity.Sections)
+		}
+	}
+	fmt.Println("  questions with misses:", misses)
+}
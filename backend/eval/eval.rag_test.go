@@ -0,0 +1,50 @@
+package eval
+
+import (
+	"slices"
+	"testing"
+	"we-are-legion/rag"
+)
+
+func TestVerbatimQuestions(t *testing.T) {
+	dataset := RAGDataset{Questions: []RAGQuestion{
+		{Question: "What is Docker Compose?", ExpectedSources: []string{"bill/docker-compose-qa.md#What is Docker Compose?"}},
+		{Question: "what is docker compose? ", ExpectedSources: []string{"bill/docker-compose-qa.md#What is Docker Compose?"}},
+		{Question: "Which tool runs an app made of several containers?", ExpectedSources: []string{"bill/docker-compose-qa.md#What is Docker Compose?"}},
+		{Question: "What is Docker Compose?", ExpectedSources: []string{"bill/docker-compose-qa.md"}},
+	}}
+	verbatim := dataset.VerbatimQuestions()
+	expected := []string{"What is Docker Compose?", "what is docker compose? "}
+	if !slices.Equal(verbatim, expected) {
+		t.Errorf("VerbatimQuestions() = %q, want %q", verbatim, expected)
+	}
+}
+
+func TestMatchSource(t *testing.T) {
+	chunk := rag.Chunk{Source: "bill/docker-compose-qa.md", Sections: []string{"What are profiles in Docker Compose?"}}
+	tests := []struct {
+		expectedSource string
+		match          bool
+	}{
+		{"bill/docker-compose-qa.md", true},
+		{"bill/docker-compose-qa.md#what are profiles in docker compose?", true},
+		{"bill/docker-compose-qa.md#What is Docker Compose?", false},
+		{"bob/docker-qa.md", false},
+	}
+	for _, test := range tests {
+		if match := matchSource(chunk, test.expectedSource); match != test.match {
+			t.Errorf("matchSource(%q) = %v, want %v", test.expectedSource, match, test.match)
+		}
+	}
+}
+
+// NOTE: the committed dataset must be paraphrased (see SeedRAGDataset)
+func TestRAGDatasetIsParaphrased(t *testing.T) {
+	dataset, err := LoadRAGDataset("datasets/rag.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verbatim := dataset.VerbatimQuestions(); len(verbatim) > 0 {
+		t.Errorf("%d questions are the titles of their sections: %q", len(verbatim), verbatim)
+	}
+}
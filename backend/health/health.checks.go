@@ -0,0 +1,212 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/mcphub"
+	"we-are-legion/workflow"
+)
+
+// listModels returns the ids of the models of the Docker Model Runner (OpenAI API: GET /models).
+func listModels(ctx context.Context, baseURL string) ([]string, error) {
+	if baseURL == "" {
+		return nil, errors.New("DMR_BASE_URL is not set")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/engines/llama.cpp/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", response.Status)
+	}
+	var models struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&models); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, model := range models.Data {
+		ids = append(ids, model.ID)
+	}
+	return ids, nil
+}
+
+// normalizeModel removes the default tag of a model (ex: ai/qwen2.5:latest -> ai/qwen2.5).
+func normalizeModel(model string) string {
+	return strings.ToLower(strings.TrimSuffix(model, ":latest"))
+}
+
+// ModelRunnerCheck checks that the Docker Model Runner at DMR_BASE_URL answers.
+func ModelRunnerCheck(baseURL string) Check {
+	return Check{
+		Name:     "model_runner",
+		Required: true,
+		Run: func(ctx context.Context) (any, error) {
+			models, err := listModels(ctx, baseURL)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"url": baseURL, "models": len(models)}, nil
+		},
+	}
+}
+
+// ModelsCheck checks that the chat, tools and embedding models of the agents are available on the Docker Model Runner.
+// The details give the status of every model, with the agents using it.
+func ModelsCheck(baseURL string, agentsCatalog map[string]*agents.AgentConfig) Check {
+	return Check{
+		Name:     "models",
+		Required: true,
+		Run: func(ctx context.Context) (any, error) {
+			// NOTE: model -> names of the agents using it
+			usedBy := map[string][]string{}
+			missingConfig := []string{}
+			for agentName, agentConfig := range agentsCatalog {
+				if agentConfig == nil || agentConfig.Agent == nil {
+					continue
+				}
+				if agentConfig.Agent.Params.Model == "" {
+					missingConfig = append(missingConfig, agentName+" (chat model)")
+				} else {
+					usedBy[agentConfig.Agent.Params.Model] = append(usedBy[agentConfig.Agent.Params.Model], agentName)
+				}
+				if embeddingModel := agentConfig.Agent.EmbeddingParams.Model; embeddingModel != "" {
+					usedBy[embeddingModel] = append(usedBy[embeddingModel], agentName+" (embeddings)")
+				}
+			}
+
+			available, err := listModels(ctx, baseURL)
+			if err != nil {
+				return nil, err
+			}
+			availableModels := map[string]bool{}
+			for _, model := range available {
+				availableModels[normalizeModel(model)] = true
+			}
+
+			details := map[string]any{}
+			missing := []string{}
+			for model, agentNames := range usedBy {
+				sort.Strings(agentNames)
+				status := StatusUp
+				if !availableModels[normalizeModel(model)] {
+					status = StatusDown
+					missing = append(missing, model)
+				}
+				details[model] = map[string]any{"status": status, "agents": agentNames}
+			}
+			sort.Strings(missing)
+			sort.Strings(missingConfig)
+			switch {
+			case len(missingConfig) > 0:
+				return details, fmt.Errorf("no model configured for: %s", strings.Join(missingConfig, ", "))
+			case len(missing) > 0:
+				return details, fmt.Errorf("models not available: %s", strings.Join(missing, ", "))
+			}
+			return details, nil
+		},
+	}
+}
+
+// StartupCheck checks that the first initialization of the agents is done (the RAG memories of the clones are built),
+// the details give the progress of every agent.
+func StartupCheck(catalog *workflow.Catalog) Check {
+	return Check{
+		Name:     "startup",
+		Required: true,
+		Run: func(ctx context.Context) (any, error) {
+			progress := catalog.Progress()
+			if !progress.Done {
+				return progress, errors.New("warming up: " + workflow.FormatStartupProgress(progress))
+			}
+			return progress, nil
+		},
+	}
+}
+
+// AgentsCheck checks that all the agents are initialized (see workflow.Catalog).
+// It is optional: the backend serves the available clones, the unavailable agents are initialized again in the background.
+func AgentsCheck(agentsCatalog map[string]*agents.AgentConfig, agentErrors map[string]error) Check {
+	return Check{
+		Name: "agents",
+		Run: func(ctx context.Context) (any, error) {
+			details := map[string]string{}
+			for agentName := range agentsCatalog {
+				details[agentName] = StatusUp
+			}
+			unavailable := []string{}
+			for agentName, err := range agentErrors {
+				details[agentName] = StatusDown + ": " + err.Error()
+				unavailable = append(unavailable, agentName)
+			}
+			if len(unavailable) > 0 {
+				sort.Strings(unavailable)
+				return details, fmt.Errorf("unavailable agents: %s", strings.Join(unavailable, ", "))
+			}
+			return details, nil
+		},
+	}
+}
+
+// RAGCheck checks that the RAG memories (the indexes of the documents) of the clones are loaded.
+func RAGCheck(clones map[string]*agents.AgentConfig) Check {
+	return Check{
+		Name:     "rag_indexes",
+		Required: true,
+		Run: func(ctx context.Context) (any, error) {
+			if len(clones) == 0 {
+				return nil, errors.New("no clone is available")
+			}
+			details := map[string]int{}
+			empty := []string{}
+			for cloneName, clone := range clones {
+				records := 0
+				if clone != nil && clone.Agent != nil {
+					records = len(clone.Agent.Store.Records)
+				}
+				details[cloneName] = records
+				if records == 0 {
+					empty = append(empty, cloneName)
+				}
+			}
+			if len(empty) > 0 {
+				sort.Strings(empty)
+				return details, fmt.Errorf("no documents in the RAG memory of: %s", strings.Join(empty, ", "))
+			}
+			return details, nil
+		},
+	}
+}
+
+// MCPChecks checks that every MCP server of the hub answers tools/list.
+// The MCP servers are optional: the web search is opt-in.
+func MCPChecks(hub *mcphub.Hub) []Check {
+	checks := []Check{}
+	for _, serverName := range hub.ServerNames() {
+		checks = append(checks, Check{
+			Name: "mcp:" + serverName,
+			Run: func(ctx context.Context) (any, error) {
+				toolNames, err := hub.ListTools(ctx, serverName)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]any{"tools": len(toolNames)}, nil
+			},
+		})
+	}
+	return checks
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"we-are-legion/agents"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+func newModelRunner(t *testing.T, models ...string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/engines/llama.cpp/v1/models" {
+			http.NotFound(response, request)
+			return
+		}
+		data := []map[string]string{}
+		for _, model := range models {
+			data = append(data, map[string]string{"id": model})
+		}
+		json.NewEncoder(response).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func agentWithModels(chatModel string, embeddingModel string) *agents.AgentConfig {
+	agent := &robby.Agent{}
+	agent.Params.Model = chatModel
+	agent.EmbeddingParams = openai.EmbeddingNewParams{Model: embeddingModel}
+	return &agents.AgentConfig{Agent: agent}
+}
+
+func TestModelsCheck(t *testing.T) {
+	baseURL := newModelRunner(t, "ai/qwen2.5:latest", "ai/mxbai-embed-large")
+	tests := []struct {
+		name    string
+		baseURL string
+		agents  map[string]*agents.AgentConfig
+		err     string
+	}{
+		{"available", baseURL, map[string]*agents.AgentConfig{"bob": agentWithModels("ai/qwen2.5", "ai/mxbai-embed-large:latest")}, ""},
+		{"missing model", baseURL, map[string]*agents.AgentConfig{"bob": agentWithModels("ai/qwen2.5", ""), "milo": agentWithModels("ai/llama3.2", "")}, "models not available: ai/llama3.2"},
+		{"no chat model", baseURL, map[string]*agents.AgentConfig{"milo": agentWithModels("", "")}, "no model configured for: milo (chat model)"},
+		{"unavailable agent", baseURL, map[string]*agents.AgentConfig{"bob": agentWithModels("ai/qwen2.5", ""), "milo": nil}, ""},
+		{"no model runner", "", map[string]*agents.AgentConfig{"bob": agentWithModels("ai/qwen2.5", "")}, "DMR_BASE_URL is not set"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ModelsCheck(test.baseURL, test.agents).Run(context.Background())
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
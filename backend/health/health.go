@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status of a check and of the readiness report.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	StatusReady    = "ready"     // all the checks are up
+	StatusDegraded = "degraded"  // only optional checks are down (ex: a MCP server), the chat still works
+	StatusNotReady = "not_ready" // a required check is down
+)
+
+// Check is a dependency of the backend checked by the readiness endpoint.
+// An optional check (ex: a MCP server) does not make the backend not ready.
+type Check struct {
+	Name     string
+	Required bool
+	Run      func(ctx context.Context) (details any, err error)
+}
+
+// Result is the status of a dependency.
+type Result struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Required  bool   `json:"required"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	Details   any    `json:"details,omitempty"`
+}
+
+// Report is the JSON body of the readiness endpoint.
+type Report struct {
+	Status string    `json:"status"`
+	Time   time.Time `json:"time"`
+	Checks []Result  `json:"checks"`
+}
+
+// Ready returns true if all the required checks are up.
+func (report Report) Ready() bool {
+	return report.Status != StatusNotReady
+}
+
+// Run runs the checks concurrently with a timeout, the results keep the order of the checks.
+func Run(ctx context.Context, checks []Check, timeout time.Duration) Report {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	var waitGroup sync.WaitGroup
+	for index, check := range checks {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			start := time.Now()
+			details, err := check.Run(ctx)
+			result := Result{Name: check.Name, Status: StatusUp, Required: check.Required, Details: details}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+			result.LatencyMs = time.Since(start).Milliseconds()
+			results[index] = result
+		}()
+	}
+	waitGroup.Wait()
+
+	report := Report{Status: StatusReady, Time: time.Now(), Checks: results}
+	for _, result := range results {
+		if result.Status == StatusUp {
+			continue
+		}
+		if result.Required {
+			report.Status = StatusNotReady
+			break
+		}
+		report.Status = StatusDegraded
+	}
+	return report
+}
+
+// LivenessHandler is the handler of /healthz: the process is alive (the dependencies are not checked).
+func LivenessHandler() http.HandlerFunc {
+	started := time.Now()
+	return func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(map[string]any{
+			"status":         "alive",
+			"uptime_seconds": int(time.Since(started).Seconds()),
+		})
+	}
+}
+
+// ReadinessHandler is the handler of /readyz: it runs the checks and returns their status,
+// with the 503 status code if a required check is down.
+func ReadinessHandler(checks func() []Check, timeout time.Duration) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		report := Run(request.Context(), checks(), timeout)
+		response.Header().Set("Content-Type", "application/json")
+		if !report.Ready() {
+			response.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(response).Encode(report)
+	}
+}
+
+// Probe requests an endpoint of the backend (ex: http://localhost:5050/readyz) and returns an error if it is not 200 OK.
+// It is used by the healthcheck sub-command (the image has no curl, see compose.yml).
+func Probe(url string) error {
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, response.Status)
+	}
+	return nil
+}
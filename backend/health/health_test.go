@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func upCheck(name string, required bool) Check {
+	return Check{Name: name, Required: required, Run: func(ctx context.Context) (any, error) {
+		return map[string]string{"name": name}, nil
+	}}
+}
+
+func downCheck(name string, required bool) Check {
+	return Check{Name: name, Required: required, Run: func(ctx context.Context) (any, error) {
+		return nil, errors.New(name + " is down")
+	}}
+}
+
+// hangingCheck answers when its context is done (ex: a dependency which does not answer).
+func hangingCheck(name string, required bool) Check {
+	return Check{Name: name, Required: required, Run: func(ctx context.Context) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		checks   []Check
+		status   string
+		statuses []string
+	}{
+		{"no check", nil, StatusReady, []string{}},
+		{"all up", []Check{upCheck("dmr", true), upCheck("mcp", false)}, StatusReady, []string{StatusUp, StatusUp}},
+		{"optional down", []Check{upCheck("dmr", true), downCheck("mcp", false)}, StatusDegraded, []string{StatusUp, StatusDown}},
+		{"required down", []Check{downCheck("dmr", true), upCheck("mcp", false)}, StatusNotReady, []string{StatusDown, StatusUp}},
+		{"required and optional down", []Check{downCheck("mcp", false), downCheck("dmr", true)}, StatusNotReady, []string{StatusDown, StatusDown}},
+		{"timeout", []Check{hangingCheck("dmr", true), upCheck("mcp", false)}, StatusNotReady, []string{StatusDown, StatusUp}},
+		{"optional timeout", []Check{upCheck("dmr", true), hangingCheck("mcp", false)}, StatusDegraded, []string{StatusUp, StatusDown}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			report := Run(context.Background(), test.checks, 100*time.Millisecond)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Run() took %s, want about the timeout", elapsed)
+			}
+			if report.Status != test.status {
+				t.Errorf("status = %s, want %s", report.Status, test.status)
+			}
+			if report.Ready() != (test.status != StatusNotReady) {
+				t.Errorf("Ready() = %v with the status %s", report.Ready(), report.Status)
+			}
+			if len(report.Checks) != len(test.statuses) {
+				t.Fatalf("%d results, want %d", len(report.Checks), len(test.statuses))
+			}
+			// NOTE: the results keep the order of the checks
+			for index, result := range report.Checks {
+				check := test.checks[index]
+				if result.Name != check.Name || result.Required != check.Required || result.Status != test.statuses[index] {
+					t.Errorf("result %d = %+v, want %s (%s)", index, result, check.Name, test.statuses[index])
+				}
+				if (result.Status == StatusDown) != (result.Error != "") {
+					t.Errorf("result %d: status %s with the error %q", index, result.Status, result.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []Check
+		statusCode int
+		status     string
+	}{
+		{"ready", []Check{upCheck("dmr", true)}, http.StatusOK, StatusReady},
+		{"degraded", []Check{upCheck("dmr", true), downCheck("mcp", false)}, http.StatusOK, StatusDegraded},
+		{"not ready", []Check{downCheck("dmr", true)}, http.StatusServiceUnavailable, StatusNotReady},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler := ReadinessHandler(func() []Check { return test.checks }, time.Second)
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != test.statusCode {
+				t.Errorf("status code = %d, want %d", recorder.Code, test.statusCode)
+			}
+			var report Report
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != test.status || len(report.Checks) != len(test.checks) {
+				t.Errorf("report = %+v, want the status %s", report, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package helpers
+
+import (
+	"net/http"
+	"sync"
+)
+
+// SyncResponseWriter serializes the writes to a streamed response,
+// ex: the labels of the tool calls detections running concurrently.
+type SyncResponseWriter struct {
+	http.ResponseWriter
+	flusher http.Flusher
+	mutex   sync.Mutex
+}
+
+// NewSyncResponseWriter returns a response writer safe for concurrent use, it is also the flusher of the response.
+func NewSyncResponseWriter(response http.ResponseWriter, flusher http.Flusher) *SyncResponseWriter {
+	return &SyncResponseWriter{ResponseWriter: response, flusher: flusher}
+}
+
+func (writer *SyncResponseWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return writer.ResponseWriter.Write(data)
+}
+
+func (writer *SyncResponseWriter) Flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.flusher.Flush()
+}
@@ -0,0 +1,83 @@
+/*
+Package mcpstub is a MCP server with fake tools, to test the MCP transports, the hub and the workflow
+without the Docker MCP Toolkit. It is only imported by the tests (its crash tool exits the process).
+*/
+package mcpstub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type stubSearchInput struct {
+	Query string `json:"query" jsonschema:"the search query"`
+	Count int    `json:"count,omitempty" jsonschema:"the number of results"`
+}
+
+type stubEchoInput struct {
+	Text string `json:"text" jsonschema:"the text to echo"`
+}
+
+// NewServer creates the stub MCP server:
+//   - brave_web_search: returns fake search results (same name as the Brave tool of the Docker MCP Toolkit).
+//   - echo: returns the text.
+//   - fail: returns an error (to test the failed tool calls).
+//   - crash: exits the process (to test the restart of the stdio servers).
+func NewServer() *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: "bob-stub", Version: "1.0.0"}, nil)
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "brave_web_search",
+		Description: "Search the web (fake results)",
+	}, func(ctx context.Context, request *mcp.CallToolRequest, input stubSearchInput) (*mcp.CallToolResult, any, error) {
+		if input.Count <= 0 {
+			input.Count = 3
+		}
+		results := []map[string]string{}
+		for i := 1; i <= input.Count; i++ {
+			results = append(results, map[string]string{
+				"title":       fmt.Sprintf("Result %d for %s", i, input.Query),
+				"url":         fmt.Sprintf("https://example.com/%d", i),
+				"description": fmt.Sprintf("This is the fake result %d for the query: %s", i, input.Query),
+			})
+		}
+		data, _ := json.Marshal(results)
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
+	})
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "echo",
+		Description: "Echo the text",
+	}, func(ctx context.Context, request *mcp.CallToolRequest, input stubEchoInput) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: input.Text}}}, nil, nil
+	})
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "fail",
+		Description: "Return an error",
+	}, func(ctx context.Context, request *mcp.CallToolRequest, input stubEchoInput) (*mcp.CallToolResult, any, error) {
+		return &mcp.CallToolResult{IsError: true, Content: []mcp.Content{&mcp.TextContent{Text: "failure: " + input.Text}}}, nil, nil
+	})
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "crash",
+		Description: "Exit the MCP server process",
+	}, func(ctx context.Context, request *mcp.CallToolRequest, input struct{}) (*mcp.CallToolResult, any, error) {
+		fmt.Fprintln(os.Stderr, "💥 stub MCP server: crash")
+		os.Exit(1)
+		return nil, nil, nil
+	})
+
+	return server
+}
+
+// RunStdio runs the stub MCP server over stdio (ex: a test binary started by the stdio transport of the hub).
+func RunStdio() error {
+	// NOTE: stdout is used by the MCP protocol, the logs go to stderr
+	fmt.Fprintln(os.Stderr, "🧪 stub MCP server (stdio)")
+	return NewServer().Run(context.Background(), &mcp.StdioTransport{})
+}
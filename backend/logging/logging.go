@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type attrsKey struct{}
+
+// Setup configures the default slog logger (the logs are written to stderr):
+//   - LOG_LEVEL: debug, info (default), warn or error.
+//   - LOG_FORMAT: text (default) or json.
+//
+// Every log line written with a context carries the attributes of the context (see With).
+func Setup() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(ContextHandler{Handler: handler}))
+}
+
+// With returns a copy of the context carrying the log attributes (key-value pairs, ex: "request_id", id).
+// An attribute replaces the attribute of the context with the same key.
+func With(ctx context.Context, args ...any) context.Context {
+	record := slog.Record{}
+	record.Add(args...)
+	newAttrs := []slog.Attr{}
+	record.Attrs(func(attr slog.Attr) bool {
+		newAttrs = append(newAttrs, attr)
+		return true
+	})
+
+	attrs := []slog.Attr{}
+	for _, attr := range Attrs(ctx) {
+		replaced := false
+		for _, newAttr := range newAttrs {
+			if newAttr.Key == attr.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			attrs = append(attrs, attr)
+		}
+	}
+	return context.WithValue(ctx, attrsKey{}, append(attrs, newAttrs...))
+}
+
+// WithStep returns a copy of the context with the step of the chat pipeline (ex: tool_detection).
+func WithStep(ctx context.Context, step string) context.Context {
+	return With(ctx, "step", step)
+}
+
+// Attrs returns the log attributes of the context.
+func Attrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// ContextHandler adds the attributes of the context to the log records,
+// and the trace id of the span of the context if any (see the tracing package).
+type ContextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the attributes of the context to the record.
+func (handler ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(Attrs(ctx)...)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()))
+	}
+	return handler.Handler.Handle(ctx, record)
+}
+
+// WithAttrs returns a handler with the attributes, which still adds the attributes of the context.
+func (handler ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return ContextHandler{Handler: handler.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a handler with the group, which still adds the attributes of the context.
+func (handler ContextHandler) WithGroup(name string) slog.Handler {
+	return ContextHandler{Handler: handler.Handler.WithGroup(name)}
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWith(t *testing.T) {
+	requestCtx := With(context.Background(), "request_id", "request-1", "session_id", "session-1", "agent", "Riker")
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want map[string]string
+	}{
+		{"no attributes", context.Background(), map[string]string{}},
+		{"attributes of the request", requestCtx,
+			map[string]string{"request_id": "request-1", "session_id": "session-1", "agent": "Riker"}},
+		{"the agent is replaced", With(requestCtx, "agent", "Bob"),
+			map[string]string{"request_id": "request-1", "session_id": "session-1", "agent": "Bob"}},
+		{"step", WithStep(With(requestCtx, "agent", "Bob"), "tool_detection"),
+			map[string]string{"request_id": "request-1", "session_id": "session-1", "agent": "Bob", "step": "tool_detection"}},
+		{"the step is replaced", WithStep(WithStep(requestCtx, "tool_detection"), "completion"),
+			map[string]string{"request_id": "request-1", "session_id": "session-1", "agent": "Riker", "step": "completion"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string]string{}
+			for _, attr := range Attrs(test.ctx) {
+				if _, ok := got[attr.Key]; ok {
+					t.Errorf("the attribute %s is duplicated", attr.Key)
+				}
+				got[attr.Key] = attr.Value.String()
+			}
+			if len(got) != len(test.want) {
+				t.Errorf("Attrs() = %v, want %v", got, test.want)
+			}
+			for key, value := range test.want {
+				if got[key] != value {
+					t.Errorf("%s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+	// NOTE: the context of the request is not changed by the contexts of the steps
+	if agent := Attrs(requestCtx)[2].Value.String(); agent != "Riker" {
+		t.Errorf("the agent of the request context = %q, want Riker", agent)
+	}
+}
+
+func TestContextHandler(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	requestCtx := WithStep(With(context.Background(), "request_id", "request-1", "session_id", "session-1", "agent", "Bob"), "completion")
+	tracedCtx := trace.ContextWithSpanContext(requestCtx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		logger func(handler slog.Handler) *slog.Logger
+		want   map[string]string
+	}{
+		{"without context attributes", context.Background(), slog.New, map[string]string{}},
+		{"attributes of the context", requestCtx, slog.New,
+			map[string]string{"request_id": "request-1", "session_id": "session-1", "agent": "Bob", "step": "completion"}},
+		{"trace id of the span", tracedCtx, slog.New,
+			map[string]string{"request_id": "request-1", "step": "completion", "trace_id": traceID.String()}},
+		{"logger with attributes", requestCtx, func(handler slog.Handler) *slog.Logger { return slog.New(handler).With("component", "mcphub") },
+			map[string]string{"request_id": "request-1", "agent": "Bob", "component": "mcphub"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			logger := test.logger(ContextHandler{Handler: slog.NewJSONHandler(&output, nil)})
+			logger.InfoContext(test.ctx, "🤖 message", "tool", "echo")
+
+			var line map[string]any
+			if err := json.Unmarshal(output.Bytes(), &line); err != nil {
+				t.Fatalf("invalid log line %q: %v", output.String(), err)
+			}
+			if line["msg"] != "🤖 message" || line["tool"] != "echo" {
+				t.Errorf("the record is changed: %v", line)
+			}
+			for key, value := range test.want {
+				if line[key] != value {
+					t.Errorf("%s = %v, want %q: %v", key, line[key], value, line)
+				}
+			}
+			if _, ok := line["trace_id"]; ok != (test.want["trace_id"] != "") {
+				t.Errorf("trace_id = %v, want a trace id only with a span", line["trace_id"])
+			}
+		})
+	}
+}
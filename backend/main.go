@@ -1,18 +1,40 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"we-are-legion/agents"
+	"we-are-legion/approval"
+	"we-are-legion/cancellation"
+	"we-are-legion/config"
+	"we-are-legion/eval"
+	"we-are-legion/health"
 	"we-are-legion/helpers"
+	"we-are-legion/logging"
+	"we-are-legion/mcphub"
+	"we-are-legion/mcpserver"
+	"we-are-legion/metrics"
+	"we-are-legion/rag"
+	"we-are-legion/session"
+	"we-are-legion/state"
+	"we-are-legion/tracing"
+	"we-are-legion/usage"
 	"we-are-legion/workflow"
 
 	"github.com/openai/openai-go"
 	"github.com/sea-monkeys/robby"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 /*
@@ -26,20 +48,113 @@ func GetBytesBody(request *http.Request) []byte {
 	return body
 }
 
+// ChatRequest is the JSON body of the /chat endpoint.
+// The retrieval parameters override the ones of the selected agent for this request only
+// (the chunk size and the chunk overlap are ignored, the RAG memories are built at startup),
+// the request is rejected (400) if the merged parameters are invalid.
+// The MCP tools are opt-in:
+//   - web_search enables (or disables) the MCP tools for this request (it overrides the toggle of the session).
+//   - tools restricts the MCP tools for this request (same syntax as the allowlists of config.yml),
+//     a non empty list enables the MCP tools, an empty list disables them.
+type ChatRequest struct {
+	Message   string                  `json:"message"`
+	SessionID string                  `json:"sessionId"`
+	Retrieval *config.RetrievalOverride `json:"retrieval,omitempty"`
+	WebSearch *bool                     `json:"web_search,omitempty"`
+	Tools     []string                  `json:"tools,omitempty"`
+	RequestID string                    `json:"requestId,omitempty"` // generated if empty, sent in the X-Request-Id header
+	AgentLoop *bool                     `json:"agent_loop,omitempty"`  // overrides agent_loop.enabled of config.yml
+}
+
+// ApprovalRequest is the (optional) JSON body of the approve and reject endpoints:
+// without tool call id, all the pending tool calls of the request are approved or rejected.
+type ApprovalRequest struct {
+	ToolCallID string `json:"tool_call_id,omitempty"`
+}
+
+// mcpAllowlists returns the allowlists of the MCP tools for a request:
+// the allowlist of the selected clone (see config.yml) and the tools requested in the body.
+func mcpAllowlists(selectedAgent *agents.AgentConfig, requestedTools []string) [][]string {
+	allowlists := [][]string{config.GetMCPTools(strings.ToLower(selectedAgent.Name))}
+	if len(requestedTools) > 0 {
+		allowlists = append(allowlists, requestedTools)
+	}
+	return allowlists
+}
+
 func main() {
+	// NOTE: the logs are written to stderr, see LOG_LEVEL and LOG_FORMAT
+	logging.Setup()
+
+	// NOTE: the backend does not start with an invalid config.yml (the default values are only used without config.yml)
+	if err := config.Init(); err != nil {
+		slog.Error("😡 invalid configuration", "path", config.Path(), "error", err)
+		os.Exit(1)
+	}
+
+	// NOTE: evaluation sub-commands, ex: ./web-chat-bot eval rag -k 5
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		if err := eval.Run(os.Args[2:]); err != nil {
+			slog.Error("😡 evaluation failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// NOTE: ./web-chat-bot mcp serve, exposes the clones of Bob as MCP tools (stdio or HTTP)
+	if len(os.Args) > 2 && os.Args[1] == "mcp" && os.Args[2] == "serve" {
+		if err := mcpserver.Run(os.Args[3:]); err != nil {
+			slog.Error("😡 MCP server failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// NOTE: ./web-chat-bot healthcheck, checks the readiness of a running backend (used by compose.yml)
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		httpPort := os.Getenv("HTTP_PORT")
+		if httpPort == "" {
+			httpPort = "5050"
+		}
+		if err := health.Probe("http://localhost:" + httpPort + "/readyz"); err != nil {
+			slog.Error("😡 backend is not ready", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// NOTE: the spans of the /chat requests are exported if OTEL_EXPORTER_OTLP_ENDPOINT is set (see the tracing package)
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		slog.Error("😡 tracing setup failed", "error", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+
+	// Get the catalog of the agents
+	// NOTE: the agents are initialized concurrently in the background, the server listens while the RAG memories are built.
+	// An agent that fails to initialize (ex: a missing docs folder or model) is unavailable,
+	// the server runs in degraded mode and the unavailable agents are initialized again in the background
+	// NOTE: serverCtx is the parent of the contexts of the requests, it is cancelled when the drain timeout of the shutdown is over
+	serverCtx, cancelServerCtx := context.WithCancel(context.Background())
 
-	// Get a map of the agents
 	agentsCatalog := workflow.InitializeAgents()
-	// Select the current agent to use
-	selectedAgent := agentsCatalog["bob"]
+	go agentsCatalog.RetryUnavailableAgents(serverCtx, config.Get().Startup.RetryInterval)
+
+	// NOTE: the unused sessions are evicted (see config.yml)
+	session.Configure(config.Get().Sessions.TTL, config.Get().Sessions.MaxSessions)
+	session.OnEvict(usage.DeleteSession)
+	// NOTE: the sessions and the token usage saved at the previous shutdown are restored
+	if err := state.Load(config.Get().Shutdown.StateFile); err != nil {
+		slog.Error("😡 state not restored", "error", err)
+	}
+	go session.RunEviction(serverCtx, time.Minute)
 
-	// NOTE: we need separate agents for the tool completions
-	// Riker is the agent in charge of detecting if the user wants to change the current Agent,
-	// and to execute the tool calls.
-	// Khan is the agent in charge of detecting if the user wants to use the MCP tools,
-	// and to execute the MCP tool calls.
-	riker := agentsCatalog["riker"].Agent
-	khan := agentsCatalog["khan"].Agent
+	// The MCP hub manages the connections to the MCP servers used by Khan.
+	// NOTE: the servers not connected at startup are reconnected by the health checks (their tools are missing until then)
+	mcpHub := mcphub.Get()
+	for serverName, err := range mcpHub.Errors() {
+		slog.Error("😡 MCP server is not available", "agent", "Khan", "server", serverName, "error", err)
+	}
 
 	var httpPort = os.Getenv("HTTP_PORT")
 	if httpPort == "" {
@@ -47,9 +162,14 @@ func main() {
 	}
 
 	mux := http.NewServeMux()
-	shouldIStopTheCompletion := false // TODO: implement a way to stop the completion cleanly
+	// NOTE: the active streams are awaited at the shutdown to send their final event (see the end of main)
+	var activeStreams sync.WaitGroup
 
 	mux.HandleFunc("POST /chat", func(response http.ResponseWriter, request *http.Request) {
+		activeStreams.Add(1)
+		defer activeStreams.Done()
+		// NOTE: the time to first token is measured from the reception of the request
+		requestStart := time.Now()
 		// add a flusher
 		flusher, ok := response.(http.Flusher)
 		if !ok {
@@ -58,7 +178,7 @@ func main() {
 		}
 		body := GetBytesBody(request)
 		// unmarshal the json data
-		var data map[string]string
+		var data ChatRequest
 
 		err := json.Unmarshal(body, &data)
 		if err != nil {
@@ -66,14 +186,96 @@ func main() {
 		}
 
 		// NOTE: this is the message typed by the user
-		userQuestion := data["message"]
+		userQuestion := data.Message
+		chatSession := session.Get(data.SessionID)
 
-		riker.Params.Messages = []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(userQuestion),
+		// Select the current agent of the session (a 503 while the agents are warming up, see workflow.Catalog)
+		selectedAgent := agentsCatalog.SelectClone(response, flusher, chatSession.SelectedClone())
+		if selectedAgent == nil {
+			return
+		}
+		// NOTE: the retrieval parameters of the request are checked before streaming (a zero value is a value, ex: top_k: 0)
+		if data.Retrieval != nil {
+			if err := data.Retrieval.ValidateRequest(); err != nil {
+				http.Error(response, "Invalid retrieval parameters: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := selectedAgent.Retrieval.Merge(*data.Retrieval).Validate(); err != nil {
+				http.Error(response, "Invalid retrieval parameters: "+err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
+		// NOTE: we need separate agents for the tool completions
+		// Riker is the agent in charge of detecting if the user wants to change the current Agent,
+		// and to execute the tool calls.
+		// Khan is the agent in charge of detecting if the user wants to use the MCP tools,
+		// and to execute the MCP tool calls.
+		// Spock is the agent in charge of rewriting the follow-up questions into standalone search queries.
+		// NOTE: they are nil if they are unavailable, their steps are skipped
+		// NOTE: every request works on its own copies (messages, tools and tool calls, ex: the MCP tools allowed for the selected clone)
+		riker := agentsCatalog.RequestAgent("riker")
+		khan := agentsCatalog.RequestAgent("khan")
+		spock := agentsCatalog.RequestAgent("spock")
 
-		khan.Params.Messages = []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(userQuestion),
+		// NOTE: the tool calls with the "ask" policy wait for POST /chat/{requestId}/approve or /reject
+		requestID := data.RequestID
+		if requestID == "" {
+			requestID = approval.NewRequestID()
+		}
+		approvals, err := approval.NewRequest(request.Context(), requestID)
+		if err != nil {
+			http.Error(response, err.Error(), http.StatusConflict)
+			return
+		}
+		defer approvals.Close()
+		response.Header().Set("X-Request-Id", requestID)
+
+		// NOTE: the logs of the request carry the request id, the session id, the agent and the step
+		// NOTE: the context of the request is a child of serverCtx (see BaseContext), it is cancelled by the shutdown
+		// or when the client disconnects
+		ctx := logging.With(tracing.FromRequest(request), "request_id", requestID, "session_id", chatSession.ID, "agent", selectedAgent.Name)
+		// NOTE: the spans of the steps are the children of the span of the request
+		ctx, span := tracing.Start(ctx, "POST /chat",
+			attribute.String("request_id", requestID),
+			attribute.String("session_id", chatSession.ID),
+		)
+		defer span.End()
+		// NOTE: the token usage of the calls to the models is collected for the request and its session (see /usage)
+		usageTracker := usage.NewTracker(chatSession.ID)
+		ctx = usage.WithTracker(ctx, usageTracker)
+		// NOTE: the request can be cancelled with DELETE /cancel/{requestId}
+		ctx, endRequest := cancellation.WithCancel(ctx, requestID)
+		defer endRequest()
+
+		// NOTE: the MCP tools are used only if web search is enabled (request, then session, then config.yml),
+		// or if tools are requested in the body
+		useMCPTools := chatSession.IsWebSearchEnabled(data.WebSearch, config.Get().MCP.WebSearch)
+		if data.Tools != nil {
+			useMCPTools = len(data.Tools) > 0
+		}
+		// NOTE: with the agent loop, the selected clone calls the tools itself (instead of Khan)
+		useAgentLoop := config.Get().AgentLoop.Enabled
+		if data.AgentLoop != nil {
+			useAgentLoop = *data.AgentLoop
+		}
+
+		// NOTE: the calls to the models are not cancelled with the context (robby uses its own context),
+		// so the request stops between the steps when it is cancelled
+		interrupted := func(step string) bool {
+			if ctx.Err() == nil {
+				return false
+			}
+			slog.WarnContext(ctx, "🚫 request interrupted", "after", step, "error", ctx.Err())
+			switch {
+			case serverCtx.Err() != nil:
+				metrics.Cancellations.WithLabelValues("shutdown").Inc()
+				helpers.ResponseLabel(response, flusher, "warning", "The server is shutting down, the answer has been interrupted: please retry in a moment")
+			case cancellation.IsCancelled(ctx):
+				helpers.ResponseLabel(response, flusher, "info", "Request cancelled")
+			default:
+				metrics.Cancellations.WithLabelValues("client_disconnected").Inc()
+			}
+			return true
 		}
 
 		// STEP 1: TOOLCALLS: check if there are tool calls to detect in the user message
@@ -81,73 +283,372 @@ func main() {
 		// and the Khan agent for the MCP tool calls.
 		helpers.ResponseLabel(response, flusher, "info", "Checking for tool calls...")
 
-		toolCalls, _ := workflow.DetectToolCalls(response, flusher, riker)
-		mcpTooCalls, _ := workflow.DetectMCPToolCalls(response, flusher, khan)
+		ctx = logging.WithStep(ctx, "tool_detection")
+		stepStart := time.Now()
+		// NOTE: Riker and Khan detect their tool calls concurrently
+		detectors := []workflow.Detector{}
+		if riker != nil {
+			riker.Params.Messages = []openai.ChatCompletionMessageParamUnion{
+				openai.UserMessage(userQuestion),
+			}
+			detectors = append(detectors, workflow.RikerDetector(riker))
+		} else {
+			slog.WarnContext(ctx, "🚧 Riker is unavailable, skipping the tool calls detection")
+		}
+
+		// NOTE: Khan can only use the MCP tools allowed for the selected clone (see config.yml)
+		// and requested in the body, the detection is skipped when the MCP tools are disabled
+		if useMCPTools && !useAgentLoop && khan != nil {
+			khan.Params.Messages = []openai.ChatCompletionMessageParamUnion{
+				openai.UserMessage(userQuestion),
+			}
+			khan.Tools = mcpHub.Tools(mcpAllowlists(selectedAgent, data.Tools)...)
+			if len(khan.Tools) > 0 {
+				detectors = append(detectors, workflow.KhanDetector(khan))
+			}
+		} else if !useMCPTools {
+			slog.InfoContext(ctx, "🔕 web search is disabled, skipping the MCP tool calls detection")
+		} else if khan == nil {
+			slog.WarnContext(ctx, "🚧 Khan is unavailable, skipping the MCP tool calls detection")
+		}
+		detections := workflow.RunDetectors(ctx, response, flusher, detectors)
+		toolCalls := detections.ToolCalls("Riker")
+		mcpTooCalls := detections.ToolCalls("Khan")
+		metrics.ObserveStep("tool_detection", stepStart)
+		if interrupted("tool_detection") {
+			return
+		}
 
 		// STEP 2: MCP TOOLS EXECUTION if there are any MCP tool calls
 		var mcpResults []string
 		if len(mcpTooCalls) > 0 {
-			mcpResults, _ = workflow.ExecuteMCPToolCalls(response, flusher, khan)
+			stepStart = time.Now()
+			mcpResults, _ = workflow.ExecuteMCPToolCalls(logging.WithStep(ctx, "mcp_execution"), response, flusher, mcpHub, khan, approvals)
+			metrics.ObserveStep("mcp_execution", stepStart)
+			if interrupted("mcp_execution") {
+				return
+			}
 		}
 
 		// STEP 3: TOOL CALLS EXECUTION
 		if len(toolCalls) > 0 {
-			workflow.ExecuteToolCalls(response, flusher, agentsCatalog, riker, selectedAgent)
+			stepStart = time.Now()
+			_, selectedAgent, _ = workflow.ExecuteToolCalls(logging.WithStep(ctx, "tool_execution"), response, flusher, agentsCatalog.Clones(), riker, selectedAgent, chatSession, approvals)
+			metrics.ObserveStep("tool_execution", stepStart)
+			if interrupted("tool_execution") {
+				return
+			}
+			chatSession.SelectClone(selectedAgent.Name)
+			ctx = logging.With(ctx, "agent", selectedAgent.Name)
 		} else {
 			// NOTE: If there are no tool calls, 
 			// just continue the conversation
-			fmt.Println("🤖 No tool calls detected, continuing the conversation...")
-			fmt.Println("📝 user message:", userQuestion)
+			slog.InfoContext(ctx, "🤖 no tool calls detected, continuing the conversation...")
+			slog.DebugContext(ctx, "📝 user message", "message", userQuestion)
 		}
 
+		// NOTE: the selected clone answers with its persona and the conversation of the session (the agents of the clones are shared),
+		// the messages added by the next steps are saved in the session with the answer
+		selectedAgent = workflow.SessionClone(selectedAgent, chatSession)
+		historyLength := len(selectedAgent.Agent.Params.Messages)
+
 		// STEP 4: add context to the prompt
-		if len(mcpTooCalls) > 0 && len(mcpResults) > 0 { // OPTION 1: add the result of the MCP tool calls execution to the Agent's message
-			selectedAgent.Agent.Params.Messages = append(
-				selectedAgent.Agent.Params.Messages,
-				openai.SystemMessage("Here are some relevant documents found in the MCP memory:\n"+strings.Join(mcpResults, "\n")),
-				openai.SystemMessage("Use the above documents to answer the user question: "),
-				openai.UserMessage(userQuestion),
-			)
-		} else { // OPTION 2: make similarity search
-			workflow.SearchSimilarities(selectedAgent, userQuestion)
+		// NOTE: the documents of the RAG memory and the results of the MCP tool calls are ranked together,
+		// with their provenance, and fitted in the budget of tokens (see config.yml)
+		metrics.RoutingDecisions.WithLabelValues(strings.ToLower(selectedAgent.Name)).Inc()
+
+		ctx = logging.WithStep(ctx, "similarity_search")
+		stepStart = time.Now()
+		var webItems []workflow.ContextItem
+		var citations []mcphub.WebResult
+		if len(mcpTooCalls) > 0 && len(mcpResults) > 0 {
+			// NOTE: the search results are extracted and capped (see config.yml), the URLs are kept as citations
+			webItems, citations = workflow.ProcessMCPResults(ctx, response, flusher, spock, userQuestion, mcpResults, config.Get().MCP.Results)
+		}
+		searchQueries := []string{userQuestion}
+		if queryRewrite := config.Get().QueryRewrite; queryRewrite.Enabled && spock != nil {
+			searchQueries = workflow.RewriteQuery(ctx, response, flusher, spock, selectedAgent.Agent.Params.Messages, userQuestion, queryRewrite.MaxQueries)
 		}
+		retrieval := selectedAgent.Retrieval
+		if data.Retrieval != nil {
+			retrieval = retrieval.Merge(*data.Retrieval)
+		}
+		docsItems := workflow.SearchSimilarities(ctx, response, flusher, selectedAgent, retrieval, userQuestion, searchQueries...)
+		contextItems := workflow.AssembleContext(ctx, response, flusher, docsItems, webItems, config.Get().Context)
+		citations = workflow.KeepCitations(citations, contextItems)
+		metrics.ObserveStep("similarity_search", stepStart)
+		if interrupted("similarity_search") {
+			return
+		}
+
+		// STEP 4bis: agent loop, the selected clone calls tools (its docs, and the MCP tools if web search is enabled)
+		if useAgentLoop {
+			ctx := logging.WithStep(ctx, "agent_loop")
+			stepStart = time.Now()
+			agentLoop := config.Get().AgentLoop
+			agentTools := []workflow.AgentTool{}
+			if slices.Contains(agentLoop.Tools, "search_docs") {
+				agentTools = append(agentTools, workflow.DocsSearchTool(selectedAgent, retrieval))
+			}
+			if slices.Contains(agentLoop.Tools, "ask_clone") && agentLoop.MaxCloneDepth > 0 {
+				// NOTE: the selected clone can ask the other clones (with their RAG memory)
+				chain := []string{strings.ToLower(selectedAgent.Name)}
+				agentTools = append(agentTools, workflow.AskCloneTool(response, flusher, agentsCatalog.Clones(), chain, approvals, agentLoop.MaxCloneDepth))
+			}
+			if slices.Contains(agentLoop.Tools, "mcp") && useMCPTools {
+				agentTools = append(agentTools, workflow.MCPAgentTools(mcpHub, mcpAllowlists(selectedAgent, data.Tools)...)...)
+			}
+			workflow.RunAgentLoop(ctx, response, flusher, selectedAgent, userQuestion, agentTools, approvals, agentLoop.MaxSteps)
+			metrics.ObserveStep("agent_loop", stepStart)
+			if interrupted("agent_loop") {
+				return
+			}
+		}
+		workflow.AddContextToMessages(selectedAgent, contextItems, userQuestion)
 
-		fmt.Println("🧠 number of messages in memory:", len(selectedAgent.Agent.Params.Messages))
+		ctx = logging.WithStep(ctx, "generation")
+		slog.InfoContext(ctx, "🧠 messages in memory", "count", len(selectedAgent.Agent.Params.Messages))
 
 		// STEP 5: generate the response using the selected Agent
-		helpers.ResponseLabelNewLine(response, flusher, "info", "Generating response...")
+		helpers.ResponseLabelNewLine(response, flusher, "info", "Generating response with "+selectedAgent.Name+"...")
 
+		stepStart = time.Now()
+		cloneName := strings.ToLower(selectedAgent.Name)
+		var timeToFirstToken time.Duration
+		promptTokens := rag.EstimateMessagesTokens(selectedAgent.Agent.Params.Messages)
+		_, completionSpan := tracing.Start(ctx, "chat.completion_stream",
+			attribute.String(tracing.AttrAgent, selectedAgent.Name),
+			attribute.String(tracing.AttrModel, selectedAgent.Agent.Params.Model),
+			attribute.Int("messages", len(selectedAgent.Agent.Params.Messages)),
+			attribute.Int(tracing.AttrInputTokens, promptTokens),
+		)
 		answer, errCompletion := selectedAgent.Agent.ChatCompletionStream(func(self *robby.Agent, content string, err error) error {
+			if timeToFirstToken == 0 && content != "" {
+				timeToFirstToken = time.Since(requestStart)
+				metrics.TimeToFirstToken.WithLabelValues(cloneName).Observe(timeToFirstToken.Seconds())
+				completionSpan.AddEvent("first token")
+			}
 			response.Write([]byte(content))
 
 			flusher.Flush()
-			if !shouldIStopTheCompletion {
-				return nil
-			} else {
+			if serverCtx.Err() != nil {
+				return errors.New("🚫 server shutting down")
+			}
+			if ctx.Err() != nil {
 				return errors.New("🚫 Cancelling request")
 			}
+			return nil
 		})
 
+		metrics.ObserveStep("generation", stepStart)
+		metrics.GeneratedTokens.WithLabelValues(cloneName).Add(float64(rag.EstimateTokens(answer)))
+		completionSpan.SetAttributes(
+			attribute.Int(tracing.AttrOutputTokens, rag.EstimateTokens(answer)),
+			attribute.Float64("time_to_first_token_seconds", timeToFirstToken.Seconds()),
+		)
+		tracing.End(completionSpan, errCompletion)
+		workflow.RecordChatUsage(ctx, selectedAgent.Name, selectedAgent.Agent.Params.Model, promptTokens, answer)
+		span.SetAttributes(attribute.String(tracing.AttrAgent, selectedAgent.Name))
+
 		if errCompletion != nil {
 			// TODO: handle error
+			slog.ErrorContext(ctx, "😡 completion failed", "error", errCompletion)
+			if serverCtx.Err() != nil {
+				metrics.Cancellations.WithLabelValues("shutdown").Inc()
+			} else if request.Context().Err() != nil {
+				metrics.Cancellations.WithLabelValues("client_disconnected").Inc()
+			} else if cancellation.IsCancelled(ctx) {
+				helpers.ResponseLabel(response, flusher, "info", "Request cancelled")
+			}
 		}
-		// NOTE: conversational memory, add the answer to the Agent's message
-		selectedAgent.Agent.Params.Messages = append(
-			selectedAgent.Agent.Params.Messages, openai.AssistantMessage(answer),
+		// NOTE: the stream is cancelled by the shutdown of the server (the partial answer is kept in the conversational memory)
+		if serverCtx.Err() != nil {
+			helpers.ResponseLabel(response, flusher, "warning", "The server is shutting down, the answer has been interrupted: please retry in a moment")
+		}
+		// NOTE: the sources of the MCP results are listed after the answer
+		if sources := workflow.FormatCitations(citations); sources != "" {
+			response.Write([]byte(sources))
+			flusher.Flush()
+			answer += sources
+		}
+		// NOTE: conversational memory, add the context, the question and the answer to the conversation of the session
+		chatSession.AddToHistory(selectedAgent.Name,
+			append(slices.Clone(selectedAgent.Agent.Params.Messages[historyLength:]), openai.AssistantMessage(answer))...,
 		)
+		// NOTE: the last event of the stream is the token usage of the request
+		helpers.ResponseLabel(response, flusher, "usage", workflow.FormatUsage(usageTracker.Summary()))
+
+	})
+
+	// Token usage since the start of the server: total, by agent, by model and by session
+	mux.HandleFunc("GET /usage", func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(map[string]any{
+			"total":    usage.Total(),
+			"sessions": usage.Sessions(),
+		})
+	})
 
+	// Token usage of a session
+	mux.HandleFunc("GET /usage/sessions/{sessionId}", func(response http.ResponseWriter, request *http.Request) {
+		summary, ok := usage.Session(request.PathValue("sessionId"))
+		if !ok {
+			http.Error(response, "no usage for this session", http.StatusNotFound)
+			return
+		}
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(summary)
 	})
 
-	// Cancel/Stop the generation of the completion
-	mux.HandleFunc("DELETE /cancel", func(response http.ResponseWriter, request *http.Request) {
-		shouldIStopTheCompletion = true
+	// Health: /healthz (the process is alive) and /readyz (the dependencies are available, see the health package)
+	readinessChecks := func() []health.Check {
+		checks := []health.Check{
+			health.StartupCheck(agentsCatalog),
+			health.ModelRunnerCheck(os.Getenv("DMR_BASE_URL")),
+			health.AgentsCheck(agentsCatalog.Agents(), agentsCatalog.Errors()),
+			health.ModelsCheck(os.Getenv("DMR_BASE_URL"), agentsCatalog.Agents()),
+			health.RAGCheck(agentsCatalog.Clones()),
+		}
+		return append(checks, health.MCPChecks(mcpHub)...)
+	}
+	mux.HandleFunc("GET /healthz", health.LivenessHandler())
+	mux.HandleFunc("GET /readyz", health.ReadinessHandler(readinessChecks, 5*time.Second))
+
+	// Prometheus metrics (see the metrics package)
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	// MCP server: the clones of Bob as MCP tools (streamable HTTP transport)
+	mux.Handle("/mcp", mcpserver.NewHTTPHandler(agentsCatalog.Clones))
+
+	// Approve or reject the pending tool calls of a /chat request, ex: {"tool_call_id": "call_1"} (optional)
+	resolveToolCalls := func(approved bool) http.HandlerFunc {
+		return func(response http.ResponseWriter, request *http.Request) {
+			var approvalRequest ApprovalRequest
+			if request.ContentLength > 0 {
+				if err := json.NewDecoder(request.Body).Decode(&approvalRequest); err != nil {
+					http.Error(response, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			resolved, err := approval.Resolve(request.PathValue("requestId"), approvalRequest.ToolCallID, approved)
+			if err != nil {
+				http.Error(response, err.Error(), http.StatusNotFound)
+				return
+			}
+			response.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(response).Encode(map[string]any{"approved": approved, "tool_calls": resolved})
+		}
+	}
+	mux.HandleFunc("POST /chat/{requestId}/approve", resolveToolCalls(true))
+	mux.HandleFunc("POST /chat/{requestId}/reject", resolveToolCalls(false))
+
+	// Settings of a session (ex: the web search toggle)
+	// NOTE: the session is not created (404 if it does not exist, it is created by PUT or by the first message)
+	mux.HandleFunc("GET /sessions/{sessionId}", func(response http.ResponseWriter, request *http.Request) {
+		chatSession, ok := session.Find(request.PathValue("sessionId"))
+		if !ok {
+			http.Error(response, "Unknown session", http.StatusNotFound)
+			return
+		}
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(chatSession.Settings())
+	})
+
+	// Update the settings of a session, ex: {"web_search": true} (null: use the default value of config.yml)
+	mux.HandleFunc("PUT /sessions/{sessionId}", func(response http.ResponseWriter, request *http.Request) {
+		var settings session.Settings
+		if err := json.NewDecoder(request.Body).Decode(&settings); err != nil {
+			http.Error(response, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		chatSession := session.Get(request.PathValue("sessionId"))
+		chatSession.SetWebSearch(settings.WebSearch)
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(chatSession.Settings())
+	})
+
+	// Clear the conversations of a session (the settings are kept)
+	mux.HandleFunc("POST /clear-history", func(response http.ResponseWriter, request *http.Request) {
+		var data ChatRequest
+		if err := json.NewDecoder(request.Body).Decode(&data); err != nil {
+			http.Error(response, "Error parsing JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if chatSession, ok := session.Find(data.SessionID); ok {
+			chatSession.ClearHistory()
+		}
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(map[string]string{"status": "cleared"})
+	})
+
+	// Cancel/Stop a /chat request (the id of the request is in the X-Request-Id header of its response, or set with requestId)
+	// NOTE: the other requests are not cancelled
+	mux.HandleFunc("DELETE /cancel/{requestId}", func(response http.ResponseWriter, request *http.Request) {
+		if !cancellation.Cancel(request.PathValue("requestId")) {
+			http.Error(response, "Unknown request", http.StatusNotFound)
+			return
+		}
+		metrics.Cancellations.WithLabelValues("cancel_endpoint").Inc()
 		helpers.ResponseLabel(response, response.(http.Flusher), "info", "Cancelling request...")
 	})
 
-	var errListening error
-	log.Println("🌍 http server is listening on: " + httpPort)
-	errListening = http.ListenAndServe(":"+httpPort, mux)
+	server := &http.Server{
+		Addr:        ":" + httpPort,
+		Handler:     metrics.Middleware(mux),
+		BaseContext: func(net.Listener) context.Context { return serverCtx },
+	}
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	errListening := make(chan error, 1)
+	go func() {
+		slog.Info("🌍 http server is listening", "port", httpPort)
+		errListening <- server.ListenAndServe()
+	}()
 
-	log.Fatal(errListening)
+	select {
+	case err := <-errListening:
+		slog.Error("😡 http server stopped", "error", err)
+		shutdownTracing(context.Background())
+		os.Exit(1)
+	case <-signalCtx.Done():
+	}
+
+	// Graceful shutdown: stop accepting requests, let the active streams finish (see config.yml),
+	// then cancel them with a final event
+	drainTimeout := config.Get().Shutdown.DrainTimeout
+	slog.Info("🛑 shutting down, draining the active streams", "drain_timeout", drainTimeout.String())
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Warn("⏱️ drain timeout is over, cancelling the active streams", "error", err)
+		cancelServerCtx()
+		// NOTE: the cancelled streams send their final event, then the remaining connections are closed
+		streamsDone := make(chan struct{})
+		go func() {
+			activeStreams.Wait()
+			close(streamsDone)
+		}()
+		select {
+		case <-streamsDone:
+		case <-time.After(5 * time.Second):
+			slog.Warn("⏱️ streams still active after their cancellation, closing the connections")
+		}
+		server.Close()
+	}
+	cancelServerCtx()
+
+	// NOTE: the sessions and the token usage are saved (the RAG memories are built from the documents at the startup),
+	// the MCP servers are closed (the stdio servers are terminated) and the pending spans are exported
+	if err := state.Save(config.Get().Shutdown.StateFile); err != nil {
+		slog.Error("😡 state not saved", "error", err)
+	}
+	mcpHub.Close()
+	tracingCtx, cancelTracing := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTracing()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		slog.Error("😡 tracing shutdown failed", "error", err)
+	}
+	slog.Info("👋 server stopped")
 
 }
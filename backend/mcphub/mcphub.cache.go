@@ -0,0 +1,175 @@
+package mcphub
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"we-are-legion/config"
+	"we-are-legion/metrics"
+)
+
+type cacheEntry struct {
+	key       string
+	result    string
+	expiresAt time.Time
+}
+
+// Cache is a LRU cache of the MCP tool results, with a TTL per tool (see config.MCPCacheConfig).
+type Cache struct {
+	mutex   sync.Mutex
+	config  config.MCPCacheConfig
+	entries map[string]*list.Element
+	order   *list.List // front: most recently used
+	size    int
+	hits    int
+	misses  int
+}
+
+// CacheStats contains the counters of the cache.
+type CacheStats struct {
+	Entries int `json:"entries"`
+	Bytes   int `json:"bytes"`
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+}
+
+// NewCache creates a cache of the MCP tool results.
+func NewCache(cacheConfig config.MCPCacheConfig) *Cache {
+	return &Cache{
+		config:  cacheConfig,
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// IsCacheable returns true if the results of the tool can be cached.
+func (cache *Cache) IsCacheable(toolName string) bool {
+	if cache == nil || cache.config.Disabled {
+		return false
+	}
+	return cache.config.Tools[toolName].Cacheable
+}
+
+// Key returns the key of a tool call: the tool name and its normalized arguments
+// (the keys are sorted by encoding/json, the strings are trimmed and their spaces collapsed,
+// and lowercased if the tool ignores the case).
+func (cache *Cache) Key(toolName string, arguments map[string]any) string {
+	ignoreCase := cache.config.Tools[toolName].IgnoreCase
+	data, err := json.Marshal(normalizeArgument(arguments, ignoreCase))
+	if err != nil {
+		return ""
+	}
+	return toolName + ":" + string(data)
+}
+
+func normalizeArgument(value any, ignoreCase bool) any {
+	switch typedValue := value.(type) {
+	case string:
+		normalized := strings.Join(strings.Fields(typedValue), " ")
+		if ignoreCase {
+			normalized = strings.ToLower(normalized)
+		}
+		return normalized
+	case map[string]any:
+		normalized := map[string]any{}
+		for key, item := range typedValue {
+			normalized[key] = normalizeArgument(item, ignoreCase)
+		}
+		return normalized
+	case []any:
+		normalized := []any{}
+		for _, item := range typedValue {
+			normalized = append(normalized, normalizeArgument(item, ignoreCase))
+		}
+		return normalized
+	default:
+		return value
+	}
+}
+
+// Get returns the cached result of a tool call, if it exists and has not expired.
+func (cache *Cache) Get(key string) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.misses++
+		return "", false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		cache.remove(element)
+		cache.misses++
+		return "", false
+	}
+	cache.order.MoveToFront(element)
+	cache.hits++
+	return entry.result, true
+}
+
+// Set adds the result of a tool call to the cache, and evicts the least recently used entries
+// if the cache is too big. A result bigger than the max bytes is not cached.
+func (cache *Cache) Set(toolName string, key string, result string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.config.MaxBytes > 0 && len(result) > cache.config.MaxBytes {
+		return
+	}
+	ttl := cache.config.Tools[toolName].TTL
+	if ttl == 0 {
+		ttl = cache.config.TTL
+	}
+	if element, ok := cache.entries[key]; ok {
+		cache.remove(element)
+	}
+	element := cache.order.PushFront(&cacheEntry{key: key, result: result, expiresAt: time.Now().Add(ttl)})
+	cache.entries[key] = element
+	cache.size += len(result)
+
+	for cache.order.Len() > 0 &&
+		((cache.config.MaxEntries > 0 && cache.order.Len() > cache.config.MaxEntries) ||
+			(cache.config.MaxBytes > 0 && cache.size > cache.config.MaxBytes)) {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *Cache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	cache.order.Remove(element)
+	delete(cache.entries, entry.key)
+	cache.size -= len(entry.result)
+}
+
+// CallToolCached calls a tool like CallTool, but the results of the cacheable tools are cached
+// (the errors are not cached). It returns true if the result comes from the cache.
+func (hub *Hub) CallToolCached(ctx context.Context, toolName string, arguments map[string]any) (string, bool, error) {
+	if !hub.cache.IsCacheable(toolName) {
+		result, err := hub.CallTool(ctx, toolName, arguments)
+		return result, false, err
+	}
+	key := hub.cache.Key(toolName, arguments)
+	if key == "" {
+		result, err := hub.CallTool(ctx, toolName, arguments)
+		return result, false, err
+	}
+	if result, ok := hub.cache.Get(key); ok {
+		metrics.MCPToolCalls.WithLabelValues(hub.ServerOf(toolName), toolName, "cache_hit").Inc()
+		return result, true, nil
+	}
+	result, err := hub.CallTool(ctx, toolName, arguments)
+	if err != nil {
+		return "", false, err
+	}
+	hub.cache.Set(toolName, key, result)
+	return result, false, nil
+}
+
+// Stats returns the counters of the cache.
+func (cache *Cache) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return CacheStats{Entries: cache.order.Len(), Bytes: cache.size, Hits: cache.hits, Misses: cache.misses}
+}
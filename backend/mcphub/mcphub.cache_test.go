@@ -0,0 +1,187 @@
+package mcphub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"we-are-legion/config"
+)
+
+func newTestCache(maxEntries int, maxBytes int) *Cache {
+	return NewCache(config.MCPCacheConfig{
+		TTL:        time.Minute,
+		MaxEntries: maxEntries,
+		MaxBytes:   maxBytes,
+		Tools: map[string]config.ToolCacheConfig{
+			"brave_web_search": {Cacheable: true, IgnoreCase: true},
+			"fetch":            {Cacheable: true, TTL: time.Hour},
+			"write_file":       {Cacheable: false},
+		},
+	})
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    string
+		wantTTL time.Duration
+	}{
+		{"TTL of the cache", "brave_web_search", time.Minute},
+		{"TTL of the tool", "fetch", time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newTestCache(10, 0)
+			before := time.Now()
+			cache.Set(test.tool, "key", "result")
+			expiresAt := cache.entries["key"].Value.(*cacheEntry).expiresAt
+			if expiresAt.Before(before.Add(test.wantTTL)) || expiresAt.After(time.Now().Add(test.wantTTL)) {
+				t.Errorf("the entry expires in %s, want %s", expiresAt.Sub(before), test.wantTTL)
+			}
+			if result, ok := cache.Get("key"); !ok || result != "result" {
+				t.Errorf("Get() = %q, %v before the expiration", result, ok)
+			}
+
+			// NOTE: the expired entries are removed when they are read
+			cache.entries["key"].Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+			if _, ok := cache.Get("key"); ok {
+				t.Error("Get() returns an expired entry")
+			}
+			if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 || stats.Hits != 1 || stats.Misses != 1 {
+				t.Errorf("Stats() = %+v after the expiration", stats)
+			}
+		})
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int
+		results    []string // cached in order, "a" is read before the last one is cached
+		wantKeys   []string
+	}{
+		{"max entries", 2, 0, []string{"1", "2", "3"}, []string{"a", "c"}},
+		{"max bytes", 0, 10, []string{"12345", "1234", "123"}, []string{"a", "c"}},
+		{"result bigger than max bytes", 0, 4, []string{"12", "1", "12345"}, []string{"a", "b"}},
+		{"no limit", 0, 0, []string{"1", "2", "3"}, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newTestCache(test.maxEntries, test.maxBytes)
+			keys := []string{"a", "b", "c"}
+			for index, result := range test.results {
+				if index == len(test.results)-1 {
+					// NOTE: "a" is the most recently used entry, "b" is the least recently used one
+					cache.Get("a")
+				}
+				cache.Set("brave_web_search", keys[index], result)
+			}
+			for _, key := range keys {
+				_, cached := cache.entries[key]
+				if want := strings.Contains(strings.Join(test.wantKeys, ","), key); cached != want {
+					t.Errorf("%s cached = %v, want %v", key, cached, want)
+				}
+			}
+			if test.maxBytes > 0 && cache.Stats().Bytes > test.maxBytes {
+				t.Errorf("the cache has %d bytes, want at most %d", cache.Stats().Bytes, test.maxBytes)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cache := newTestCache(10, 0)
+	tests := []struct {
+		name   string
+		tool   string
+		first  map[string]any
+		second map[string]any
+		same   bool
+	}{
+		{"order of the arguments", "fetch",
+			map[string]any{"url": "https://docs.docker.com", "max_length": 100},
+			map[string]any{"max_length": 100, "url": "https://docs.docker.com"}, true},
+		{"spaces are collapsed", "fetch",
+			map[string]any{"url": " https://docs.docker.com "}, map[string]any{"url": "https://docs.docker.com"}, true},
+		{"ignore case", "brave_web_search",
+			map[string]any{"query": "Docker  Compose"}, map[string]any{"query": "docker compose"}, true},
+		{"case of the tools without ignore_case", "fetch",
+			map[string]any{"url": "https://docs.docker.com/Compose"}, map[string]any{"url": "https://docs.docker.com/compose"}, false},
+		{"nested arguments", "brave_web_search",
+			map[string]any{"query": "compose", "filters": []any{"Docs", map[string]any{"lang": "EN"}}},
+			map[string]any{"filters": []any{"docs", map[string]any{"lang": "en"}}, "query": "compose"}, true},
+		{"different arguments", "brave_web_search",
+			map[string]any{"query": "compose"}, map[string]any{"query": "bake"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, second := cache.Key(test.tool, test.first), cache.Key(test.tool, test.second)
+			if (first == second) != test.same {
+				t.Errorf("Key() = %q and %q, same = %v, want %v", first, second, first == second, test.same)
+			}
+			if !strings.HasPrefix(first, test.tool+":") {
+				t.Errorf("Key() = %q, want the tool name as prefix", first)
+			}
+		})
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache *Cache
+		tool  string
+		want  bool
+	}{
+		{"cacheable tool", newTestCache(10, 0), "brave_web_search", true},
+		{"non cacheable tool", newTestCache(10, 0), "write_file", false},
+		{"tool without cache setting", newTestCache(10, 0), "echo", false},
+		{"disabled cache", NewCache(config.MCPCacheConfig{Disabled: true, Tools: map[string]config.ToolCacheConfig{"echo": {Cacheable: true}}}), "echo", false},
+		{"no cache", nil, "brave_web_search", false},
+	}
+	for _, test := range tests {
+		if got := test.cache.IsCacheable(test.tool); got != test.want {
+			t.Errorf("%s: IsCacheable(%s) = %v, want %v", test.name, test.tool, got, test.want)
+		}
+	}
+}
+
+func TestCallToolCached(t *testing.T) {
+	hub := newStubHub(t, httpStubConfig(t))
+	hub.SetCache(NewCache(config.MCPCacheConfig{
+		TTL: time.Minute,
+		Tools: map[string]config.ToolCacheConfig{
+			"brave_web_search": {Cacheable: true, IgnoreCase: true},
+			"fail":             {Cacheable: true},
+		},
+	}))
+	tests := []struct {
+		name       string
+		tool       string
+		first      map[string]any
+		second     map[string]any
+		wantCached bool
+	}{
+		{"cacheable tool", "brave_web_search", map[string]any{"query": "Compose"}, map[string]any{"query": "compose "}, true},
+		{"non cacheable tool", "echo", map[string]any{"text": "hello"}, map[string]any{"text": "hello"}, false},
+		{"the errors are not cached", "fail", map[string]any{"text": "oops"}, map[string]any{"text": "oops"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, cached, _ := hub.CallToolCached(context.Background(), test.tool, test.first)
+			if cached {
+				t.Fatal("the first call comes from the cache")
+			}
+			second, cached, _ := hub.CallToolCached(context.Background(), test.tool, test.second)
+			if cached != test.wantCached {
+				t.Errorf("the second call comes from the cache = %v, want %v", cached, test.wantCached)
+			}
+			if test.wantCached && second != first {
+				t.Errorf("cached result = %q, want %q", second, first)
+			}
+		})
+	}
+}
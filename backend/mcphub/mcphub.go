@@ -0,0 +1,580 @@
+package mcphub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"we-are-legion/config"
+	"we-are-legion/metrics"
+	"we-are-legion/tracing"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Server is a MCP server of the hub, with its client session and its tools.
+// The (re)connections of a server are serialized (see connectServer): the previous session is closed before the new one is opened.
+type Server struct {
+	Name       string
+	Config     config.MCPServerConfig
+	session    *mcp.ClientSession
+	cancel     context.CancelFunc // cancels the context of the session (see connectServer)
+	tools      []*mcp.Tool
+	err        error
+	restarts   int
+	lastCheck  time.Time
+	connecting sync.Mutex
+}
+
+// ServerStatus is the state of a MCP server of the hub.
+type ServerStatus struct {
+	Name      string    `json:"name"`
+	Transport string    `json:"transport"`
+	Connected bool      `json:"connected"`
+	Tools     []string  `json:"tools"`
+	Restarts  int       `json:"restarts"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Hub manages the connections to the MCP servers of the configuration,
+// and dispatches the tool calls to the server providing the tool.
+// Once started (see Start), the hub checks the health of the servers and reconnects them when they crash.
+type Hub struct {
+	mutex          sync.RWMutex
+	servers        map[string]*Server
+	reconnect      chan string
+	stop           context.CancelFunc
+	cache          *Cache
+	connectTimeout time.Duration
+}
+
+// NewHub creates a hub for the MCP servers (the connections are opened by Connect).
+func NewHub(servers map[string]config.MCPServerConfig) *Hub {
+	hub := &Hub{
+		servers:        map[string]*Server{},
+		reconnect:      make(chan string, len(servers)),
+		connectTimeout: 10 * time.Second,
+	}
+	for name, serverConfig := range servers {
+		hub.servers[name] = &Server{Name: name, Config: serverConfig}
+	}
+	return hub
+}
+
+var (
+	defaultHub     *Hub
+	defaultHubOnce sync.Once
+)
+
+// Get returns the hub of the MCP servers of the configuration, it is connected on the first call.
+func Get() *Hub {
+	defaultHubOnce.Do(func() {
+		defaultHub = NewHub(config.Get().MCP.Servers)
+		defaultHub.SetCache(NewCache(config.Get().MCP.Cache))
+		defaultHub.SetConnectTimeout(config.Get().MCP.ConnectTimeout)
+		// NOTE: an unreachable server must not block the startup, it is reconnected by the supervisor
+		ctx, cancel := context.WithTimeout(context.Background(), config.Get().MCP.ConnectTimeout)
+		defer cancel()
+		defaultHub.Connect(ctx)
+		defaultHub.Start(config.Get().MCP.HealthCheckInterval)
+	})
+	return defaultHub
+}
+
+// SetCache sets the cache of the tool results used by CallToolCached (nil: no cache).
+func (hub *Hub) SetCache(cache *Cache) {
+	hub.cache = cache
+}
+
+// SetConnectTimeout sets the maximum duration of the connection to a server (0: the default duration, 10s).
+func (hub *Hub) SetConnectTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		hub.connectTimeout = timeout
+	}
+}
+
+// Cache returns the cache of the tool results (nil if there is no cache).
+func (hub *Hub) Cache() *Cache {
+	return hub.cache
+}
+
+// NewTransport creates the MCP transport of a server configuration.
+func NewTransport(serverConfig config.MCPServerConfig) (mcp.Transport, error) {
+	switch serverConfig.Transport {
+	case "stdio":
+		if len(serverConfig.Command) == 0 {
+			return nil, errors.New("missing command for the stdio transport")
+		}
+		return &mcp.CommandTransport{Command: exec.Command(serverConfig.Command[0], serverConfig.Command[1:]...)}, nil
+	case "socat":
+		if serverConfig.Address == "" {
+			return nil, errors.New("missing address for the socat transport")
+		}
+		return &mcp.CommandTransport{Command: exec.Command("socat", "STDIO", "TCP:"+serverConfig.Address)}, nil
+	case "http":
+		if serverConfig.URL == "" {
+			return nil, errors.New("missing url for the http transport")
+		}
+		return &mcp.StreamableClientTransport{Endpoint: serverConfig.URL}, nil
+	case "sse":
+		if serverConfig.URL == "" {
+			return nil, errors.New("missing url for the sse transport")
+		}
+		return &mcp.SSEClientTransport{Endpoint: serverConfig.URL}, nil
+	default:
+		return nil, fmt.Errorf("unknown MCP transport: %s", serverConfig.Transport)
+	}
+}
+
+// Connect opens the connections to the MCP servers concurrently and fetches their tools.
+// A server which fails to connect (or does not answer before the connect timeout or the end of the context)
+// is skipped, its error is kept (see Errors).
+func (hub *Hub) Connect(ctx context.Context) {
+	var waitGroup sync.WaitGroup
+	for _, name := range hub.ServerNames() {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			server := hub.server(name)
+			server.connecting.Lock()
+			defer server.connecting.Unlock()
+			if err := hub.connectServer(ctx, name); err != nil {
+				slog.Error("😡 MCP server failed", "server", name, "error", err)
+				metrics.MCPErrors.WithLabelValues(name, "connection").Inc()
+			}
+		}()
+	}
+	waitGroup.Wait()
+}
+
+// connectServer (re)connects a server: the previous session is closed (the process of a stdio server is terminated),
+// then a new one is opened. The connection is opened without holding the lock of the hub,
+// the tool calls to the other servers are not blocked, but the connections of a server are serialized.
+// The caller must hold the connecting lock of the server.
+func (hub *Hub) connectServer(ctx context.Context, name string) error {
+	hub.mutex.Lock()
+	server := hub.servers[name]
+	previousSession, previousCancel := server.session, server.cancel
+	server.session, server.cancel, server.tools = nil, nil, nil
+	serverConfig := server.Config
+	hub.mutex.Unlock()
+
+	if previousSession != nil {
+		previousSession.Close()
+	}
+	if previousCancel != nil {
+		previousCancel()
+	}
+
+	// NOTE: the session outlives the context of the caller (ex: the request of a tool call restarting a crashed server),
+	// the SSE transport keeps the context for its stream: it is only cancelled if the connection is not opened in time
+	sessionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	timeout := time.AfterFunc(hub.connectTimeout, cancel)
+	stopAfterFunc := context.AfterFunc(ctx, cancel)
+	session, tools, err := connect(sessionCtx, serverConfig)
+	timeout.Stop()
+	stopAfterFunc()
+	if err == nil && sessionCtx.Err() != nil {
+		session.Close()
+		err = fmt.Errorf("failed to connect: %w", sessionCtx.Err())
+	}
+	if err != nil {
+		cancel()
+	}
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	server.err = err
+	server.lastCheck = time.Now()
+	if err != nil {
+		return err
+	}
+	server.session = session
+	server.cancel = cancel
+	server.tools = tools
+
+	toolNames := []string{}
+	for _, tool := range tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+	slog.Info("🔌 MCP server connected", "server", name, "transport", serverConfig.Transport, "tools", strings.Join(toolNames, ", "))
+
+	// NOTE: watch the session to detect a crash (ex: the process of a stdio server exits)
+	go hub.watch(name, session)
+	return nil
+}
+
+// connect opens a client session to a MCP server and fetches its tools.
+func connect(ctx context.Context, serverConfig config.MCPServerConfig) (*mcp.ClientSession, []*mcp.Tool, error) {
+	transport, err := NewTransport(serverConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := mcp.NewClient(&mcp.Implementation{Name: "bob", Version: "1.0.0"}, nil)
+	session, err := client.Connect(ctx, transport, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	tools, err := session.ListTools(ctx, nil)
+	if err != nil {
+		session.Close()
+		return nil, nil, fmt.Errorf("failed to list the tools: %w", err)
+	}
+	return session, tools.Tools, nil
+}
+
+// watch waits for the end of a session, and asks for a reconnection if it is still the session of the server.
+func (hub *Hub) watch(name string, session *mcp.ClientSession) {
+	err := session.Wait()
+
+	hub.mutex.Lock()
+	server := hub.servers[name]
+	crashed := server.session == session
+	if crashed {
+		server.session, server.tools = nil, nil
+		server.err = fmt.Errorf("connection closed: %v", err)
+	}
+	hub.mutex.Unlock()
+
+	if crashed {
+		slog.Warn("💥 MCP server connection closed", "server", name, "error", err)
+		metrics.MCPErrors.WithLabelValues(name, "connection").Inc()
+		select {
+		case hub.reconnect <- name:
+		default:
+		}
+	}
+}
+
+// Start runs the supervisor of the servers in the background:
+// every interval, the servers are pinged and the disconnected ones are restarted,
+// a crashed server is restarted immediately.
+func (hub *Hub) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	hub.mutex.Lock()
+	hub.stop = cancel
+	hub.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case name := <-hub.reconnect:
+				// NOTE: the session has been cleared by watch
+				hub.restartServer(ctx, name, nil)
+			case <-ticker.C:
+				for _, name := range hub.ServerNames() {
+					if session, err := hub.checkServer(ctx, name); err != nil {
+						slog.Warn("🩺 MCP server is unhealthy", "server", name, "error", err)
+						hub.restartServer(ctx, name, session)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// checkServer pings a server, it returns the pinged session (nil if the server is not connected).
+func (hub *Hub) checkServer(ctx context.Context, name string) (*mcp.ClientSession, error) {
+	hub.mutex.RLock()
+	session := hub.servers[name].session
+	hub.mutex.RUnlock()
+	if session == nil {
+		return nil, errors.New("not connected")
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := session.Ping(pingCtx, nil)
+
+	hub.mutex.Lock()
+	hub.servers[name].lastCheck = time.Now()
+	hub.mutex.Unlock()
+	return session, err
+}
+
+// ListTools asks the tools of a server (tools/list), ex: to check the readiness of the server.
+// It returns the names of the tools.
+func (hub *Hub) ListTools(ctx context.Context, name string) ([]string, error) {
+	hub.mutex.RLock()
+	server, ok := hub.servers[name]
+	var session *mcp.ClientSession
+	if ok {
+		session = server.session
+	}
+	hub.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown MCP server: %s", name)
+	}
+	if session == nil {
+		return nil, errors.New("not connected")
+	}
+
+	result, err := session.ListTools(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	toolNames := []string{}
+	for _, tool := range result.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+	return toolNames, nil
+}
+
+// restartServer reconnects a server if its session is still the failed one (nil: the server is disconnected).
+// The supervisor and the tool calls can detect the same failure at the same time:
+// the first one restarts the server, the others use the new session.
+func (hub *Hub) restartServer(ctx context.Context, name string, failedSession *mcp.ClientSession) {
+	server := hub.server(name)
+	server.connecting.Lock()
+	defer server.connecting.Unlock()
+
+	hub.mutex.Lock()
+	if server.session != nil && server.session != failedSession {
+		hub.mutex.Unlock()
+		slog.Debug("🔌 MCP server already restarted", "server", name)
+		return
+	}
+	server.restarts++
+	hub.mutex.Unlock()
+
+	if err := hub.connectServer(ctx, name); err != nil {
+		slog.Error("😡 MCP server restart failed", "server", name, "error", err)
+		metrics.MCPErrors.WithLabelValues(name, "restart").Inc()
+	}
+}
+
+func (hub *Hub) server(name string) *Server {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.servers[name]
+}
+
+// ServerNames returns the sorted names of the servers of the hub.
+func (hub *Hub) ServerNames() []string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.sortedServerNames()
+}
+
+// Status returns the state of the servers, sorted by name.
+func (hub *Hub) Status() []ServerStatus {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	statuses := []ServerStatus{}
+	for _, name := range hub.sortedServerNames() {
+		server := hub.servers[name]
+		status := ServerStatus{
+			Name:      name,
+			Transport: server.Config.Transport,
+			Connected: server.session != nil,
+			Tools:     []string{},
+			Restarts:  server.restarts,
+			LastCheck: server.lastCheck,
+		}
+		for _, tool := range server.tools {
+			status.Tools = append(status.Tools, tool.Name)
+		}
+		if server.err != nil {
+			status.Error = server.err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Errors returns the connection errors of the servers.
+func (hub *Hub) Errors() map[string]error {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	errs := map[string]error{}
+	for name, server := range hub.servers {
+		if server.err != nil {
+			errs[name] = server.err
+		}
+	}
+	return errs
+}
+
+// isAllowed checks if a tool of a server matches the allowlist
+// (tool name, server/* for all the tools of a server, or server/tool).
+func isAllowed(serverName string, toolName string, allowlist []string) bool {
+	for _, allowed := range allowlist {
+		if allowed == toolName || allowed == serverName+"/*" || allowed == serverName+"/"+toolName {
+			return true
+		}
+	}
+	return false
+}
+
+func isAllowedByAll(serverName string, toolName string, allowlists [][]string) bool {
+	for _, allowlist := range allowlists {
+		if !isAllowed(serverName, toolName, allowlist) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tools returns the tools of the connected servers matching all the allowlists, in the OpenAI format
+// (ex: the allowlist of the selected clone and the tools requested in the /chat body).
+// If two servers provide a tool with the same name, the first server (by name) wins.
+func (hub *Hub) Tools(allowlists ...[]string) []openai.ChatCompletionToolParam {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	tools := []openai.ChatCompletionToolParam{}
+	seen := map[string]bool{}
+	for _, name := range hub.sortedServerNames() {
+		server := hub.servers[name]
+		for _, tool := range server.tools {
+			if seen[tool.Name] || !isAllowedByAll(server.Name, tool.Name, allowlists) {
+				continue
+			}
+			seen[tool.Name] = true
+			tools = append(tools, ConvertToOpenAITool(tool))
+		}
+	}
+	return tools
+}
+
+func (hub *Hub) sortedServerNames() []string {
+	names := []string{}
+	for name := range hub.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConvertToOpenAITool converts a MCP tool to an OpenAI tool.
+func ConvertToOpenAITool(tool *mcp.Tool) openai.ChatCompletionToolParam {
+	parameters := openai.FunctionParameters{"type": "object", "properties": map[string]any{}}
+	if data, err := json.Marshal(tool.InputSchema); err == nil {
+		var schema map[string]any
+		if json.Unmarshal(data, &schema) == nil && schema != nil {
+			parameters = schema
+		}
+	}
+	return openai.ChatCompletionToolParam{
+		Function: openai.FunctionDefinitionParam{
+			Name:        tool.Name,
+			Description: openai.String(tool.Description),
+			Parameters:  parameters,
+		},
+	}
+}
+
+// findServer returns the first connected server (by name) providing the tool, and its session.
+func (hub *Hub) findServer(toolName string) (string, *mcp.ClientSession, error) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for _, name := range hub.sortedServerNames() {
+		server := hub.servers[name]
+		if server.session == nil {
+			continue
+		}
+		for _, tool := range server.tools {
+			if tool.Name == toolName {
+				return server.Name, server.session, nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("no MCP server provides the tool %s", toolName)
+}
+
+// ServerOf returns the name of the first connected server providing the tool (empty if there is none).
+func (hub *Hub) ServerOf(toolName string) string {
+	serverName, _, _ := hub.findServer(toolName)
+	return serverName
+}
+
+// CallTool calls a tool on the server providing it and returns the text content of the result.
+// The calls are counted and timed (see the mcp_* metrics), and traced (see the tracing package).
+func (hub *Hub) CallTool(ctx context.Context, toolName string, arguments map[string]any) (string, error) {
+	ctx, span := tracing.Start(ctx, "mcp.call_tool", attribute.String("mcp.tool", toolName))
+	start := time.Now()
+	text, serverName, err := hub.callTool(ctx, toolName, arguments)
+	metrics.ObserveMCPToolCall(serverName, toolName, start, err)
+	span.SetAttributes(attribute.String("mcp.server", serverName), attribute.Int("result_length", len(text)))
+	tracing.End(span, err)
+	return text, err
+}
+
+func (hub *Hub) callTool(ctx context.Context, toolName string, arguments map[string]any) (string, string, error) {
+	serverName, session, err := hub.findServer(toolName)
+	if err != nil {
+		return "", serverName, err
+	}
+	result, err := session.CallTool(ctx, &mcp.CallToolParams{
+		Name:      toolName,
+		Arguments: arguments,
+	})
+	if errors.Is(err, mcp.ErrConnectionClosed) {
+		// NOTE: the server crashed, restart it and retry once
+		slog.Warn("💥 MCP server connection closed, restarting...", "server", serverName)
+		hub.restartServer(ctx, serverName, session)
+		if serverName, session, err = hub.findServer(toolName); err != nil {
+			return "", serverName, err
+		}
+		result, err = session.CallTool(ctx, &mcp.CallToolParams{
+			Name:      toolName,
+			Arguments: arguments,
+		})
+	}
+	if err != nil {
+		return "", serverName, fmt.Errorf("%s/%s: %w", serverName, toolName, err)
+	}
+
+	texts := []string{}
+	for _, content := range result.Content {
+		if textContent, ok := content.(*mcp.TextContent); ok {
+			texts = append(texts, textContent.Text)
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if result.IsError {
+		return "", serverName, fmt.Errorf("%s/%s: %s", serverName, toolName, text)
+	}
+	return text, serverName, nil
+}
+
+// Close stops the supervisor and closes the connections to the MCP servers (the stdio servers are terminated).
+func (hub *Hub) Close() {
+	hub.mutex.Lock()
+	if hub.stop != nil {
+		hub.stop()
+	}
+	sessions := []*mcp.ClientSession{}
+	cancels := []context.CancelFunc{}
+	for _, server := range hub.servers {
+		if server.session != nil {
+			sessions = append(sessions, server.session)
+			cancels = append(cancels, server.cancel)
+			server.session, server.cancel, server.tools = nil, nil, nil
+		}
+	}
+	hub.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
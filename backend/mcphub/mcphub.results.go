@@ -0,0 +1,101 @@
+package mcphub
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// WebResult is a search result extracted from the result of a MCP tool.
+type WebResult struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet"`
+}
+
+// ParseWebResults extracts the search results (title, URL, snippet) of the result of a MCP tool:
+//   - JSON: an array of results, or an object with a "results" array (or "web": {"results": [...]}).
+//   - text: blocks of "Title: ...", "Description: ..." and "URL: ..." lines (the Brave search MCP server).
+//
+// It returns nil if the result does not contain search results.
+func ParseWebResults(text string) []WebResult {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		var data any
+		if json.Unmarshal([]byte(text), &data) == nil {
+			return parseJSONResults(data)
+		}
+	}
+	return parseTextResults(text)
+}
+
+func parseJSONResults(data any) []WebResult {
+	switch typedData := data.(type) {
+	case []any:
+		results := []WebResult{}
+		for _, item := range typedData {
+			object, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			result := WebResult{
+				Title:   firstString(object, "title", "name"),
+				URL:     firstString(object, "url", "link", "href"),
+				Snippet: firstString(object, "description", "snippet", "content", "text"),
+			}
+			if result.URL != "" || result.Title != "" {
+				results = append(results, result)
+			}
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		return results
+	case map[string]any:
+		if web, ok := typedData["web"].(map[string]any); ok {
+			return parseJSONResults(web["results"])
+		}
+		if results, ok := typedData["results"]; ok {
+			return parseJSONResults(results)
+		}
+	}
+	return nil
+}
+
+func firstString(object map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := object[key].(string); ok && value != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func parseTextResults(text string) []WebResult {
+	results := []WebResult{}
+	current := WebResult{}
+	flush := func() {
+		if current.URL != "" || current.Title != "" {
+			results = append(results, current)
+		}
+		current = WebResult{}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Title:"):
+			if current.Title != "" {
+				flush()
+			}
+			current.Title = strings.TrimSpace(strings.TrimPrefix(line, "Title:"))
+		case strings.HasPrefix(line, "Description:"):
+			current.Snippet = strings.TrimSpace(strings.TrimPrefix(line, "Description:"))
+		case strings.HasPrefix(line, "URL:"):
+			current.URL = strings.TrimSpace(strings.TrimPrefix(line, "URL:"))
+		}
+	}
+	flush()
+	if len(results) == 0 {
+		return nil
+	}
+	return results
+}
@@ -0,0 +1,50 @@
+package mcphub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWebResults(t *testing.T) {
+	// NOTE: a (shortened) response of the Brave Search API
+	braveJSON := `{
+		"type": "search",
+		"query": {"original": "docker compose profiles"},
+		"web": {
+			"type": "search",
+			"results": [
+				{"title": "Using profiles with Compose", "url": "https://docs.docker.com/compose/how-tos/profiles/", "description": " Profiles help you adjust your Compose application. ", "language": "en"},
+				{"title": "Compose file reference", "url": "https://docs.docker.com/reference/compose-file/", "description": "The Compose file."}
+			]
+		}
+	}`
+	braveText := "Title: Using profiles with Compose\nDescription: Profiles help you adjust your Compose application.\nURL: https://docs.docker.com/compose/how-tos/profiles/\n\n" +
+		"Title: Compose file reference\nDescription: The Compose file.\nURL: https://docs.docker.com/reference/compose-file/"
+	want := []WebResult{
+		{Title: "Using profiles with Compose", URL: "https://docs.docker.com/compose/how-tos/profiles/", Snippet: "Profiles help you adjust your Compose application."},
+		{Title: "Compose file reference", URL: "https://docs.docker.com/reference/compose-file/", Snippet: "The Compose file."},
+	}
+	tests := []struct {
+		name   string
+		result string
+		want   []WebResult
+	}{
+		{"Brave Search API", braveJSON, want},
+		{"Brave search MCP server (text)", braveText, want},
+		{"array of results", `[{"name": "Bake", "link": "https://docs.docker.com/build/bake/", "snippet": "Bake builds"}, "ignored", {"id": 1}]`,
+			[]WebResult{{Title: "Bake", URL: "https://docs.docker.com/build/bake/", Snippet: "Bake builds"}}},
+		{"object with results", `{"results": [{"title": "Model Runner", "href": "https://docs.docker.com/ai/model-runner/"}]}`,
+			[]WebResult{{Title: "Model Runner", URL: "https://docs.docker.com/ai/model-runner/"}}},
+		{"not JSON", "Docker Compose is a tool for defining and running multi-container applications.", nil},
+		{"invalid JSON", `{"web": {"results": [`, nil},
+		{"JSON without results", `{"error": "rate limited"}`, nil},
+		{"empty result", "", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseWebResults(test.result); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseWebResults() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package mcphub
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"we-are-legion/config"
+	"we-are-legion/internal/mcpstub"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+// NOTE: the test binary is the stub MCP server of the stdio transport (see stdioStubConfig)
+func TestMain(m *testing.M) {
+	if os.Getenv("MCPHUB_STUB") == "stdio" {
+		if err := mcpstub.RunStdio(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func stdioStubConfig(t *testing.T) config.MCPServerConfig {
+	t.Setenv("MCPHUB_STUB", "stdio")
+	return config.MCPServerConfig{Transport: "stdio", Command: []string{os.Args[0], "-test.run=^$"}}
+}
+
+func httpStubConfig(t *testing.T) config.MCPServerConfig {
+	server := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return mcpstub.NewServer() }, nil))
+	t.Cleanup(server.Close)
+	return config.MCPServerConfig{Transport: "http", URL: server.URL}
+}
+
+func sseStubConfig(t *testing.T) config.MCPServerConfig {
+	server := httptest.NewServer(mcp.NewSSEHandler(func(*http.Request) *mcp.Server { return mcpstub.NewServer() }, nil))
+	t.Cleanup(server.Close)
+	return config.MCPServerConfig{Transport: "sse", URL: server.URL}
+}
+
+// socatStubConfig serves the stub MCP server on a TCP address (one session per connection).
+func socatStubConfig(t *testing.T) config.MCPServerConfig {
+	if _, err := exec.LookPath("socat"); err != nil {
+		t.Skip("socat is not installed")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go mcpstub.NewServer().Run(context.Background(), &mcp.IOTransport{Reader: connection, Writer: connection})
+		}
+	}()
+	return config.MCPServerConfig{Transport: "socat", Address: listener.Addr().String()}
+}
+
+func newStubHub(t *testing.T, serverConfig config.MCPServerConfig) *Hub {
+	hub := NewHub(map[string]config.MCPServerConfig{"stub": serverConfig})
+	hub.SetConnectTimeout(10 * time.Second)
+	t.Cleanup(hub.Close)
+	hub.Connect(context.Background())
+	if err := hub.Errors()["stub"]; err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	return hub
+}
+
+func TestTransports(t *testing.T) {
+	transports := []struct {
+		name   string
+		config func(t *testing.T) config.MCPServerConfig
+	}{
+		{"stdio", stdioStubConfig},
+		{"socat", socatStubConfig},
+		{"http", httpStubConfig},
+		{"sse", sseStubConfig},
+	}
+	for _, transport := range transports {
+		t.Run(transport.name, func(t *testing.T) {
+			hub := newStubHub(t, transport.config(t))
+
+			tools, err := hub.ListTools(context.Background(), "stub")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tools) != 4 {
+				t.Errorf("ListTools() = %v, want the 4 tools of the stub", tools)
+			}
+			if definitions := hub.Tools([]string{"stub/*"}); len(definitions) != 4 {
+				t.Errorf("Tools(stub/*) returns %d tools, want 4", len(definitions))
+			}
+			if serverName := hub.ServerOf("echo"); serverName != "stub" {
+				t.Errorf("ServerOf(echo) = %q, want stub", serverName)
+			}
+
+			text, err := hub.CallTool(context.Background(), "echo", map[string]any{"text": "hello"})
+			if err != nil || text != "hello" {
+				t.Errorf("CallTool(echo) = %q, %v, want hello", text, err)
+			}
+			if _, err := hub.CallTool(context.Background(), "unknown", nil); err == nil {
+				t.Error("CallTool(unknown) must fail")
+			}
+			if _, err := hub.CallTool(context.Background(), "fail", map[string]any{"text": "oops"}); err == nil || !strings.Contains(err.Error(), "failure: oops") {
+				t.Errorf("CallTool(fail) = %v, want the error of the tool", err)
+			}
+		})
+	}
+}
+
+// NOTE: the session of the stub must outlive the context of the connection (the SSE transport keeps it for its stream)
+func TestConnectContextEnds(t *testing.T) {
+	hub := NewHub(map[string]config.MCPServerConfig{"stub": sseStubConfig(t)})
+	t.Cleanup(hub.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	hub.Connect(ctx)
+	cancel()
+
+	text, err := hub.CallTool(context.Background(), "echo", map[string]any{"text": "still connected"})
+	if err != nil || text != "still connected" {
+		t.Errorf("CallTool(echo) = %q, %v after the end of the context of the connection", text, err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	// NOTE: the server accepts the connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	server := &http.Server{Handler: http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	hub := NewHub(map[string]config.MCPServerConfig{"silent": {Transport: "sse", URL: "http://" + listener.Addr().String()}})
+	hub.SetConnectTimeout(200 * time.Millisecond)
+	defer hub.Close()
+
+	start := time.Now()
+	hub.Connect(context.Background())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Connect() took %s, want about the connect timeout", elapsed)
+	}
+	if hub.Errors()["silent"] == nil {
+		t.Error("the silent server must have a connection error")
+	}
+}
+
+// NOTE: the tool calls and the supervisor detect the crash at the same time, the server must be restarted once
+func TestRestartAfterCrash(t *testing.T) {
+	hub := newStubHub(t, stdioStubConfig(t))
+	hub.Start(time.Hour)
+
+	if _, err := hub.CallTool(context.Background(), "crash", nil); err == nil {
+		t.Fatal("CallTool(crash) must fail")
+	}
+
+	var waitGroup sync.WaitGroup
+	errs := make(chan error, 5)
+	for range 5 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			// NOTE: the server can be restarting, retry until the restarted server answers
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				text, err := hub.CallTool(context.Background(), "echo", map[string]any{"text": "back"})
+				if err == nil && text == "back" {
+					return
+				}
+				if time.Now().After(deadline) {
+					errs <- err
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+	}
+	waitGroup.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("CallTool(echo) after the restart: %v", err)
+	}
+
+	status := hub.Status()[0]
+	if !status.Connected {
+		t.Errorf("the server is not connected after the restart: %+v", status)
+	}
+	if status.Restarts != 1 {
+		t.Errorf("Restarts = %d, want 1 (the concurrent restarts of a server must be merged)", status.Restarts)
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		tool      string
+		allowlist []string
+		want      bool
+	}{
+		{"tool name", "docker-mcp-toolkit", "brave_web_search", []string{"brave_web_search"}, true},
+		{"tool name of any server", "stub", "brave_web_search", []string{"brave_web_search"}, true},
+		{"all the tools of the server", "filesystem", "write_file", []string{"brave_web_search", "filesystem/*"}, true},
+		{"all the tools of another server", "stub", "write_file", []string{"filesystem/*"}, false},
+		{"tool of the server", "filesystem", "read_file", []string{"filesystem/read_file"}, true},
+		{"other tool of the server", "filesystem", "write_file", []string{"filesystem/read_file"}, false},
+		{"same tool of another server", "stub", "read_file", []string{"filesystem/read_file"}, false},
+		{"empty allowlist", "stub", "echo", []string{}, false},
+		{"no partial match", "stub", "echo", []string{"ech", "stub/", "*"}, false},
+	}
+	for _, test := range tests {
+		if got := isAllowed(test.server, test.tool, test.allowlist); got != test.want {
+			t.Errorf("%s: isAllowed(%s, %s, %v) = %v, want %v", test.name, test.server, test.tool, test.allowlist, got, test.want)
+		}
+	}
+}
+
+func TestTools(t *testing.T) {
+	// NOTE: two servers with the same tools, the first server by name wins
+	hub := NewHub(map[string]config.MCPServerConfig{"stub": httpStubConfig(t), "alpha": httpStubConfig(t)})
+	hub.SetConnectTimeout(10 * time.Second)
+	t.Cleanup(hub.Close)
+	hub.Connect(context.Background())
+	if len(hub.Errors()) > 0 {
+		t.Fatalf("connection failed: %v", hub.Errors())
+	}
+
+	tests := []struct {
+		name       string
+		allowlists [][]string
+		want       []string
+	}{
+		{"no allowlist", nil, []string{"brave_web_search", "crash", "echo", "fail"}},
+		{"tool names", [][]string{{"echo", "fail", "unknown"}}, []string{"echo", "fail"}},
+		{"all the tools of a server", [][]string{{"stub/*"}}, []string{"brave_web_search", "crash", "echo", "fail"}},
+		{"tool of a server", [][]string{{"stub/echo", "alpha/fail"}}, []string{"echo", "fail"}},
+		{"all the allowlists must match", [][]string{{"alpha/*"}, {"echo", "brave_web_search"}}, []string{"brave_web_search", "echo"}},
+		{"empty allowlist", [][]string{{}}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := []string{}
+			for _, tool := range hub.Tools(test.allowlists...) {
+				names = append(names, tool.Function.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, test.want) {
+				t.Errorf("Tools() = %v, want %v", names, test.want)
+			}
+		})
+	}
+	if serverName := hub.ServerOf("echo"); serverName != "alpha" {
+		t.Errorf("ServerOf(echo) = %q, want alpha (the first server by name)", serverName)
+	}
+}
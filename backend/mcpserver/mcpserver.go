@@ -0,0 +1,138 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/workflow"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+)
+
+type askInput struct {
+	Question string `json:"question" jsonschema:"the question to ask to the clone"`
+}
+
+type searchDocsInput struct {
+	Clone string `json:"clone" jsonschema:"the name of the clone: bob (Docker), bill (Docker Compose), garfield (Docker Model Runner) or milo (Docker Bake)"`
+	Query string `json:"query" jsonschema:"the search query"`
+}
+
+// NewServer creates a MCP server exposing the clones of Bob as tools:
+//   - ask_<clone>: asks a question to a clone (with its RAG memory).
+//   - search_docs: searches the documents of the RAG memory of a clone.
+func NewServer(clones map[string]*agents.AgentConfig) *mcp.Server {
+	server := mcp.NewServer(&mcp.Implementation{Name: "bob", Version: "1.0.0"}, nil)
+
+	cloneNames := []string{}
+	for cloneName := range clones {
+		cloneNames = append(cloneNames, cloneName)
+	}
+	sort.Strings(cloneNames)
+
+	for _, cloneName := range cloneNames {
+		clone := clones[cloneName]
+		mcp.AddTool(server, &mcp.Tool{
+			Name:        "ask_" + cloneName,
+			Description: fmt.Sprintf("Ask a question to %s: %s", clone.Name, cloneExpertise(cloneName)),
+		}, func(ctx context.Context, request *mcp.CallToolRequest, input askInput) (*mcp.CallToolResult, any, error) {
+			if strings.TrimSpace(input.Question) == "" {
+				return nil, nil, errors.New("the question is empty")
+			}
+			slog.InfoContext(ctx, "❓ question", "agent", clone.Name, "question", input.Question)
+			answer, _, err := workflow.AskClone(ctx, clone, input.Question, clone.Retrieval)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s failed to answer: %w", clone.Name, err)
+			}
+			return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: answer}}}, nil, nil
+		})
+	}
+
+	mcp.AddTool(server, &mcp.Tool{
+		Name:        "search_docs",
+		Description: "Search the documents of a clone of Bob: " + strings.Join(cloneNames, ", "),
+	}, func(ctx context.Context, request *mcp.CallToolRequest, input searchDocsInput) (*mcp.CallToolResult, any, error) {
+		clone, ok := clones[strings.ToLower(input.Clone)]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown clone: %s (available clones: %s)", input.Clone, strings.Join(cloneNames, ", "))
+		}
+		similarities, err := workflow.SearchDocuments(ctx, clone, input.Query, clone.Retrieval)
+		if err != nil {
+			return nil, nil, fmt.Errorf("search failed: %w", err)
+		}
+		data, err := json.MarshalIndent(similarities, "", "  ")
+		if err != nil {
+			return nil, nil, err
+		}
+		return &mcp.CallToolResult{Content: []mcp.Content{&mcp.TextContent{Text: string(data)}}}, nil, nil
+	})
+
+	return server
+}
+
+func cloneExpertise(cloneName string) string {
+	switch cloneName {
+	case "bob":
+		return "Docker expert"
+	case "bill":
+		return "Docker Compose expert"
+	case "garfield":
+		return "Docker Model Runner expert"
+	case "milo":
+		return "Docker Bake expert"
+	default:
+		return "clone of Bob"
+	}
+}
+
+// NewHTTPHandler returns the handler of the streamable HTTP transport of the MCP server.
+// A server is created for every MCP session with the clones available at this time
+// (ex: a clone initialized again in the background, see workflow.Catalog).
+func NewHTTPHandler(clones func() map[string]*agents.AgentConfig) http.Handler {
+	return mcp.NewStreamableHTTPHandler(func(request *http.Request) *mcp.Server { return NewServer(clones()) }, nil)
+}
+
+/*
+Run runs the backend as a MCP server exposing the clones of Bob:
+  - over stdio by default: ./web-chat-bot mcp serve
+  - over streamable HTTP: ./web-chat-bot mcp serve -http :8090 (the endpoint is /mcp)
+
+NOTE: the /mcp endpoint is also available on the HTTP server of the backend.
+*/
+func Run(args []string) error {
+	flags := flag.NewFlagSet("mcp serve", flag.ContinueOnError)
+	httpAddress := flags.String("http", "", "serve the streamable HTTP transport on this address")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// NOTE: with the stdio transport, stdout is used by the MCP protocol:
+	// the logs are written to stderr (see logging.Setup), stdout is redirected to stderr for the other outputs
+	stdout := os.Stdout
+	if *httpAddress == "" {
+		os.Stdout = os.Stderr
+	}
+
+	clones, err := workflow.InitializeClones()
+	if err != nil {
+		return err
+	}
+	server := NewServer(clones)
+
+	if *httpAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/mcp", NewHTTPHandler(func() map[string]*agents.AgentConfig { return clones }))
+		slog.Info("🌍 MCP server is listening", "address", *httpAddress+"/mcp")
+		return http.ListenAndServe(*httpAddress, mux)
+	}
+	slog.Info("🌍 MCP server is listening on stdio")
+	return server.Run(context.Background(), &mcp.IOTransport{Reader: os.Stdin, Writer: stdout})
+}
@@ -0,0 +1,201 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"we-are-legion/agents"
+	"we-are-legion/config"
+	"we-are-legion/rag"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// fakeModelRunner answers the embeddings (a vector of the keywords of the text)
+// and the chat completions (the answer repeats the question) of the model runner, and keeps the prompts.
+type fakeModelRunner struct {
+	mutex   sync.Mutex
+	prompts [][]map[string]any
+}
+
+func (runner *fakeModelRunner) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(request.URL.Path, "/embeddings"):
+		var body struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(request.Body).Decode(&body)
+		embedding := []float64{}
+		for _, keyword := range []string{"compose", "bake", "model"} {
+			if strings.Contains(strings.ToLower(body.Input), keyword) {
+				embedding = append(embedding, 1)
+			} else {
+				embedding = append(embedding, 0)
+			}
+		}
+		json.NewEncoder(response).Encode(map[string]any{
+			"object": "list",
+			"model":  "embed",
+			"data":   []map[string]any{{"object": "embedding", "index": 0, "embedding": embedding}},
+			"usage":  map[string]int{"prompt_tokens": 3, "total_tokens": 3},
+		})
+	case strings.HasSuffix(request.URL.Path, "/chat/completions"):
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		json.NewDecoder(request.Body).Decode(&body)
+		runner.mutex.Lock()
+		runner.prompts = append(runner.prompts, body.Messages)
+		runner.mutex.Unlock()
+		question := body.Messages[len(body.Messages)-1]["content"]
+		json.NewEncoder(response).Encode(map[string]any{
+			"id":     "completion",
+			"object": "chat.completion",
+			"model":  "fake",
+			"choices": []map[string]any{{
+				"index": 0, "finish_reason": "stop",
+				"message": map[string]any{"role": "assistant", "content": "answer to: " + question.(string)},
+			}},
+		})
+	default:
+		http.NotFound(response, request)
+	}
+}
+
+// newClone creates a clone with a RAG memory built from a document, and a conversation of another user in its agent.
+func newClone(t *testing.T, baseURL string, name string, document string) *agents.AgentConfig {
+	agent, err := robby.NewAgent(
+		robby.WithDMRClient(context.Background(), baseURL+"/engines/llama.cpp/v1"),
+		robby.WithParams(openai.ChatCompletionNewParams{Model: "fake"}),
+		robby.WithEmbeddingParams(openai.EmbeddingNewParams{Model: "embed"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persona := []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are " + name)}
+	agent.Params.Messages = append(slices.Clone(persona),
+		openai.UserMessage("the question of another user"),
+		openai.AssistantMessage("the answer to another user"),
+	)
+	source := strings.ToLower(name) + "/docs.md"
+	if err := rag.BuildMemory(context.Background(), name, agent, rag.ChunkDocument(source, document, 1000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	return &agents.AgentConfig{
+		Name:      name,
+		Agent:     agent,
+		Persona:   persona,
+		Retrieval: config.RetrievalConfig{SimilarityThreshold: 0.5, TopK: 3},
+	}
+}
+
+// connect connects an in-memory MCP client to the MCP server of the clones.
+func connect(t *testing.T, clones map[string]*agents.AgentConfig) *mcp.ClientSession {
+	serverTransport, clientTransport := mcp.NewInMemoryTransports()
+	serverSession, err := NewServer(clones).Connect(context.Background(), serverTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { serverSession.Close() })
+	client := mcp.NewClient(&mcp.Implementation{Name: "test", Version: "1.0.0"}, nil)
+	clientSession, err := client.Connect(context.Background(), clientTransport, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clientSession.Close() })
+	return clientSession
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	texts := []string{}
+	for _, content := range result.Content {
+		if text, ok := content.(*mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func TestServer(t *testing.T) {
+	runner := &fakeModelRunner{}
+	server := httptest.NewServer(runner)
+	t.Cleanup(server.Close)
+	t.Setenv("DMR_BASE_URL", server.URL)
+
+	clones := map[string]*agents.AgentConfig{
+		"bill": newClone(t, server.URL, "Bill", "## Profiles\nThe compose profiles enable the services.\n"),
+		"milo": newClone(t, server.URL, "Milo", "## Targets\nThe bake targets build the images.\n"),
+	}
+	session := connect(t, clones)
+
+	tools, err := session.ListTools(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolNames := []string{}
+	for _, tool := range tools.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+	slices.Sort(toolNames)
+	if !slices.Equal(toolNames, []string{"ask_bill", "ask_milo", "search_docs"}) {
+		t.Errorf("ListTools() = %v, want ask_bill, ask_milo and search_docs", toolNames)
+	}
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments map[string]any
+		isError   bool
+		want      []string
+	}{
+		{"ask a clone", "ask_bill", map[string]any{"question": "How do I use the compose profiles?"}, false,
+			[]string{"answer to: How do I use the compose profiles?"}},
+		{"empty question", "ask_milo", map[string]any{"question": " "}, true, []string{"the question is empty"}},
+		{"search the docs of a clone", "search_docs", map[string]any{"clone": "Bill", "query": "compose profiles"}, false,
+			[]string{`"source": "bill/docs.md"`, "The compose profiles enable the services."}},
+		{"search without result", "search_docs", map[string]any{"clone": "milo", "query": "compose profiles"}, false,
+			[]string{"[]"}},
+		{"unknown clone", "search_docs", map[string]any{"clone": "garfield", "query": "model runner"}, true,
+			[]string{"unknown clone: garfield (available clones: bill, milo)"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := session.CallTool(context.Background(), &mcp.CallToolParams{Name: test.tool, Arguments: test.arguments})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.IsError != test.isError {
+				t.Errorf("IsError = %v, want %v: %s", result.IsError, test.isError, resultText(result))
+			}
+			for _, expected := range test.want {
+				if !strings.Contains(resultText(result), expected) {
+					t.Errorf("the result %q does not contain %q", resultText(result), expected)
+				}
+			}
+		})
+	}
+
+	// NOTE: the clone answers with its persona and its documents, without the conversations of the users
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if len(runner.prompts) != 1 {
+		t.Fatalf("%d chat completions, want 1", len(runner.prompts))
+	}
+	prompt, _ := json.Marshal(runner.prompts[0])
+	for _, expected := range []string{"You are Bill", "The compose profiles enable the services."} {
+		if !strings.Contains(string(prompt), expected) {
+			t.Errorf("the prompt %s does not contain %q", prompt, expected)
+		}
+	}
+	if strings.Contains(string(prompt), "another user") {
+		t.Errorf("the prompt contains the conversation of another user: %s", prompt)
+	}
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// NOTE: the metrics are exposed by the /metrics endpoint of the backend (see Handler),
+// the names are prefixed with "bob_".
+const namespace = "bob"
+
+// Buckets of the latencies of the models (in seconds): the completions last from a few hundred milliseconds to minutes.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120}
+
+var (
+	// HTTPRequests counts the HTTP requests by method, route (ex: "POST /chat") and status code.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	// HTTPRequestDuration observes the duration of the HTTP requests (the streamed answers included).
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the HTTP requests by method and route.",
+		Buckets:   latencyBuckets,
+	}, []string{"method", "route"})
+
+	// StepDuration observes the duration of the steps of the /chat pipeline
+	// (tool_detection, mcp_execution, tool_execution, similarity_search, agent_loop, generation).
+	StepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_step_duration_seconds",
+		Help:      "Duration of the steps of the chat pipeline.",
+		Buckets:   latencyBuckets,
+	}, []string{"step"})
+
+	// TimeToFirstToken observes the time between the reception of a /chat request and the first streamed token of the answer.
+	TimeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_time_to_first_token_seconds",
+		Help:      "Time between the reception of a chat request and the first token of the answer, by clone.",
+		Buckets:   latencyBuckets,
+	}, []string{"clone"})
+
+	// GeneratedTokens counts the tokens of the streamed answers (estimated, ~4 characters per token).
+	GeneratedTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_generated_tokens_total",
+		Help:      "Number of tokens generated by the clones (estimated).",
+	}, []string{"clone"})
+
+	// RoutingDecisions counts the clone selected to answer each /chat request.
+	RoutingDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_routing_decisions_total",
+		Help:      "Number of chat requests answered by each clone.",
+	}, []string{"clone"})
+
+	// RAGSearches counts the similarity searches by clone and result ("hit": at least one document found, "miss").
+	RAGSearches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rag_searches_total",
+		Help:      "Number of similarity searches by clone and result (hit or miss).",
+	}, []string{"clone", "result"})
+
+	// RAGDocuments counts the documents found by the similarity searches.
+	RAGDocuments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rag_documents_total",
+		Help:      "Number of documents found by the similarity searches, by clone.",
+	}, []string{"clone"})
+
+	// MCPToolCalls counts the calls of the MCP tools by server, tool and status ("ok", "error" or "cache_hit").
+	MCPToolCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mcp_tool_calls_total",
+		Help:      "Number of MCP tool calls by server, tool and status (ok, error, cache_hit).",
+	}, []string{"server", "tool", "status"})
+
+	// MCPToolCallDuration observes the duration of the calls of the MCP tools (the cache hits are not observed).
+	MCPToolCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mcp_tool_call_duration_seconds",
+		Help:      "Duration of the MCP tool calls by server and tool.",
+		Buckets:   latencyBuckets,
+	}, []string{"server", "tool"})
+
+	// MCPErrors counts the errors of the MCP servers by server and kind ("tool_call", "connection" or "restart").
+	MCPErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mcp_errors_total",
+		Help:      "Number of errors of the MCP servers by server and kind (tool_call, connection, restart).",
+	}, []string{"server", "kind"})
+
+	// Cancellations counts the cancelled answers by reason ("cancel_endpoint": DELETE /cancel, "client_disconnected", "shutdown": the drain timeout of the shutdown is over).
+	Cancellations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_cancellations_total",
+		Help:      "Number of cancelled answers by reason.",
+	}, []string{"reason"})
+)
+
+// ObserveStep observes the duration of a step of the /chat pipeline since start.
+func ObserveStep(step string, start time.Time) {
+	StepDuration.WithLabelValues(step).Observe(time.Since(start).Seconds())
+}
+
+// ObserveMCPToolCall counts a call of a MCP tool and observes its duration since start.
+func ObserveMCPToolCall(server, tool string, start time.Time, err error) {
+	MCPToolCallDuration.WithLabelValues(server, tool).Observe(time.Since(start).Seconds())
+	if err != nil {
+		MCPToolCalls.WithLabelValues(server, tool, "error").Inc()
+		MCPErrors.WithLabelValues(server, "tool_call").Inc()
+		return
+	}
+	MCPToolCalls.WithLabelValues(server, tool, "ok").Inc()
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler returns the handler of the /metrics endpoint (Prometheus text format).
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware counts the HTTP requests and observes their duration.
+// The route is the pattern of the ServeMux (ex: "POST /chat/{requestId}/approve"), not the path, to keep a bounded number of series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: response, status: http.StatusOK}
+		next.ServeHTTP(recorder, request)
+
+		// NOTE: the ServeMux sets the pattern of the request when it routes it
+		route := request.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(request.Method, route, strconv.Itoa(recorder.status)).Inc()
+		HTTPRequestDuration.WithLabelValues(request.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder keeps the status code of the response.
+// It is still a http.Flusher: the answers of /chat are streamed.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if !recorder.wroteHeader {
+		recorder.status = status
+		recorder.wroteHeader = true
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	recorder.wroteHeader = true
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap is used by http.ResponseController (ex: the streamable HTTP transport of the MCP server).
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /chat", func(response http.ResponseWriter, request *http.Request) {
+		response.WriteHeader(http.StatusServiceUnavailable)
+		response.Write([]byte("warming up"))
+	})
+	mux.HandleFunc("POST /chat/{requestId}/approve", func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte("approved"))
+		// NOTE: the answers of /chat are streamed, the recorder must still be a http.Flusher
+		if _, ok := response.(http.Flusher); !ok {
+			t.Error("the response writer of the middleware is not a http.Flusher")
+		}
+	})
+	handler := Middleware(mux)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		route  string
+		code   string
+	}{
+		{"status code of the handler", http.MethodPost, "/chat", "POST /chat", "503"},
+		{"route pattern instead of the path", http.MethodPost, "/chat/request-1/approve", "POST /chat/{requestId}/approve", "200"},
+		{"unknown route", http.MethodGet, "/unknown", "unmatched", "404"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := HTTPRequests.WithLabelValues(test.method, test.route, test.code)
+			before := testutil.ToFloat64(requests)
+			durationsBefore, _ := observations(t, HTTPRequestDuration.WithLabelValues(test.method, test.route))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+
+			if got := testutil.ToFloat64(requests) - before; got != 1 {
+				t.Errorf("%g requests %s %s %s, want 1", got, test.method, test.route, test.code)
+			}
+			if durations, _ := observations(t, HTTPRequestDuration.WithLabelValues(test.method, test.route)); durations != durationsBefore+1 {
+				t.Error("the duration of the request is not observed")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// observations returns the number and the sum of the observations of a histogram.
+func observations(t *testing.T, histogram prometheus.Observer) (uint64, float64) {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := histogram.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestObserveStep(t *testing.T) {
+	tests := []struct {
+		step     string
+		duration time.Duration
+	}{
+		{"tool_detection", 0},
+		{"similarity_search", 100 * time.Millisecond},
+		{"generation", 2 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.step, func(t *testing.T) {
+			countBefore, sumBefore := observations(t, StepDuration.WithLabelValues(test.step))
+			ObserveStep(test.step, time.Now().Add(-test.duration))
+			count, sum := observations(t, StepDuration.WithLabelValues(test.step))
+			if count != countBefore+1 {
+				t.Errorf("%d observations, want %d", count, countBefore+1)
+			}
+			if latency := sum - sumBefore; latency < test.duration.Seconds() || latency > test.duration.Seconds()+1 {
+				t.Errorf("observed latency = %gs, want about %s", latency, test.duration)
+			}
+		})
+	}
+}
+
+func TestObserveMCPToolCall(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus string
+		wantErrors float64
+	}{
+		{"successful call", nil, "ok", 0},
+		{"failed call", errors.New("failure"), "error", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := MCPToolCalls.WithLabelValues("test-server", "echo", test.wantStatus)
+			toolErrors := MCPErrors.WithLabelValues("test-server", "tool_call")
+			callsBefore, errorsBefore := testutil.ToFloat64(calls), testutil.ToFloat64(toolErrors)
+			durationsBefore, _ := observations(t, MCPToolCallDuration.WithLabelValues("test-server", "echo"))
+
+			ObserveMCPToolCall("test-server", "echo", time.Now(), test.err)
+
+			if got := testutil.ToFloat64(calls) - callsBefore; got != 1 {
+				t.Errorf("%g calls with the status %s, want 1", got, test.wantStatus)
+			}
+			if got := testutil.ToFloat64(toolErrors) - errorsBefore; got != test.wantErrors {
+				t.Errorf("%g tool call errors, want %g", got, test.wantErrors)
+			}
+			if durations, _ := observations(t, MCPToolCallDuration.WithLabelValues("test-server", "echo")); durations != durationsBefore+1 {
+				t.Errorf("the duration of the call is not observed")
+			}
+		})
+	}
+}
+
+func TestCancellations(t *testing.T) {
+	reasons := map[string]int{"cancel_endpoint": 2, "client_disconnected": 1, "shutdown": 3}
+	before := map[string]float64{}
+	for reason := range reasons {
+		before[reason] = testutil.ToFloat64(Cancellations.WithLabelValues(reason))
+	}
+	for reason, count := range reasons {
+		for range count {
+			Cancellations.WithLabelValues(reason).Inc()
+		}
+	}
+	for reason, count := range reasons {
+		if got := testutil.ToFloat64(Cancellations.WithLabelValues(reason)) - before[reason]; got != float64(count) {
+			t.Errorf("%g cancellations %s, want %d", got, reason, count)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Chunk is a piece of a clone document with the information about where it comes from.
+type Chunk struct {
+	ID       string   `json:"id"`       // source and index of the chunk in the document (ex: bob/docker-qa.md#3)
+	Source   string   `json:"source"`   // path of the document, relative to the docs folder (ex: bob/docker-qa.md)
+	Start    int      `json:"start"`    // offset of the first byte of the chunk in the document
+	End      int      `json:"end"`      // offset of the byte following the chunk in the document
+	Sections []string `json:"sections"` // markdown headings of the sections overlapped by the chunk
+	Content  string   `json:"content"`
+}
+
+// NOTE: robby only keeps the text of the chunks in the RAG memory,
+// this index allows to find the source of a chunk returned by a similarity search:
+// the key is the id of the chunk, it is the id of its vector record (see BuildMemory),
+// the same content in two documents (or two clones) are two chunks.
+var (
+	chunksIndex      = map[string]Chunk{}
+	chunksIndexMutex sync.RWMutex
+)
+
+// ChunkDocument divides a document into chunks (like ChunkText)
+// and keeps track of the source, the offsets and the sections of every chunk.
+//
+// Parameters:
+//   - source: The path of the document, relative to the docs folder.
+//   - text: The content of the document.
+//   - chunkSize: The size of each chunk.
+//   - overlap: The amount of overlap between consecutive chunks.
+//
+// Returns:
+//   - []Chunk: A slice of chunks, each chunk is also registered in the chunks index.
+func ChunkDocument(source string, text string, chunkSize, overlap int) []Chunk {
+	sections := GetSections(text)
+
+	chunks := []Chunk{}
+	for start := 0; start < len(text); start += chunkSize - overlap {
+		end := start + chunkSize
+		if end > len(text) {
+			end = len(text)
+		}
+		chunk := Chunk{
+			ID:      fmt.Sprintf("%s#%d", source, len(chunks)),
+			Source:  source,
+			Start:   start,
+			End:     end,
+			Content: text[start:end],
+		}
+		for _, section := range sections {
+			if section.Start < end && start < section.End {
+				chunk.Sections = append(chunk.Sections, section.Title)
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	chunksIndexMutex.Lock()
+	defer chunksIndexMutex.Unlock()
+	for _, chunk := range chunks {
+		chunksIndex[chunk.ID] = chunk
+	}
+	return chunks
+}
+
+// GetChunk returns the registered chunk with the given id.
+func GetChunk(id string) (Chunk, bool) {
+	chunksIndexMutex.RLock()
+	defer chunksIndexMutex.RUnlock()
+	chunk, ok := chunksIndex[id]
+	return chunk, ok
+}
+
+// Section is a part of a markdown document starting with a "## " heading.
+type Section struct {
+	Title string
+	Start int
+	End   int
+}
+
+// GetSections returns the "## " sections of a markdown document.
+// The title of a section is the heading text without the "## " prefix and the numbering (ex: "1. ").
+func GetSections(text string) []Section {
+	sections := []Section{}
+	offset := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if strings.HasPrefix(line, "## ") {
+			if len(sections) > 0 {
+				sections[len(sections)-1].End = offset
+			}
+			sections = append(sections, Section{
+				Title: SectionTitle(line),
+				Start: offset,
+			})
+		}
+		offset += len(line)
+	}
+	if len(sections) > 0 {
+		sections[len(sections)-1].End = len(text)
+	}
+	return sections
+}
+
+// SectionTitle removes the "## " prefix and the numbering of a markdown heading.
+func SectionTitle(heading string) string {
+	title := strings.TrimSpace(strings.TrimPrefix(heading, "## "))
+	if number, rest, found := strings.Cut(title, ". "); found && strings.Trim(number, "0123456789") == "" {
+		title = rest
+	}
+	return title
+}
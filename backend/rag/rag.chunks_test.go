@@ -0,0 +1,42 @@
+package rag
+
+import "testing"
+
+func TestChunkDocumentKeepsTheSourceOfIdenticalChunks(t *testing.T) {
+	text := "## Volumes\nA volume keeps the data.\n"
+	bobChunks := ChunkDocument("bob/volumes.md", text, 512, 0)
+	billChunks := ChunkDocument("bill/volumes.md", text, 512, 0)
+
+	tests := []struct {
+		chunk  Chunk
+		source string
+	}{
+		{bobChunks[0], "bob/volumes.md"},
+		{billChunks[0], "bill/volumes.md"},
+	}
+	for _, test := range tests {
+		chunk, ok := GetChunk(test.chunk.ID)
+		if !ok {
+			t.Fatalf("chunk %s is not registered", test.chunk.ID)
+		}
+		if chunk.Source != test.source {
+			t.Errorf("chunk %s: source = %s, want %s", test.chunk.ID, chunk.Source, test.source)
+		}
+	}
+	if bobChunks[0].ID == billChunks[0].ID {
+		t.Errorf("identical chunks of two documents have the same id: %s", bobChunks[0].ID)
+	}
+}
+
+func TestChunkDocumentIDs(t *testing.T) {
+	chunks := ChunkDocument("milo/bake.md", "0123456789", 4, 1)
+	want := []string{"milo/bake.md#0", "milo/bake.md#1", "milo/bake.md#2", "milo/bake.md#3"}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for index, chunk := range chunks {
+		if chunk.ID != want[index] {
+			t.Errorf("chunk %d: id = %s, want %s", index, chunk.ID, want[index])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"we-are-legion/usage"
+
+	"github.com/sea-monkeys/robby"
+)
+
+// IndexingProgress is the progress of the embeddings of the chunks of a RAG memory.
+type IndexingProgress struct {
+	Chunks   int `json:"chunks"`
+	Embedded int `json:"embedded"`
+	Failed   int `json:"failed,omitempty"`
+}
+
+// NOTE: the embedding slots are shared by all the RAG memories built at the same time (see SetEmbeddingParallelism).
+var (
+	embeddingSlots = make(chan struct{}, 4)
+	indexing       = map[string]IndexingProgress{}
+	indexingMutex  sync.Mutex
+)
+
+// SetEmbeddingParallelism sets the maximum number of embeddings of chunks created at the same time (see BuildMemory).
+// It must be called before building the RAG memories.
+func SetEmbeddingParallelism(parallelism int) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	embeddingSlots = make(chan struct{}, parallelism)
+}
+
+// Indexing returns the progress of the RAG memory of an agent (false if it has never been built).
+func Indexing(agentName string) (IndexingProgress, bool) {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+	progress, ok := indexing[strings.ToLower(agentName)]
+	return progress, ok
+}
+
+func updateIndexing(agentName string, update func(progress *IndexingProgress)) {
+	indexingMutex.Lock()
+	defer indexingMutex.Unlock()
+	progress := indexing[strings.ToLower(agentName)]
+	update(&progress)
+	indexing[strings.ToLower(agentName)] = progress
+}
+
+// BuildMemory creates the RAG memory of the agent with the embeddings of the chunks.
+// Unlike robby.WithRAGMemory, the embeddings are created concurrently (see SetEmbeddingParallelism)
+// and their progress is reported (see Indexing). The token usage is recorded for the agent (see usage.Add).
+// A chunk that fails is skipped, it returns an error if no chunk has been embedded (ex: the model runner is down).
+// The id of a vector record is the id of its chunk (see GetChunk).
+func BuildMemory(ctx context.Context, agentName string, agent *robby.Agent, chunks []Chunk) error {
+	ctx = usage.WithAgent(ctx, agentName)
+	updateIndexing(agentName, func(progress *IndexingProgress) {
+		*progress = IndexingProgress{Chunks: len(chunks)}
+	})
+
+	slots := embeddingSlots
+	embeddings := make([][]float64, len(chunks))
+	var waitGroup sync.WaitGroup
+	var firstError error
+	var errorOnce sync.Once
+	for index, chunk := range chunks {
+		waitGroup.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer waitGroup.Done()
+			defer func() { <-slots }()
+			embedding, err := CreateEmbedding(ctx, agent, chunk.Content)
+			updateIndexing(agentName, func(progress *IndexingProgress) {
+				if err != nil {
+					progress.Failed++
+				} else {
+					progress.Embedded++
+				}
+			})
+			if err != nil {
+				errorOnce.Do(func() { firstError = err })
+				return
+			}
+			embeddings[index] = embedding
+		}()
+	}
+	waitGroup.Wait()
+
+	// NOTE: the store of robby is not safe for concurrent use, the records are saved once all the embeddings are created
+	agent.Store = robby.MemoryVectorStore{Records: map[string]robby.VectorRecord{}}
+	for index, embedding := range embeddings {
+		if embedding == nil {
+			continue
+		}
+		agent.Store.Save(robby.VectorRecord{Id: chunks[index].ID, Prompt: chunks[index].Content, Embedding: embedding})
+	}
+
+	if firstError != nil {
+		progress, _ := Indexing(agentName)
+		if progress.Embedded == 0 {
+			return fmt.Errorf("no embedding created for the %d chunks: %w", len(chunks), firstError)
+		}
+		slog.Warn("😡 chunks not embedded", "agent", agentName, "failed", progress.Failed, "chunks", len(chunks), "error", firstError)
+	}
+	slog.Info("📚 RAG memory built", "agent", agentName, "records", len(agent.Store.Records))
+	return nil
+}
@@ -0,0 +1,143 @@
+package rag
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// EstimateTokens returns an estimation of the number of tokens of a text (~4 characters per token).
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// EstimateMessagesTokens returns an estimation of the number of tokens of the contents of the messages (ex: a prompt).
+func EstimateMessagesTokens(messages []openai.ChatCompletionMessageParamUnion) int {
+	tokens := 0
+	for _, message := range messages {
+		if content, ok := message.GetContent().AsAny().(*string); ok && content != nil {
+			tokens += EstimateTokens(*content)
+			continue
+		}
+		// NOTE: the other contents (ex: the tool calls of the assistant) are estimated with their JSON
+		data, _ := json.Marshal(message)
+		tokens += EstimateTokens(string(data))
+	}
+	return tokens
+}
+
+// DuplicateThreshold is the Jaccard similarity above which two retrieved spans are near-duplicates (see MergeChunks).
+const DuplicateThreshold = 0.9
+
+// MergeChunks merges the retrieved chunks coming from the same source into contiguous spans
+// (the chunks overlap, see ChunkDocument), then removes the near-duplicates.
+// The order of the first appearance of every span is kept (the best scores first).
+//
+// Parameters:
+//   - chunks: The retrieved chunks (a chunk without source is kept as is).
+//   - duplicateThreshold: The Jaccard similarity (on words) above which two spans are near-duplicates.
+//
+// Returns:
+//   - []string: The merged spans.
+func MergeChunks(chunks []Chunk, duplicateThreshold float64) []string {
+	type span struct {
+		chunk Chunk
+		rank  int
+	}
+	spansBySource := map[string][]span{}
+	sources := []string{}
+	unknown := []span{}
+
+	for rank, chunk := range chunks {
+		if chunk.Source == "" {
+			// NOTE: the chunk does not come from ChunkDocument, keep it as is
+			unknown = append(unknown, span{chunk: chunk, rank: rank})
+			continue
+		}
+		if _, exists := spansBySource[chunk.Source]; !exists {
+			sources = append(sources, chunk.Source)
+		}
+		spansBySource[chunk.Source] = append(spansBySource[chunk.Source], span{chunk: chunk, rank: rank})
+	}
+
+	merged := []span{}
+	for _, source := range sources {
+		spans := spansBySource[source]
+		sort.Slice(spans, func(i, j int) bool {
+			return spans[i].chunk.Start < spans[j].chunk.Start
+		})
+		current := spans[0]
+		for _, next := range spans[1:] {
+			if next.chunk.Start <= current.chunk.End {
+				// NOTE: the chunks overlap (or touch), append the part of the next chunk after the current one
+				if next.chunk.End > current.chunk.End {
+					current.chunk.Content += next.chunk.Content[current.chunk.End-next.chunk.Start:]
+					current.chunk.End = next.chunk.End
+				}
+				current.rank = min(current.rank, next.rank)
+				continue
+			}
+			merged = append(merged, current)
+			current = next
+		}
+		merged = append(merged, current)
+	}
+	merged = append(merged, unknown...)
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].rank < merged[j].rank
+	})
+
+	results := []string{}
+	for _, candidate := range merged {
+		duplicate := false
+		for _, result := range results {
+			if JaccardSimilarity(candidate.chunk.Content, result) >= duplicateThreshold {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			results = append(results, candidate.chunk.Content)
+		}
+	}
+	return results
+}
+
+// JaccardSimilarity returns the Jaccard similarity of the sets of (lower case) words of two texts.
+func JaccardSimilarity(text1, text2 string) float64 {
+	words1 := map[string]bool{}
+	for _, word := range strings.Fields(strings.ToLower(text1)) {
+		words1[word] = true
+	}
+	words2 := map[string]bool{}
+	for _, word := range strings.Fields(strings.ToLower(text2)) {
+		words2[word] = true
+	}
+	if len(words1) == 0 && len(words2) == 0 {
+		return 1.0
+	}
+	intersection := 0
+	for word := range words1 {
+		if words2[word] {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(words1)+len(words2)-intersection)
+}
+
+// LimitTokens keeps the first texts fitting in the budget of tokens.
+func LimitTokens(texts []string, maxTokens int) []string {
+	results := []string{}
+	tokens := 0
+	for _, text := range texts {
+		tokens += EstimateTokens(text)
+		if tokens > maxTokens {
+			break
+		}
+		results = append(results, text)
+	}
+	return results
+}
@@ -0,0 +1,137 @@
+package rag
+
+import (
+	"slices"
+	"testing"
+)
+
+// chunkOf returns the chunk [start, end) of a document.
+func chunkOf(source, document string, start, end int) Chunk {
+	return Chunk{Source: source, Start: start, End: end, Content: document[start:end]}
+}
+
+func TestMergeChunks(t *testing.T) {
+	document := "alpha beta gamma delta epsilon zeta eta theta"
+	other := "one two three four five six"
+	tests := []struct {
+		name      string
+		chunks    []Chunk
+		threshold float64
+		want      []string
+	}{
+		{
+			name:      "no chunks",
+			chunks:    nil,
+			threshold: 0.9,
+			want:      []string{},
+		},
+		{
+			name: "overlapping chunks are merged",
+			chunks: []Chunk{
+				chunkOf("a.md", document, 6, 22),
+				chunkOf("a.md", document, 0, 11),
+			},
+			threshold: 0.9,
+			want:      []string{"alpha beta gamma delta"},
+		},
+		{
+			name: "touching chunks are merged",
+			chunks: []Chunk{
+				chunkOf("a.md", document, 0, 11),
+				chunkOf("a.md", document, 11, 22),
+			},
+			threshold: 0.9,
+			want:      []string{"alpha beta gamma delta"},
+		},
+		{
+			name: "a chunk inside another one is dropped",
+			chunks: []Chunk{
+				chunkOf("a.md", document, 0, 22),
+				chunkOf("a.md", document, 6, 10),
+			},
+			threshold: 0.9,
+			want:      []string{"alpha beta gamma delta"},
+		},
+		{
+			name: "distant chunks are kept in the order of their best rank",
+			chunks: []Chunk{
+				chunkOf("a.md", document, 36, 45),
+				chunkOf("b.md", other, 0, 7),
+				chunkOf("a.md", document, 0, 5),
+			},
+			threshold: 0.9,
+			want:      []string{"eta theta", "one two", "alpha"},
+		},
+		{
+			name: "the same text in two sources is a near-duplicate",
+			chunks: []Chunk{
+				{Source: "a.md", Start: 0, End: 10, Content: "docker compose up"},
+				{Source: "b.md", Start: 50, End: 60, Content: "Docker Compose up"},
+			},
+			threshold: 0.9,
+			want:      []string{"docker compose up"},
+		},
+		{
+			name: "the chunks without source are kept as is",
+			chunks: []Chunk{
+				{Content: "a web result"},
+				chunkOf("a.md", document, 0, 5),
+				{Content: "another web result"},
+			},
+			threshold: 0.9,
+			want:      []string{"a web result", "alpha", "another web result"},
+		},
+		{
+			name: "a threshold above 1 keeps the duplicates",
+			chunks: []Chunk{
+				{Content: "docker compose up"},
+				{Content: "docker compose up"},
+			},
+			threshold: 1.1,
+			want:      []string{"docker compose up", "docker compose up"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := MergeChunks(test.chunks, test.threshold)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("MergeChunks() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestJaccardSimilarity(t *testing.T) {
+	tests := []struct {
+		text1, text2 string
+		want         float64
+	}{
+		{"", "", 1},
+		{"docker compose", "", 0},
+		{"Docker Compose", "docker compose", 1},
+		{"docker compose up", "docker compose down", 0.5},
+	}
+	for _, test := range tests {
+		if got := JaccardSimilarity(test.text1, test.text2); got != test.want {
+			t.Errorf("JaccardSimilarity(%q, %q) = %v, want %v", test.text1, test.text2, got, test.want)
+		}
+	}
+}
+
+func TestLimitTokens(t *testing.T) {
+	texts := []string{"12345678", "1234", "12345678"}
+	tests := []struct {
+		maxTokens int
+		want      []string
+	}{
+		{0, []string{}},
+		{2, []string{"12345678"}},
+		{3, []string{"12345678", "1234"}},
+		{100, texts},
+	}
+	for _, test := range tests {
+		if got := LimitTokens(texts, test.maxTokens); !slices.Equal(got, test.want) {
+			t.Errorf("LimitTokens(%d) = %q, want %q", test.maxTokens, got, test.want)
+		}
+	}
+}
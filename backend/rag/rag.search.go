@@ -0,0 +1,84 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"os"
+	"we-are-legion/usage"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/sea-monkeys/robby"
+)
+
+// Similarity is a chunk found in the RAG memory of an agent, with its cosine similarity score.
+type Similarity struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+// CreateEmbedding creates the embedding of a text with the embedding model of the agent.
+// The token usage is recorded with the context (see usage.Add).
+// NOTE: the DMR client of robby is private, so we use our own client.
+func CreateEmbedding(ctx context.Context, agent *robby.Agent, text string) ([]float64, error) {
+	client := openai.NewClient(
+		option.WithBaseURL(os.Getenv("DMR_BASE_URL")+"/engines/llama.cpp/v1"),
+		option.WithAPIKey(""),
+	)
+	embeddingParams := agent.EmbeddingParams
+	embeddingParams.Input = openai.EmbeddingNewParamsInputUnion{
+		OfString: openai.String(text),
+	}
+	embeddingResponse, err := client.Embeddings.New(ctx, embeddingParams)
+	if err != nil {
+		return nil, err
+	}
+	record := usage.Record{Model: embeddingParams.Model, Kind: usage.KindEmbedding, PromptTokens: int(embeddingResponse.Usage.PromptTokens)}
+	if record.PromptTokens == 0 {
+		record.PromptTokens, record.Estimated = EstimateTokens(text), true
+	}
+	usage.Add(ctx, record)
+	if len(embeddingResponse.Data) == 0 {
+		return nil, errors.New("no embedding returned")
+	}
+	return embeddingResponse.Data[0].Embedding, nil
+}
+
+// ChunksOf returns the chunks of the similarities (ex: to merge them, see MergeChunks).
+func ChunksOf(similarities []Similarity) []Chunk {
+	chunks := []Chunk{}
+	for _, similarity := range similarities {
+		chunks = append(chunks, similarity.Chunk)
+	}
+	return chunks
+}
+
+// SearchTopNSimilarities searches the RAG memory of the agent for the chunks the most similar to the text.
+// Unlike RAGMemorySearchSimilaritiesWithText, the results are sorted by score
+// and come with their source document.
+//
+// Parameters:
+//   - ctx: The context of the request (see CreateEmbedding).
+//   - agent: The agent owning the RAG memory.
+//   - text: The text to search for.
+//   - limit: The minimum cosine similarity score for a chunk to be returned.
+//   - max: The maximum number of chunks to return.
+func SearchTopNSimilarities(ctx context.Context, agent *robby.Agent, text string, limit float64, max int) ([]Similarity, error) {
+	embedding, err := CreateEmbedding(ctx, agent, text)
+	if err != nil {
+		return nil, err
+	}
+	records, err := agent.Store.SearchTopNSimilarities(robby.VectorRecord{Embedding: embedding}, limit, max)
+	if err != nil {
+		return nil, err
+	}
+	similarities := []Similarity{}
+	for _, record := range records {
+		chunk, ok := GetChunk(record.Id)
+		if !ok {
+			chunk = Chunk{Content: record.Prompt}
+		}
+		similarities = append(similarities, Similarity{Chunk: chunk, Score: record.CosineSimilarity})
+	}
+	return similarities, nil
+}
@@ -2,21 +2,41 @@ package rag
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 )
 
-func GetChunksOfCloneDocuments(cloneName string) ([]string, error) {
-	contents, err := GetContentFiles("/app/docs/"+cloneName, ".md")
-	if err != nil {
-		return nil, fmt.Errorf("error getting content files for %s agent: %w", cloneName, err)
+// DocsPath returns the root folder of the clones documents.
+// It can be overridden with the DOCS_PATH environment variable (useful outside of the container).
+func DocsPath() string {
+	docsPath := os.Getenv("DOCS_PATH")
+	if docsPath == "" {
+		docsPath = "/app/docs"
 	}
-	for _, content := range contents {
-		fmt.Println("📄", cloneName, "content file:", content)
+	return docsPath
+}
+
+// GetChunksOfCloneDocuments splits the markdown documents of a clone into overlapping chunks.
+func GetChunksOfCloneDocuments(cloneName string, chunkSize, overlap int) ([]Chunk, error) {
+	if chunkSize <= 0 || overlap < 0 || overlap >= chunkSize {
+		return nil, fmt.Errorf("invalid chunk size (%d) or overlap (%d) for %s agent", chunkSize, overlap, cloneName)
 	}
-	chunks := []string{}
-	for _, content := range contents {
-		chunks = append(chunks, ChunkText(content, 512, 210)...)
+	chunks := []Chunk{}
+	_, err := ForEachFile(filepath.Join(DocsPath(), cloneName), ".md", func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content := string(data)
+		slog.Debug("📄 content file", "clone", cloneName, "path", path)
+
+		source, _ := filepath.Rel(DocsPath(), path)
+		chunks = append(chunks, ChunkDocument(source, content, chunkSize, overlap)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting content files for %s agent: %w", cloneName, err)
 	}
 	return chunks, nil
 }
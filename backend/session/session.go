@@ -0,0 +1,286 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Session contains the settings and the conversation of a user of the frontend (the session id is sent with every message).
+// The conversational memory of every clone is kept in the session: the agents of the clones are shared by all the sessions.
+type Session struct {
+	mutex     sync.RWMutex
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	WebSearch *bool     `json:"web_search,omitempty"` // nil: use the default value of the configuration
+	Clone     string    `json:"clone,omitempty"`      // name of the clone speaking with the user (empty: not selected yet)
+	// History contains the messages of the conversation with every clone (the key is the lowercase name of the clone),
+	// without the persona of the clone.
+	History map[string][]openai.ChatCompletionMessageParamUnion `json:"history,omitempty"`
+}
+
+// Settings is the JSON representation of the settings of a session (GET and PUT /sessions/{sessionId}).
+type Settings struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	WebSearch *bool     `json:"web_search,omitempty"`
+	Clone     string    `json:"clone,omitempty"` // read-only, the clone is selected by the conversation
+}
+
+// NOTE: the sessions are evicted when they are not used for the TTL, or when there are too many sessions
+// (the least recently used first, see Configure). The callbacks of OnEvict are called with the ids of the evicted sessions.
+var (
+	sessions      = map[string]*Session{}
+	lastUsed      = map[string]time.Time{}
+	sessionsMutex sync.Mutex
+	ttl           = 24 * time.Hour
+	maxSessions   = 1000
+	evictionHooks []func(id string)
+)
+
+// Configure sets the TTL of the unused sessions and the maximum number of sessions (0: no limit).
+func Configure(sessionTTL time.Duration, max int) {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	ttl, maxSessions = sessionTTL, max
+}
+
+// OnEvict registers a callback called with the id of every evicted session (ex: to forget its token usage).
+// It must be called before serving the requests.
+func OnEvict(callback func(id string)) {
+	evictionHooks = append(evictionHooks, callback)
+}
+
+// Get returns the session with the given id, it is created if it does not exist (see Find).
+// An empty id is the "default" session. Creating a session can evict the least recently used one.
+func Get(id string) *Session {
+	if id == "" {
+		id = "default"
+	}
+	sessionsMutex.Lock()
+	session, ok := sessions[id]
+	if !ok {
+		session = &Session{ID: id, CreatedAt: time.Now()}
+		sessions[id] = session
+	}
+	lastUsed[id] = time.Now()
+	evicted := []string{}
+	if !ok {
+		evicted = evictLeastRecentlyUsed()
+	}
+	sessionsMutex.Unlock()
+
+	notifyEviction(evicted)
+	return session
+}
+
+// Find returns the session with the given id if it exists (it is not created, ex: GET /sessions/{sessionId}).
+func Find(id string) (*Session, bool) {
+	if id == "" {
+		id = "default"
+	}
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	session, ok := sessions[id]
+	return session, ok
+}
+
+// Count returns the number of sessions.
+func Count() int {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	return len(sessions)
+}
+
+// EvictExpired evicts the sessions not used since the TTL, and returns their ids.
+func EvictExpired(now time.Time) []string {
+	sessionsMutex.Lock()
+	evicted := []string{}
+	if ttl > 0 {
+		for id, usedAt := range lastUsed {
+			if now.Sub(usedAt) > ttl {
+				evicted = append(evicted, id)
+				delete(sessions, id)
+				delete(lastUsed, id)
+			}
+		}
+	}
+	sessionsMutex.Unlock()
+
+	notifyEviction(evicted)
+	return evicted
+}
+
+// RunEviction evicts the expired sessions every interval, until the end of the context.
+func RunEviction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if evicted := EvictExpired(now); len(evicted) > 0 {
+				slog.Info("🧹 sessions evicted", "count", len(evicted))
+			}
+		}
+	}
+}
+
+// evictLeastRecentlyUsed evicts the least recently used sessions above the maximum number of sessions.
+// The caller must hold the lock of the sessions.
+func evictLeastRecentlyUsed() []string {
+	evicted := []string{}
+	for maxSessions > 0 && len(sessions) > maxSessions {
+		oldestID := ""
+		for id, usedAt := range lastUsed {
+			if oldestID == "" || usedAt.Before(lastUsed[oldestID]) {
+				oldestID = id
+			}
+		}
+		evicted = append(evicted, oldestID)
+		delete(sessions, oldestID)
+		delete(lastUsed, oldestID)
+	}
+	return evicted
+}
+
+func notifyEviction(evicted []string) {
+	for _, id := range evicted {
+		for _, callback := range evictionHooks {
+			callback(id)
+		}
+	}
+}
+
+// Snapshot is the state of the sessions saved at the shutdown and restored at the startup (see the state package).
+type Snapshot struct {
+	Sessions map[string]*Session  `json:"sessions"`
+	LastUsed map[string]time.Time `json:"last_used"`
+}
+
+// Export returns a copy of the state of all the sessions.
+func Export() Snapshot {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	snapshot := Snapshot{Sessions: map[string]*Session{}, LastUsed: map[string]time.Time{}}
+	for id, session := range sessions {
+		snapshot.Sessions[id] = session.copy()
+		snapshot.LastUsed[id] = lastUsed[id]
+	}
+	return snapshot
+}
+
+// Import adds the sessions of a snapshot (the existing sessions with the same id are replaced),
+// the expired sessions are evicted at the next eviction (see RunEviction).
+func Import(snapshot Snapshot) {
+	sessionsMutex.Lock()
+	for id, session := range snapshot.Sessions {
+		if session == nil {
+			continue
+		}
+		session.ID = id
+		sessions[id] = session
+		lastUsed[id] = snapshot.LastUsed[id]
+		if lastUsed[id].IsZero() {
+			lastUsed[id] = time.Now()
+		}
+	}
+	evicted := evictLeastRecentlyUsed()
+	sessionsMutex.Unlock()
+
+	notifyEviction(evicted)
+}
+
+func (session *Session) copy() *Session {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	history := map[string][]openai.ChatCompletionMessageParamUnion{}
+	for cloneName, messages := range session.History {
+		history[cloneName] = slices.Clone(messages)
+	}
+	return &Session{
+		ID:        session.ID,
+		CreatedAt: session.CreatedAt,
+		WebSearch: session.WebSearch,
+		Clone:     session.Clone,
+		History:   history,
+	}
+}
+
+// Settings returns a copy of the settings of the session.
+func (session *Session) Settings() Settings {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return Settings{
+		ID:        session.ID,
+		CreatedAt: session.CreatedAt,
+		WebSearch: session.WebSearch,
+		Clone:     session.Clone,
+	}
+}
+
+// SelectedClone returns the name of the clone speaking with the user in this session (empty if none is selected yet).
+func (session *Session) SelectedClone() string {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return session.Clone
+}
+
+// SelectClone sets the clone speaking with the user in this session (see the Riker tool calls).
+func (session *Session) SelectClone(cloneName string) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.Clone = strings.ToLower(cloneName)
+}
+
+// SetWebSearch sets the web search toggle of the session (nil: use the default value of the configuration).
+func (session *Session) SetWebSearch(enabled *bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.WebSearch = enabled
+}
+
+// IsWebSearchEnabled resolves the web search option: the value of the request if set,
+// then the toggle of the session if set, then the default value.
+func (session *Session) IsWebSearchEnabled(requestValue *bool, defaultValue bool) bool {
+	if requestValue != nil {
+		return *requestValue
+	}
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	if session.WebSearch != nil {
+		return *session.WebSearch
+	}
+	return defaultValue
+}
+
+// CloneHistory returns a copy of the messages of the conversation of the session with a clone.
+func (session *Session) CloneHistory(cloneName string) []openai.ChatCompletionMessageParamUnion {
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+	return slices.Clone(session.History[strings.ToLower(cloneName)])
+}
+
+// AddToHistory adds messages to the conversation of the session with a clone (ex: the question and the answer of a request).
+func (session *Session) AddToHistory(cloneName string, messages ...openai.ChatCompletionMessageParamUnion) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.History == nil {
+		session.History = map[string][]openai.ChatCompletionMessageParamUnion{}
+	}
+	cloneName = strings.ToLower(cloneName)
+	session.History[cloneName] = append(session.History[cloneName], messages...)
+}
+
+// ClearHistory removes the messages of the conversations of the session (the settings are kept).
+func (session *Session) ClearHistory() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.History = nil
+}
@@ -0,0 +1,201 @@
+package session
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+func TestSelectClone(t *testing.T) {
+	first := Get("test-select-clone-1")
+	second := Get("test-select-clone-2")
+	if clone := first.SelectedClone(); clone != "" {
+		t.Fatalf("SelectedClone() = %q, want no clone", clone)
+	}
+	first.SelectClone("Milo")
+	if clone := first.SelectedClone(); clone != "milo" {
+		t.Errorf("SelectedClone() = %q, want milo", clone)
+	}
+	if clone := second.SelectedClone(); clone != "" {
+		t.Errorf("SelectedClone() of another session = %q, want no clone", clone)
+	}
+}
+
+func TestIsWebSearchEnabled(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name         string
+		session      *bool
+		request      *bool
+		defaultValue bool
+		want         bool
+	}{
+		{"default", nil, nil, true, true},
+		{"session", &disabled, nil, true, false},
+		{"request over session", &disabled, &enabled, false, true},
+		{"request over default", nil, &disabled, true, false},
+	}
+	for _, test := range tests {
+		session := &Session{WebSearch: test.session}
+		if got := session.IsWebSearchEnabled(test.request, test.defaultValue); got != test.want {
+			t.Errorf("%s: IsWebSearchEnabled() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// resetSessions removes all the sessions and the eviction hooks, and restores the limits at the end of the test.
+func resetSessions(t *testing.T, sessionTTL time.Duration, max int) {
+	sessionsMutex.Lock()
+	previousTTL, previousMax := ttl, maxSessions
+	sessions, lastUsed, evictionHooks = map[string]*Session{}, map[string]time.Time{}, nil
+	sessionsMutex.Unlock()
+	Configure(sessionTTL, max)
+	t.Cleanup(func() { Configure(previousTTL, previousMax) })
+}
+
+func TestFindDoesNotCreate(t *testing.T) {
+	resetSessions(t, time.Hour, 10)
+	if _, ok := Find("unknown"); ok {
+		t.Error("Find() returns an unknown session")
+	}
+	if Count() != 0 {
+		t.Errorf("Count() = %d after Find, want 0", Count())
+	}
+	created := Get("")
+	if found, ok := Find("default"); !ok || found != created {
+		t.Error("Find(default) does not return the session created by Get")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		max       int
+		ids       []string
+		elapsed   time.Duration
+		wantAlive []string
+	}{
+		{"no eviction", time.Hour, 10, []string{"a", "b"}, time.Minute, []string{"a", "b"}},
+		{"expired sessions", time.Hour, 10, []string{"a", "b"}, 2 * time.Hour, []string{}},
+		{"no TTL", 0, 10, []string{"a", "b"}, 2 * time.Hour, []string{"a", "b"}},
+		{"least recently used above the max", time.Hour, 2, []string{"a", "b", "a", "c"}, time.Minute, []string{"a", "c"}},
+		{"no max", time.Hour, 0, []string{"a", "b", "c"}, time.Minute, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetSessions(t, test.ttl, test.max)
+			evicted := map[string]bool{}
+			OnEvict(func(id string) { evicted[id] = true })
+
+			for _, id := range test.ids {
+				Get(id)
+				// NOTE: the sessions must have distinct usage times
+				time.Sleep(time.Millisecond)
+			}
+			EvictExpired(time.Now().Add(test.elapsed))
+
+			if Count() != len(test.wantAlive) {
+				t.Errorf("Count() = %d, want %d", Count(), len(test.wantAlive))
+			}
+			for _, id := range test.wantAlive {
+				if _, ok := Find(id); !ok {
+					t.Errorf("session %s has been evicted", id)
+				}
+				if evicted[id] {
+					t.Errorf("the eviction hook has been called for the alive session %s", id)
+				}
+			}
+			for _, id := range test.ids {
+				if _, ok := Find(id); !ok && !evicted[id] {
+					t.Errorf("the eviction hook has not been called for the session %s", id)
+				}
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	resetSessions(t, time.Hour, 10)
+	first, second := Get("first"), Get("second")
+	first.AddToHistory("Bob", openai.UserMessage("first question"), openai.AssistantMessage("first answer"))
+	first.AddToHistory("bill", openai.UserMessage("question to Bill"))
+	second.AddToHistory("bob", openai.UserMessage("second question"))
+
+	tests := []struct {
+		name    string
+		session *Session
+		clone   string
+		want    []string
+	}{
+		{"first session with Bob", first, "bob", []string{"first question", "first answer"}},
+		{"first session with Bill", first, "Bill", []string{"question to Bill"}},
+		{"second session with Bob", second, "Bob", []string{"second question"}},
+		{"no conversation", second, "milo", []string{}},
+	}
+	for _, test := range tests {
+		contents := []string{}
+		for _, message := range test.session.CloneHistory(test.clone) {
+			contents = append(contents, *message.GetContent().AsAny().(*string))
+		}
+		if !slices.Equal(contents, test.want) {
+			t.Errorf("%s: CloneHistory() = %q, want %q", test.name, contents, test.want)
+		}
+	}
+
+	// NOTE: the history is a copy, the changes of a request are only saved by AddToHistory
+	history := first.CloneHistory("bob")
+	history[0] = openai.UserMessage("changed")
+	_ = append(history[:1], openai.UserMessage("appended"))
+	if content := *first.CloneHistory("bob")[0].GetContent().AsAny().(*string); content != "first question" {
+		t.Errorf("the history of the session has changed: %q", content)
+	}
+
+	first.ClearHistory()
+	if len(first.CloneHistory("bob")) != 0 || len(first.CloneHistory("bill")) != 0 {
+		t.Error("ClearHistory() must remove the conversations of the session")
+	}
+	if len(second.CloneHistory("bob")) != 1 {
+		t.Error("ClearHistory() must not change the other sessions")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	resetSessions(t, time.Hour, 2)
+	evicted := []string{}
+	OnEvict(func(id string) { evicted = append(evicted, id) })
+	saved := Get("saved")
+	saved.SelectClone("Bill")
+	saved.AddToHistory("bill", openai.UserMessage("question"), openai.AssistantMessage("answer"))
+	snapshot := Export()
+
+	// NOTE: the snapshot is a copy, the next changes of the session are not saved
+	saved.AddToHistory("bill", openai.UserMessage("not saved"))
+	if len(snapshot.Sessions["saved"].History["bill"]) != 2 {
+		t.Fatalf("the snapshot has changed: %d messages", len(snapshot.Sessions["saved"].History["bill"]))
+	}
+
+	resetSessions(t, time.Hour, 2)
+	OnEvict(func(id string) { evicted = append(evicted, id) })
+	Get("current")
+	snapshot.Sessions["old"] = &Session{CreatedAt: time.Now()}
+	snapshot.LastUsed["old"] = time.Now().Add(-time.Minute)
+	Import(snapshot)
+
+	restored, ok := Find("saved")
+	if !ok {
+		t.Fatal("the saved session is not restored")
+	}
+	if restored.ID != "saved" || restored.SelectedClone() != "bill" || len(restored.CloneHistory("bill")) != 2 {
+		t.Errorf("restored session = %q, clone %q, %d messages", restored.ID, restored.SelectedClone(), len(restored.CloneHistory("bill")))
+	}
+	// NOTE: the limits are applied to the restored sessions (the least recently used is evicted)
+	if !slices.Equal(evicted, []string{"old"}) {
+		t.Errorf("evicted sessions = %v, want [old]", evicted)
+	}
+	if _, ok := Find("current"); !ok {
+		t.Error("the current sessions must be kept")
+	}
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+	"we-are-legion/session"
+	"we-are-legion/usage"
+)
+
+// State is the state of the backend saved to a JSON file at the shutdown and restored at the startup:
+// the sessions (settings and conversations) and the token usage.
+// NOTE: the RAG memories are built from the documents of the clones at the startup.
+type State struct {
+	SavedAt  time.Time        `json:"saved_at"`
+	Sessions session.Snapshot `json:"sessions"`
+	Usage    usage.Snapshot   `json:"usage"`
+}
+
+// Save writes the state of the sessions and of the token usage to the file.
+// The file is replaced atomically (a temporary file is renamed), its folder is created if needed.
+func Save(path string) error {
+	state := State{SavedAt: time.Now(), Sessions: session.Export(), Usage: usage.Export()}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	temporaryFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+	if _, err := temporaryFile.Write(data); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	if err := temporaryFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temporaryFile.Name(), path); err != nil {
+		return err
+	}
+	slog.Info("💾 state saved", "path", path, "sessions", len(state.Sessions.Sessions))
+	return nil
+}
+
+// Load restores the sessions and the token usage saved in the file.
+// A missing file is not an error (ex: the first startup), there is nothing to restore.
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	session.Import(state.Sessions)
+	usage.Import(state.Usage)
+	slog.Info("💾 state restored", "path", path, "sessions", len(state.Sessions.Sessions), "saved_at", state.SavedAt)
+	return nil
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"we-are-legion/session"
+	"we-are-legion/usage"
+
+	"github.com/openai/openai-go"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "state.json")
+	chatSession := session.Get("test-state")
+	chatSession.AddToHistory("bob", openai.UserMessage("question"), openai.AssistantMessage("answer"))
+	usage.Add(usage.WithTracker(context.Background(), usage.NewTracker("test-state")), usage.Record{Agent: "Bob", PromptTokens: 10})
+
+	if err := Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	usage.DeleteSession("test-state")
+	chatSession.ClearHistory()
+	if err := Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	restored, ok := session.Find("test-state")
+	if !ok || len(restored.CloneHistory("bob")) != 2 {
+		t.Errorf("the conversation of the session is not restored")
+	}
+	if summary, ok := usage.Session("test-state"); !ok || summary.TotalTokens != 10 {
+		t.Errorf("the token usage of the session is not restored: %+v", summary.Usage)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	invalidFile := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(invalidFile, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"missing file (first startup)", filepath.Join(t.TempDir(), "missing.json"), false},
+		{"invalid file", invalidFile, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Load(test.path); (err != nil) != test.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "we-are-legion"
+
+// Attributes of the spans (the names of the OpenTelemetry semantic conventions for the generative AI, when they exist).
+const (
+	AttrAgent        = "agent"
+	AttrModel        = "gen_ai.request.model"
+	AttrInputTokens  = "gen_ai.usage.input_tokens"  // estimated, ~4 characters per token
+	AttrOutputTokens = "gen_ai.usage.output_tokens" // estimated, ~4 characters per token
+)
+
+// Setup configures the OpenTelemetry tracer provider:
+// the spans are exported with OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT), ex: http://localhost:4318.
+// The other OTEL_* environment variables of the exporter are supported (ex: OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME).
+// Without endpoint, the tracing is disabled: the spans are not recorded.
+//
+// It returns a function flushing and stopping the exporter (to call before exiting).
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serviceResource := resource.Default()
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		serviceResource, _ = resource.Merge(serviceResource, resource.NewSchemaless(attribute.String("service.name", "bob-backend")))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("😡 tracing error", "error", err)
+	}))
+	slog.Info("🔭 tracing enabled", "endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")+os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"))
+
+	return provider.Shutdown, nil
+}
+
+// FromRequest returns the context of the request with the trace of the caller if any (the traceparent header).
+func FromRequest(request *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+}
+
+// Start starts a span (a child of the span of the context if any).
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// SetError records the error (if any) on the span.
+func SetError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// End records the error (if any) and ends the span.
+func End(span trace.Span, err error) {
+	SetError(span, err)
+	span.End()
+}
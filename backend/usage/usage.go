@@ -0,0 +1,228 @@
+package usage
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+// Kinds of the calls to the models.
+const (
+	KindChat      = "chat"      // chat completion (streamed or not)
+	KindTools     = "tools"     // tools completion (the detection of the tool calls)
+	KindEmbedding = "embedding" // embedding of a search query, or of a chunk of the documents when the RAG memory is built
+)
+
+// Record is the token usage of a call to a model.
+// The tokens come from the usage field of the response when the model runner returns it,
+// otherwise they are estimated (~4 characters per token, see rag.EstimateTokens).
+type Record struct {
+	Agent            string // ex: Bob, Riker, Spock
+	Model            string
+	Kind             string
+	PromptTokens     int
+	CompletionTokens int
+	Estimated        bool
+}
+
+// Usage is the token usage of a set of calls.
+type Usage struct {
+	Calls            int `json:"calls"`
+	EstimatedCalls   int `json:"estimated_calls"` // the calls without usage field in the response
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (usage *Usage) add(record Record) {
+	usage.Calls++
+	if record.Estimated {
+		usage.EstimatedCalls++
+	}
+	usage.PromptTokens += record.PromptTokens
+	usage.CompletionTokens += record.CompletionTokens
+	usage.TotalTokens += record.PromptTokens + record.CompletionTokens
+}
+
+// Summary is the token usage of a request, a session or of all the requests, by agent (the clones and the tool agents) and by model.
+type Summary struct {
+	Usage
+	ByAgent map[string]Usage `json:"by_agent"`
+	ByModel map[string]Usage `json:"by_model"`
+}
+
+func newSummary() *Summary {
+	return &Summary{ByAgent: map[string]Usage{}, ByModel: map[string]Usage{}}
+}
+
+func (summary *Summary) add(record Record) {
+	summary.Usage.add(record)
+	agentUsage := summary.ByAgent[record.Agent]
+	agentUsage.add(record)
+	summary.ByAgent[record.Agent] = agentUsage
+	modelUsage := summary.ByModel[record.Model]
+	modelUsage.add(record)
+	summary.ByModel[record.Model] = modelUsage
+}
+
+func (usage *Usage) merge(other Usage) {
+	usage.Calls += other.Calls
+	usage.EstimatedCalls += other.EstimatedCalls
+	usage.PromptTokens += other.PromptTokens
+	usage.CompletionTokens += other.CompletionTokens
+	usage.TotalTokens += other.TotalTokens
+}
+
+func (summary *Summary) merge(other Summary) {
+	summary.Usage.merge(other.Usage)
+	for agentName, agentUsage := range other.ByAgent {
+		merged := summary.ByAgent[agentName]
+		merged.merge(agentUsage)
+		summary.ByAgent[agentName] = merged
+	}
+	for model, modelUsage := range other.ByModel {
+		merged := summary.ByModel[model]
+		merged.merge(modelUsage)
+		summary.ByModel[model] = merged
+	}
+}
+
+func (summary *Summary) copy() Summary {
+	return Summary{Usage: summary.Usage, ByAgent: maps.Clone(summary.ByAgent), ByModel: maps.Clone(summary.ByModel)}
+}
+
+// Tracker collects the token usage of a request (see WithTracker).
+type Tracker struct {
+	mutex     sync.Mutex
+	sessionID string
+	summary   *Summary
+}
+
+// NewTracker returns the tracker of a request of the session.
+func NewTracker(sessionID string) *Tracker {
+	return &Tracker{sessionID: sessionID, summary: newSummary()}
+}
+
+// Summary returns a copy of the token usage of the request.
+func (tracker *Tracker) Summary() Summary {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.summary.copy()
+}
+
+type trackerKey struct{}
+
+type agentKey struct{}
+
+// WithTracker returns a copy of the context with the tracker of the request:
+// the calls recorded with this context (see Add) are added to the request and to its session.
+func WithTracker(ctx context.Context, tracker *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, tracker)
+}
+
+// WithAgent returns a copy of the context with the name of the agent of the records added without agent
+// (ex: the embeddings of the search queries of a clone, see rag.CreateEmbedding).
+func WithAgent(ctx context.Context, agentName string) context.Context {
+	return context.WithValue(ctx, agentKey{}, agentName)
+}
+
+// AgentOf returns the name of the agent of the context (see WithAgent).
+func AgentOf(ctx context.Context) string {
+	agentName, _ := ctx.Value(agentKey{}).(string)
+	return agentName
+}
+
+var (
+	total    = newSummary()
+	sessions = map[string]*Summary{}
+	mutex    sync.Mutex
+)
+
+// Add records the token usage of a call: it is added to the totals,
+// and to the request and the session of the tracker of the context if any.
+// NOTE: the embeddings of the documents at startup have no tracker (see rag.BuildMemory), they are only in the totals.
+func Add(ctx context.Context, record Record) {
+	if record.Agent == "" {
+		record.Agent = AgentOf(ctx)
+	}
+	mutex.Lock()
+	total.add(record)
+	tracker, _ := ctx.Value(trackerKey{}).(*Tracker)
+	if tracker != nil {
+		sessionSummary, ok := sessions[tracker.sessionID]
+		if !ok {
+			sessionSummary = newSummary()
+			sessions[tracker.sessionID] = sessionSummary
+		}
+		sessionSummary.add(record)
+	}
+	mutex.Unlock()
+
+	if tracker != nil {
+		tracker.mutex.Lock()
+		tracker.summary.add(record)
+		tracker.mutex.Unlock()
+	}
+}
+
+// Total returns the token usage of all the requests since the start of the server.
+func Total() Summary {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return total.copy()
+}
+
+// Session returns the token usage of a session (false if the session has no usage).
+func Session(sessionID string) (Summary, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	sessionSummary, ok := sessions[sessionID]
+	if !ok {
+		return Summary{}, false
+	}
+	return sessionSummary.copy(), true
+}
+
+// Sessions returns the token usage of every session.
+func Sessions() map[string]Summary {
+	mutex.Lock()
+	defer mutex.Unlock()
+	summaries := map[string]Summary{}
+	for sessionID, sessionSummary := range sessions {
+		summaries[sessionID] = sessionSummary.copy()
+	}
+	return summaries
+}
+
+// DeleteSession forgets the token usage of a session (ex: when the session is evicted), the totals are kept.
+func DeleteSession(sessionID string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(sessions, sessionID)
+}
+
+// Snapshot is the token usage saved at the shutdown and restored at the startup (see the state package).
+type Snapshot struct {
+	Total    Summary            `json:"total"`
+	Sessions map[string]Summary `json:"sessions"`
+}
+
+// Export returns a copy of the totals and of the token usage of the sessions.
+func Export() Snapshot {
+	return Snapshot{Total: Total(), Sessions: Sessions()}
+}
+
+// Import adds the token usage of a snapshot to the totals and to the sessions.
+func Import(snapshot Snapshot) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	total.merge(snapshot.Total)
+	for sessionID, summary := range snapshot.Sessions {
+		sessionSummary, ok := sessions[sessionID]
+		if !ok {
+			sessionSummary = newSummary()
+			sessions[sessionID] = sessionSummary
+		}
+		sessionSummary.merge(summary)
+	}
+}
@@ -0,0 +1,146 @@
+package usage
+
+import (
+	"context"
+	"testing"
+)
+
+// resetUsage forgets the usage recorded by the previous tests.
+func resetUsage() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	total = newSummary()
+	sessions = map[string]*Summary{}
+}
+
+func TestAdd(t *testing.T) {
+	resetUsage()
+	first, second := NewTracker("first"), NewTracker("second")
+
+	Add(WithTracker(context.Background(), first), Record{Agent: "Bob", Model: "qwen", Kind: KindChat, PromptTokens: 100, CompletionTokens: 20, Estimated: true})
+	Add(WithTracker(WithAgent(context.Background(), "Bill"), first), Record{Model: "embed", Kind: KindEmbedding, PromptTokens: 10})
+	Add(WithTracker(context.Background(), second), Record{Agent: "Riker", Model: "qwen", Kind: KindTools, PromptTokens: 50, CompletionTokens: 5, Estimated: true})
+	// NOTE: without tracker (ex: the embeddings of the documents at startup), only in the totals
+	Add(WithAgent(context.Background(), "Bob"), Record{Model: "embed", Kind: KindEmbedding, PromptTokens: 1000})
+
+	tests := []struct {
+		name    string
+		summary Summary
+		want    Usage
+		agents  map[string]int
+		models  map[string]int
+	}{
+		{"first request", first.Summary(),
+			Usage{Calls: 2, EstimatedCalls: 1, PromptTokens: 110, CompletionTokens: 20, TotalTokens: 130},
+			map[string]int{"Bob": 120, "Bill": 10}, map[string]int{"qwen": 120, "embed": 10}},
+		{"second request", second.Summary(),
+			Usage{Calls: 1, EstimatedCalls: 1, PromptTokens: 50, CompletionTokens: 5, TotalTokens: 55},
+			map[string]int{"Riker": 55}, map[string]int{"qwen": 55}},
+		{"total", Total(),
+			Usage{Calls: 4, EstimatedCalls: 2, PromptTokens: 1160, CompletionTokens: 25, TotalTokens: 1185},
+			map[string]int{"Bob": 1120, "Bill": 10, "Riker": 55}, map[string]int{"qwen": 175, "embed": 1010}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.summary.Usage != test.want {
+				t.Errorf("usage = %+v, want %+v", test.summary.Usage, test.want)
+			}
+			checkTotals(t, "agent", test.summary.ByAgent, test.agents)
+			checkTotals(t, "model", test.summary.ByModel, test.models)
+		})
+	}
+
+	firstSession, ok := Session("first")
+	if !ok || firstSession.Usage != first.Summary().Usage {
+		t.Errorf("Session(first) = %+v, %v, want the usage of its request", firstSession.Usage, ok)
+	}
+	if _, ok := Session("unknown"); ok {
+		t.Error("Session(unknown) must not exist")
+	}
+	if len(Sessions()) != 2 {
+		t.Errorf("Sessions() = %v, want 2 sessions", Sessions())
+	}
+}
+
+func checkTotals(t *testing.T, kind string, usages map[string]Usage, want map[string]int) {
+	t.Helper()
+	if len(usages) != len(want) {
+		t.Errorf("%d usages by %s, want %d: %v", len(usages), kind, len(want), usages)
+	}
+	for name, totalTokens := range want {
+		if usages[name].TotalTokens != totalTokens {
+			t.Errorf("total tokens of the %s %s = %d, want %d", kind, name, usages[name].TotalTokens, totalTokens)
+		}
+	}
+}
+
+func TestSummaryIsACopy(t *testing.T) {
+	resetUsage()
+	tracker := NewTracker("session")
+	Add(WithTracker(context.Background(), tracker), Record{Agent: "Bob", Model: "qwen", PromptTokens: 10})
+
+	summary := tracker.Summary()
+	summary.ByAgent["Bob"] = Usage{}
+	sessionSummary, _ := Session("session")
+	sessionSummary.ByModel["qwen"] = Usage{}
+
+	if tracker.Summary().ByAgent["Bob"].TotalTokens != 10 {
+		t.Error("the summary of the tracker has changed")
+	}
+	if sessionSummary, _ := Session("session"); sessionSummary.ByModel["qwen"].TotalTokens != 10 {
+		t.Error("the summary of the session has changed")
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	resetUsage()
+	Add(WithTracker(context.Background(), NewTracker("evicted")), Record{Agent: "Bob", PromptTokens: 10})
+	Add(WithTracker(context.Background(), NewTracker("kept")), Record{Agent: "Bob", PromptTokens: 20})
+
+	DeleteSession("evicted")
+	DeleteSession("unknown")
+
+	if _, ok := Session("evicted"); ok {
+		t.Error("the usage of the evicted session must be forgotten")
+	}
+	if _, ok := Session("kept"); !ok {
+		t.Error("the usage of the other sessions must be kept")
+	}
+	if Total().TotalTokens != 30 {
+		t.Errorf("the totals = %d tokens, want 30 (the usage of the evicted sessions is kept)", Total().TotalTokens)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	resetUsage()
+	Add(WithTracker(context.Background(), NewTracker("saved")), Record{Agent: "Bob", Model: "qwen", PromptTokens: 10, CompletionTokens: 5})
+	snapshot := Export()
+
+	resetUsage()
+	Add(WithTracker(context.Background(), NewTracker("current")), Record{Agent: "Bill", Model: "qwen", PromptTokens: 20})
+	Import(snapshot)
+
+	tests := []struct {
+		name    string
+		summary Summary
+		want    Usage
+		agents  map[string]int
+		models  map[string]int
+	}{
+		{"total", Total(), Usage{Calls: 2, PromptTokens: 30, CompletionTokens: 5, TotalTokens: 35},
+			map[string]int{"Bob": 15, "Bill": 20}, map[string]int{"qwen": 35}},
+		{"saved session", Sessions()["saved"], Usage{Calls: 1, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			map[string]int{"Bob": 15}, map[string]int{"qwen": 15}},
+		{"current session", Sessions()["current"], Usage{Calls: 1, PromptTokens: 20, TotalTokens: 20},
+			map[string]int{"Bill": 20}, map[string]int{"qwen": 20}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.summary.Usage != test.want {
+				t.Errorf("usage = %+v, want %+v", test.summary.Usage, test.want)
+			}
+			checkTotals(t, "agent", test.summary.ByAgent, test.agents)
+			checkTotals(t, "model", test.summary.ByModel, test.models)
+		})
+	}
+}
@@ -1,53 +1,335 @@
 package workflow
 
-import "we-are-legion/agents"
-
-func InitializeAgents() (map[string]*agents.AgentConfig) {
-	// create a map of agents
-	agentsCatalog := map[string]*agents.AgentConfig{
-		"bob": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeBobAgent()
-			if err != nil {
-				panic("Error initializing Bob agent: " + err.Error())
-			}
-			return cfg
-		}(),
-		"bill": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeBillAgent()
-			if err != nil {
-				panic("Error initializing Bill agent: " + err.Error())
-			}
-			return cfg
-		}(),
-		"milo": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeMiloAgent()
-			if err != nil {
-				panic("Error initializing Milo agent: " + err.Error())
-			}
-			return cfg
-		}(),
-		"garfield": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeGarfieldAgent()
-			if err != nil {
-				panic("Error initializing Garfield agent: " + err.Error())
-			}
-			return cfg
-		}(),
-		"riker": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeRikerAgent()
-			if err != nil {
-				panic("Error initializing Riker agent: " + err.Error())
-			}
-			return cfg
-		}(),
-		"khan": func() *agents.AgentConfig {
-			cfg, err := agents.InitializeKhanAgent()
-			if err != nil {
-				panic("Error initializing Khan agent: " + err.Error())
-			}
-			return cfg
-		}(),
-	}
-	return agentsCatalog
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"we-are-legion/agents"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/rag"
+	"we-are-legion/session"
 
+	"github.com/sea-monkeys/robby"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// CloneNames are the names of the clones of Bob (the agents with a RAG memory answering the user).
+var CloneNames = []string{"bob", "bill", "garfield", "milo"}
+
+// agentInitializers are the constructors of the agents of the catalog: the clones and the tool agents.
+var agentInitializers = map[string]func() (*agents.AgentConfig, error){
+	"bob":      agents.InitializeBobAgent,
+	"bill":     agents.InitializeBillAgent,
+	"milo":     agents.InitializeMiloAgent,
+	"garfield": agents.InitializeGarfieldAgent,
+	"riker":    agents.InitializeRikerAgent,
+	"khan":     agents.InitializeKhanAgent,
+	"spock":    agents.InitializeSpockAgent,
+}
+
+// Startup statuses of the agents (see Progress).
+const (
+	AgentInitializing = "initializing"
+	AgentReady        = "ready"
+	AgentUnavailable  = "unavailable"
+)
+
+// AgentProgress is the startup progress of an agent.
+type AgentProgress struct {
+	Status   string                `json:"status"`
+	Indexing *rag.IndexingProgress `json:"indexing,omitempty"` // the embeddings of the documents of a clone
+	Error    string                `json:"error,omitempty"`
+}
+
+// StartupProgress is the progress of the initialization of the agents.
+type StartupProgress struct {
+	Done           bool                     `json:"done"`
+	ElapsedSeconds int                      `json:"elapsed_seconds"`
+	Agents         map[string]AgentProgress `json:"agents"`
+}
+
+// Catalog is the catalog of the agents.
+// The agents are initialized independently: an agent that fails (ex: a missing docs folder, or an unset model)
+// is unavailable, its error is recorded, and it is initialized again in the background (see RetryUnavailableAgents).
+type Catalog struct {
+	mutex       sync.RWMutex
+	agents      map[string]*agents.AgentConfig
+	errors      map[string]error
+	startTime   time.Time
+	initialized chan struct{} // closed when the first initialization of all the agents is done
+}
+
+// InitializeAgents initializes all the agents concurrently in the background, it does not fail:
+// the server starts with the agents being initialized (see Initialized and Progress),
+// then in degraded mode with the available agents (see Errors).
+// The embeddings of the documents of the clones are bounded by the embedding parallelism (see config.yml).
+func InitializeAgents() *Catalog {
+	catalog := &Catalog{
+		agents:      map[string]*agents.AgentConfig{},
+		errors:      map[string]error{},
+		startTime:   time.Now(),
+		initialized: make(chan struct{}),
+	}
+	rag.SetEmbeddingParallelism(config.Get().Startup.EmbeddingParallelism)
+
+	go func() {
+		var waitGroup sync.WaitGroup
+		for agentName := range agentInitializers {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				catalog.initialize(agentName)
+			}()
+		}
+		waitGroup.Wait()
+		close(catalog.initialized)
+		slog.Info("🚀 agents initialized", "duration", time.Since(catalog.startTime).String(), "unavailable", len(catalog.Errors()))
+	}()
+	return catalog
+}
+
+// Initialized returns true when the first initialization of all the agents is done (the agents are available or not).
+func (catalog *Catalog) Initialized() bool {
+	select {
+	case <-catalog.initialized:
+		return true
+	default:
+		return false
+	}
+}
+
+// Progress returns the startup progress of the agents, with the indexing of the documents of the clones.
+func (catalog *Catalog) Progress() StartupProgress {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	progress := StartupProgress{
+		Done:           catalog.Initialized(),
+		ElapsedSeconds: int(time.Since(catalog.startTime).Seconds()),
+		Agents:         map[string]AgentProgress{},
+	}
+	for agentName := range agentInitializers {
+		agentProgress := AgentProgress{Status: AgentInitializing}
+		if _, ok := catalog.agents[agentName]; ok {
+			agentProgress.Status = AgentReady
+		} else if err, ok := catalog.errors[agentName]; ok {
+			agentProgress.Status = AgentUnavailable
+			agentProgress.Error = err.Error()
+		}
+		if indexing, ok := rag.Indexing(agentName); ok {
+			agentProgress.Indexing = &indexing
+		}
+		progress.Agents[agentName] = agentProgress
+	}
+	return progress
+}
+
+// FormatStartupProgress returns the text of the warming up message, ex: "Bob: 120/340 chunks · Bill: ready · Riker: initializing".
+func FormatStartupProgress(progress StartupProgress) string {
+	parts := []string{}
+	for _, agentName := range append(slices.Clone(CloneNames), "riker", "khan", "spock") {
+		agentProgress := progress.Agents[agentName]
+		text := agentProgress.Status
+		if agentProgress.Status == AgentInitializing && agentProgress.Indexing != nil {
+			text = fmt.Sprintf("%d/%d chunks", agentProgress.Indexing.Embedded, agentProgress.Indexing.Chunks)
+		}
+		parts = append(parts, cases.Title(language.English).String(agentName)+": "+text)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// initializeAgent calls the constructor of an agent, a panic is returned as an error.
+func initializeAgent(agentName string) (agentConfig *agents.AgentConfig, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	agentConfig, err = agentInitializers[agentName]()
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: robby does not check the model, the completions would fail at the first message
+	if agentConfig.Agent == nil || agentConfig.Agent.Params.Model == "" {
+		return nil, errors.New("no chat model (see the MODEL_RUNNER_* variables)")
+	}
+	// NOTE: the copies of the agent (ex: to ask a clone) start from the persona, never from the messages of a conversation
+	agentConfig.Persona = slices.Clone(agentConfig.Agent.Params.Messages)
+	return agentConfig, nil
+}
+
+func (catalog *Catalog) initialize(agentName string) bool {
+	agentConfig, err := initializeAgent(agentName)
+
+	catalog.mutex.Lock()
+	defer catalog.mutex.Unlock()
+	if err != nil {
+		catalog.errors[agentName] = err
+		slog.Error("😡 agent unavailable", "agent", agentName, "error", err)
+		return false
+	}
+	delete(catalog.errors, agentName)
+	catalog.agents[agentName] = agentConfig
+	slog.Info("✅ agent available", "agent", agentConfig.Name)
+	return true
+}
+
+// Get returns an available agent (false if the agent is unavailable).
+func (catalog *Catalog) Get(agentName string) (*agents.AgentConfig, bool) {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	agentConfig, ok := catalog.agents[agentName]
+	return agentConfig, ok
+}
+
+// Agent returns the robby agent of an available agent (nil if the agent is unavailable), ex: Riker.
+func (catalog *Catalog) Agent(agentName string) *robby.Agent {
+	agentConfig, ok := catalog.Get(agentName)
+	if !ok {
+		return nil
+	}
+	return agentConfig.Agent
+}
+
+// RequestAgent returns a copy of the robby agent of an available agent for a request (nil if the agent is unavailable).
+// The tool agents (Riker, Khan and Spock) are shared by the concurrent requests:
+// the copy has its own messages, tools and tool calls, the changes of a request are not seen by the others.
+func (catalog *Catalog) RequestAgent(agentName string) *robby.Agent {
+	agent := catalog.Agent(agentName)
+	if agent == nil {
+		return nil
+	}
+	requestAgent := *agent
+	requestAgent.Params.Messages = slices.Clone(agent.Params.Messages)
+	requestAgent.Tools = slices.Clone(agent.Tools)
+	requestAgent.ToolCalls = nil
+	return &requestAgent
+}
+
+// SessionClone returns a copy of a clone for a request of a session: the messages of its agent are the persona of the clone
+// followed by the conversation of the session with this clone (see session.Session.History).
+// The agents of the clones are shared by the sessions, the changes of the messages of the copy are not seen by the other requests:
+// the new messages are added to the history of the session at the end of the request.
+func SessionClone(clone *agents.AgentConfig, chatSession *session.Session) *agents.AgentConfig {
+	requestClone := *clone
+	requestAgent := *clone.Agent
+	requestAgent.Params.Messages = append(slices.Clone(clone.Persona), chatSession.CloneHistory(clone.Name)...)
+	requestAgent.Tools = nil
+	requestAgent.ToolCalls = nil
+	requestClone.Agent = &requestAgent
+	return &requestClone
+}
+
+// Agents returns the available agents.
+func (catalog *Catalog) Agents() map[string]*agents.AgentConfig {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	return maps.Clone(catalog.agents)
+}
+
+// Clones returns the available clones of Bob.
+func (catalog *Catalog) Clones() map[string]*agents.AgentConfig {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	clones := map[string]*agents.AgentConfig{}
+	for _, cloneName := range CloneNames {
+		if clone, ok := catalog.agents[cloneName]; ok {
+			clones[cloneName] = clone
+		}
+	}
+	return clones
+}
+
+// FirstClone returns the first available clone (in the order of CloneNames), nil if no clone is available.
+func (catalog *Catalog) FirstClone() *agents.AgentConfig {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	for _, cloneName := range CloneNames {
+		if clone, ok := catalog.agents[cloneName]; ok {
+			return clone
+		}
+	}
+	return nil
+}
+
+// SelectClone returns the clone answering a request of a session: the clone selected by the previous messages,
+// else Bob when he is ready, else the first available clone if he is unavailable (the unavailable clones are skipped).
+// When no clone can answer, the reason is sent to the stream and nil is returned:
+// during the first initialization, the request is answered immediately with a 503 (Retry-After) and the startup progress.
+func (catalog *Catalog) SelectClone(response http.ResponseWriter, flusher http.Flusher, cloneName string) *agents.AgentConfig {
+	if clone, ok := catalog.Get(cloneName); ok {
+		return clone
+	}
+	if bob, ok := catalog.Get("bob"); ok {
+		return bob
+	}
+	if !catalog.Initialized() {
+		// NOTE: the RAG memories are being built, answer immediately instead of waiting
+		response.Header().Set("Retry-After", "10")
+		response.WriteHeader(http.StatusServiceUnavailable)
+		helpers.ResponseLabel(response, flusher, "warning", "Bob is warming up, please retry in a few seconds ("+FormatStartupProgress(catalog.Progress())+")")
+		return nil
+	}
+	if clone := catalog.FirstClone(); clone != nil {
+		return clone
+	}
+	helpers.ResponseLabel(response, flusher, "error", "No clone of Bob is available, please retry later")
+	return nil
+}
+
+// Errors returns the initialization errors of the unavailable agents.
+func (catalog *Catalog) Errors() map[string]error {
+	catalog.mutex.RLock()
+	defer catalog.mutex.RUnlock()
+	return maps.Clone(catalog.errors)
+}
+
+// RetryUnavailableAgents initializes again the unavailable agents at every interval (ex: when the docs folder
+// or the model appears), once the first initialization is done, until all the agents are available or the context is done.
+func (catalog *Catalog) RetryUnavailableAgents(ctx context.Context, interval time.Duration) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-catalog.initialized:
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		agentErrors := catalog.Errors()
+		if len(agentErrors) == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for agentName := range agentErrors {
+			slog.Info("🔁 retrying the initialization of the agent", "agent", agentName)
+			catalog.initialize(agentName)
+		}
+	}
+}
+
+// InitializeClones initializes only the clones of Bob (with their RAG memories),
+// without the tool agents (ex: to evaluate the retrieval, or to expose the clones as MCP tools).
+func InitializeClones() (map[string]*agents.AgentConfig, error) {
+	rag.SetEmbeddingParallelism(config.Get().Startup.EmbeddingParallelism)
+	clones := map[string]*agents.AgentConfig{}
+	for _, cloneName := range CloneNames {
+		clone, err := initializeAgent(cloneName)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing %s agent: %w", cloneName, err)
+		}
+		clones[cloneName] = clone
+	}
+	return clones, nil
 }
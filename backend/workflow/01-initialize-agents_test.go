@@ -0,0 +1,232 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"we-are-legion/agents"
+	"we-are-legion/session"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+func TestRequestAgent(t *testing.T) {
+	khan := &robby.Agent{Tools: []openai.ChatCompletionToolParam{{Function: openai.FunctionDefinitionParam{Name: "brave_web_search"}}}}
+	khan.Params.Messages = []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are Khan")}
+	catalog := &Catalog{agents: map[string]*agents.AgentConfig{"khan": {Name: "Khan", Agent: khan}}}
+
+	if catalog.RequestAgent("riker") != nil {
+		t.Error("RequestAgent() of an unavailable agent must be nil")
+	}
+
+	first, second := catalog.RequestAgent("khan"), catalog.RequestAgent("khan")
+	first.Params.Messages = append(first.Params.Messages, openai.UserMessage("first question"))
+	first.Tools = first.Tools[:0]
+	first.ToolCalls = []openai.ChatCompletionMessageToolCall{{ID: "call_1"}}
+	second.Params.Messages[0] = openai.SystemMessage("changed")
+
+	if len(khan.Params.Messages) != 1 || *khan.Params.Messages[0].GetContent().AsAny().(*string) != "You are Khan" {
+		t.Errorf("the messages of the shared agent have changed: %v", khan.Params.Messages)
+	}
+	if len(khan.Tools) != 1 || khan.ToolCalls != nil {
+		t.Errorf("the tools of the shared agent have changed: %v %v", khan.Tools, khan.ToolCalls)
+	}
+	if len(second.Params.Messages) != 1 || len(second.Tools) != 1 || second.ToolCalls != nil {
+		t.Errorf("a request sees the changes of another one: %v %v %v", second.Params.Messages, second.Tools, second.ToolCalls)
+	}
+}
+
+func TestSessionClone(t *testing.T) {
+	bob := &robby.Agent{}
+	persona := []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are Bob")}
+	bob.Params.Messages = slices.Clone(persona)
+	clone := &agents.AgentConfig{Name: "Bob", Agent: bob, Persona: persona}
+
+	sessions := []*session.Session{session.Get("test-session-clone-1"), session.Get("test-session-clone-2")}
+	sessions[0].AddToHistory("bob", openai.UserMessage("previous question"), openai.AssistantMessage("previous answer"))
+
+	// NOTE: the requests of the sessions run concurrently (see go test -race)
+	var waitGroup sync.WaitGroup
+	for index, chatSession := range sessions {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			requestClone := SessionClone(clone, chatSession)
+			requestClone.Agent.Params.Messages = append(requestClone.Agent.Params.Messages, openai.UserMessage(fmt.Sprintf("question %d", index+1)))
+			chatSession.AddToHistory(requestClone.Name, requestClone.Agent.Params.Messages[len(requestClone.Agent.Params.Messages)-1])
+		}()
+	}
+	waitGroup.Wait()
+
+	if len(bob.Params.Messages) != 1 {
+		t.Errorf("the messages of the shared agent have changed: %v", bob.Params.Messages)
+	}
+	tests := []struct {
+		session *session.Session
+		want    []string
+	}{
+		{sessions[0], []string{"You are Bob", "previous question", "previous answer", "question 1"}},
+		{sessions[1], []string{"You are Bob", "question 2"}},
+	}
+	for _, test := range tests {
+		contents := []string{}
+		for _, message := range SessionClone(clone, test.session).Agent.Params.Messages {
+			contents = append(contents, *message.GetContent().AsAny().(*string))
+		}
+		if !slices.Equal(contents, test.want) {
+			t.Errorf("the messages of %s = %q, want %q", test.session.ID, contents, test.want)
+		}
+	}
+}
+
+// setAgentInitializers replaces the constructors of the agents of the catalog during the test.
+func setAgentInitializers(t *testing.T, initializers map[string]func() (*agents.AgentConfig, error)) {
+	previous := agentInitializers
+	agentInitializers = initializers
+	t.Cleanup(func() { agentInitializers = previous })
+}
+
+func newTestAgent(name string) *agents.AgentConfig {
+	agent := &robby.Agent{}
+	agent.Params.Model = "fake"
+	agent.Params.Messages = []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are " + name)}
+	return &agents.AgentConfig{Name: name, Agent: agent}
+}
+
+func TestRetryUnavailableAgents(t *testing.T) {
+	// NOTE: Bill fails twice (ex: the docs folder is missing), then succeeds
+	var billAttempts atomic.Int32
+	setAgentInitializers(t, map[string]func() (*agents.AgentConfig, error){
+		"bob": func() (*agents.AgentConfig, error) { return newTestAgent("Bob"), nil },
+		"bill": func() (*agents.AgentConfig, error) {
+			if billAttempts.Add(1) <= 2 {
+				return nil, errors.New("no docs folder")
+			}
+			return newTestAgent("Bill"), nil
+		},
+		"riker": func() (*agents.AgentConfig, error) { panic("no model") },
+	})
+
+	catalog := InitializeAgents()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	retried := make(chan struct{})
+	go func() {
+		defer close(retried)
+		catalog.RetryUnavailableAgents(ctx, 10*time.Millisecond)
+	}()
+
+	// NOTE: Riker never succeeds, the retries stop with the context
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, ok := catalog.Get("bill"); ok {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Bill is still unavailable after %d attempts: %v", billAttempts.Load(), catalog.Errors())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if attempts := billAttempts.Load(); attempts != 3 {
+		t.Errorf("Bill was initialized %d times, want 3", attempts)
+	}
+	agentErrors := catalog.Errors()
+	if _, ok := agentErrors["bill"]; ok || len(agentErrors) != 1 || agentErrors["riker"] == nil {
+		t.Errorf("Errors() = %v, want only the error of Riker", agentErrors)
+	}
+	progress := catalog.Progress()
+	if !progress.Done || progress.Agents["bill"].Status != AgentReady || progress.Agents["riker"].Status != AgentUnavailable {
+		t.Errorf("Progress() = %+v, want Bill ready and Riker unavailable", progress)
+	}
+	if bill, _ := catalog.Get("bill"); len(bill.Persona) != 1 {
+		t.Errorf("the persona of Bill is not recorded at the retry: %v", bill.Persona)
+	}
+
+	cancel()
+	select {
+	case <-retried:
+	case <-time.After(time.Second):
+		t.Error("RetryUnavailableAgents() does not stop with the context")
+	}
+}
+
+func TestRetryUnavailableAgentsAllAvailable(t *testing.T) {
+	setAgentInitializers(t, map[string]func() (*agents.AgentConfig, error){
+		"bob": func() (*agents.AgentConfig, error) { return newTestAgent("Bob"), nil },
+	})
+
+	catalog := InitializeAgents()
+	retried := make(chan struct{})
+	go func() {
+		defer close(retried)
+		catalog.RetryUnavailableAgents(context.Background(), time.Hour)
+	}()
+	select {
+	case <-retried:
+	case <-time.After(5 * time.Second):
+		t.Error("RetryUnavailableAgents() does not stop when all the agents are available")
+	}
+}
+
+func TestSelectClone(t *testing.T) {
+	tests := []struct {
+		name        string
+		available   []string
+		initialized bool
+		cloneName   string
+		want        string
+		wantStatus  int
+		wantLabel   string
+	}{
+		{name: "warming up", wantStatus: http.StatusServiceUnavailable, wantLabel: "Bob is warming up"},
+		{name: "warming up without Bob", available: []string{"bill", "riker"}, wantStatus: http.StatusServiceUnavailable, wantLabel: "Bill: ready"},
+		{name: "Bob is ready while warming up", available: []string{"bob"}, want: "Bob", wantStatus: http.StatusOK},
+		{name: "the selected clone is ready while warming up", available: []string{"garfield"}, cloneName: "garfield", want: "Garfield", wantStatus: http.StatusOK},
+		{name: "the selected clone", available: []string{"bob", "milo"}, initialized: true, cloneName: "milo", want: "Milo", wantStatus: http.StatusOK},
+		{name: "the selected clone is unavailable", available: []string{"bob"}, initialized: true, cloneName: "milo", want: "Bob", wantStatus: http.StatusOK},
+		{name: "Bob is unavailable", available: []string{"garfield", "bill"}, initialized: true, want: "Bill", wantStatus: http.StatusOK},
+		{name: "no clone is available", available: []string{"riker"}, initialized: true, wantStatus: http.StatusOK, wantLabel: "No clone of Bob is available"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalog := &Catalog{agents: map[string]*agents.AgentConfig{}, startTime: time.Now(), initialized: make(chan struct{})}
+			for _, agentName := range test.available {
+				catalog.agents[agentName] = newTestAgent(strings.ToUpper(agentName[:1]) + agentName[1:])
+			}
+			if test.initialized {
+				close(catalog.initialized)
+			}
+			if done := catalog.Progress().Done; done != test.initialized {
+				t.Fatalf("Progress().Done = %v, want %v", done, test.initialized)
+			}
+
+			recorder := httptest.NewRecorder()
+			clone := catalog.SelectClone(recorder, recorder, test.cloneName)
+			switch {
+			case test.want == "" && clone != nil:
+				t.Errorf("SelectClone() = %s, want nil", clone.Name)
+			case test.want != "" && (clone == nil || clone.Name != test.want):
+				t.Errorf("SelectClone() = %v, want %s", clone, test.want)
+			}
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if retryAfter := recorder.Header().Get("Retry-After"); (retryAfter == "10") != (test.wantStatus == http.StatusServiceUnavailable) {
+				t.Errorf("Retry-After = %q", retryAfter)
+			}
+			if body := recorder.Body.String(); !strings.Contains(body, test.wantLabel) || (test.wantLabel == "") != (body == "") {
+				t.Errorf("the stream is %q, want %q", body, test.wantLabel)
+			}
+		})
+	}
+}
@@ -1,48 +1,124 @@
 package workflow
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"sync"
+	"time"
 	"we-are-legion/helpers"
+	"we-are-legion/logging"
 
 	"github.com/openai/openai-go"
 	"github.com/sea-monkeys/robby"
 )
 
-func DetectToolCalls(response http.ResponseWriter, flusher http.Flusher, riker *robby.Agent) ([]openai.ChatCompletionMessageToolCall, error) {
-	toolCalls, err := riker.ToolsCompletion()
+func DetectToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, riker *robby.Agent) ([]openai.ChatCompletionMessageToolCall, error) {
+	toolCalls, err := toolsCompletion(ctx, "riker.tools_completion", "Riker", riker)
 	if err != nil {
 		if len(toolCalls) > 0 {
-			fmt.Println("😡 Error: ", err.Error())
+			slog.ErrorContext(ctx, "😡 tool calls detection failed", "error", err)
 			helpers.ResponseLabel(response, flusher, "error", "Tool call error detected: "+err.Error())
 		} else {
-			fmt.Println("🙂 no tool calls detected.")
+			slog.InfoContext(ctx, "🙂 no tool calls detected")
 			helpers.ResponseLabel(response, flusher, "success", "No tool calls detected")
 		}
 	}
-	fmt.Println("🤖 Number of Tool Calls:", len(toolCalls))
+	slog.InfoContext(ctx, "🤖 tool calls detected", "count", len(toolCalls))
 	if len(toolCalls) > 0 {
 		toolCallsJSON, _ := riker.ToolCallsToJSON()
-		fmt.Println("🤖 Tool Calls:\n", toolCallsJSON)
+		slog.DebugContext(ctx, "🤖 tool calls", "tool_calls", toolCallsJSON)
 	}
 	return toolCalls, err
 }
 
-func DetectMCPToolCalls(response http.ResponseWriter, flusher http.Flusher, khan *robby.Agent) ([]openai.ChatCompletionMessageToolCall, error) {
-	mcpTooCalls, err := khan.ToolsCompletion()
+func DetectMCPToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, khan *robby.Agent) ([]openai.ChatCompletionMessageToolCall, error) {
+	mcpTooCalls, err := toolsCompletion(ctx, "khan.tools_completion", "Khan", khan)
 	if err != nil {
 		if len(mcpTooCalls) > 0 {
-			fmt.Println("😡 Error: ", err.Error())
+			slog.ErrorContext(ctx, "😡 tool calls detection failed", "error", err)
 			helpers.ResponseLabel(response, flusher, "error", "MCP Tool call error detected: "+err.Error())
 		} else {
-			fmt.Println("🙂 no tool calls detected.")
+			slog.InfoContext(ctx, "🙂 no tool calls detected")
 			helpers.ResponseLabel(response, flusher, "success", "No MCP tool calls detected")
 		}
 	}
-	fmt.Println("🤖 Number of MCP Tool Calls:", len(mcpTooCalls))
+	slog.InfoContext(ctx, "🤖 MCP tool calls detected", "count", len(mcpTooCalls))
 	if len(mcpTooCalls) > 0 {
 		mcpToolCallsJSON, _ := khan.ToolCallsToJSON()
-		fmt.Println("🤖 MCP Tool Calls:\n", mcpToolCallsJSON)
+		slog.DebugContext(ctx, "🤖 MCP tool calls", "tool_calls", mcpToolCallsJSON)
 	}
 	return mcpTooCalls, err
 }
+
+// Detector is a tool calls detection pass run before the answer, ex: Riker (the clones) and Khan (the MCP tools).
+type Detector struct {
+	Name   string // name of the tool agent, ex: Riker
+	Detect func(ctx context.Context, response http.ResponseWriter, flusher http.Flusher) ([]openai.ChatCompletionMessageToolCall, error)
+}
+
+// RikerDetector returns the detection of the tool calls of Riker (see DetectToolCalls).
+func RikerDetector(riker *robby.Agent) Detector {
+	return Detector{
+		Name: "Riker",
+		Detect: func(ctx context.Context, response http.ResponseWriter, flusher http.Flusher) ([]openai.ChatCompletionMessageToolCall, error) {
+			return DetectToolCalls(ctx, response, flusher, riker)
+		},
+	}
+}
+
+// KhanDetector returns the detection of the MCP tool calls of Khan (see DetectMCPToolCalls).
+func KhanDetector(khan *robby.Agent) Detector {
+	return Detector{
+		Name: "Khan",
+		Detect: func(ctx context.Context, response http.ResponseWriter, flusher http.Flusher) ([]openai.ChatCompletionMessageToolCall, error) {
+			return DetectMCPToolCalls(ctx, response, flusher, khan)
+		},
+	}
+}
+
+// Detection is the result of a detector.
+type Detection struct {
+	Name      string
+	ToolCalls []openai.ChatCompletionMessageToolCall
+	Err       error
+	Duration  time.Duration
+}
+
+// Detections are the results of the detectors, in the order of the detectors.
+type Detections []Detection
+
+// ToolCalls returns the tool calls detected by a detector (nil if the detector did not run).
+func (detections Detections) ToolCalls(name string) []openai.ChatCompletionMessageToolCall {
+	for _, detection := range detections {
+		if detection.Name == name {
+			return detection.ToolCalls
+		}
+	}
+	return nil
+}
+
+// RunDetectors runs the detectors concurrently: the pre-answer latency is the one of the slowest detector.
+// Every detector reports in the stream when it finishes,
+// the results are returned in the order of the detectors (whatever the order they finish).
+func RunDetectors(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, detectors []Detector) Detections {
+	// NOTE: the detectors write their labels to the same stream
+	writer := helpers.NewSyncResponseWriter(response, flusher)
+
+	detections := make(Detections, len(detectors))
+	var waitGroup sync.WaitGroup
+	for index, detector := range detectors {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			start := time.Now()
+			toolCalls, err := detector.Detect(logging.With(ctx, "detector", detector.Name), writer, writer)
+			detection := Detection{Name: detector.Name, ToolCalls: toolCalls, Err: err, Duration: time.Since(start)}
+			detections[index] = detection
+			helpers.ResponseLabel(writer, writer, "info", fmt.Sprintf("%s done in %.1fs (%d tool calls)", detection.Name, detection.Duration.Seconds(), len(detection.ToolCalls)))
+		}()
+	}
+	waitGroup.Wait()
+	return detections
+}
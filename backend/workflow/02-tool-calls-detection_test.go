@@ -0,0 +1,108 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"we-are-legion/logging"
+
+	"github.com/openai/openai-go"
+)
+
+// reportRecorder records the stream and closes the channel of a detector when its end is reported.
+type reportRecorder struct {
+	*httptest.ResponseRecorder
+	reported map[string]chan struct{}
+}
+
+func (recorder *reportRecorder) Write(data []byte) (int, error) {
+	for name, reported := range recorder.reported {
+		if strings.Contains(string(data), name+" done") {
+			close(reported)
+		}
+	}
+	return recorder.ResponseRecorder.Write(data)
+}
+
+// newFakeDetector returns a detector which waits for the channel before detecting a tool call (nil: it does not wait).
+func newFakeDetector(name string, toolName string, err error, waitFor <-chan struct{}) Detector {
+	return Detector{
+		Name: name,
+		Detect: func(ctx context.Context, response http.ResponseWriter, flusher http.Flusher) ([]openai.ChatCompletionMessageToolCall, error) {
+			if waitFor != nil {
+				<-waitFor
+			}
+			for _, attr := range logging.Attrs(ctx) {
+				if attr.Key == "detector" && attr.Value.String() != name {
+					return nil, errors.New("wrong detector in the logs: " + attr.Value.String())
+				}
+			}
+			return []openai.ChatCompletionMessageToolCall{{ID: name, Function: openai.ChatCompletionMessageToolCallFunction{Name: toolName}}}, err
+		},
+	}
+}
+
+func TestRunDetectors(t *testing.T) {
+	detectionError := errors.New("detection failed")
+	tests := []struct {
+		name          string
+		rikerFirst    bool
+		khanErr       error
+		wantFirstDone string
+	}{
+		{"Riker finishes first", true, nil, "Riker"},
+		{"Khan finishes first", false, nil, "Khan"},
+		{"Khan fails first", false, detectionError, "Khan"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rikerDone, khanDone := make(chan struct{}), make(chan struct{})
+			recorder := &reportRecorder{ResponseRecorder: httptest.NewRecorder(), reported: map[string]chan struct{}{"Riker": rikerDone, "Khan": khanDone}}
+			// NOTE: the second detector waits for the report of the first one
+			var rikerWaitsFor, khanWaitsFor <-chan struct{}
+			if test.rikerFirst {
+				khanWaitsFor = rikerDone
+			} else {
+				rikerWaitsFor = khanDone
+			}
+			detectors := []Detector{
+				newFakeDetector("Riker", "choose_clone_of_bob", nil, rikerWaitsFor),
+				newFakeDetector("Khan", "brave_web_search", test.khanErr, khanWaitsFor),
+			}
+
+			detections := RunDetectors(context.Background(), recorder, recorder, detectors)
+
+			// NOTE: the results are in the order of the detectors, whatever the order they finish
+			if len(detections) != 2 || detections[0].Name != "Riker" || detections[1].Name != "Khan" {
+				t.Fatalf("detections = %+v, want Riker then Khan", detections)
+			}
+			for _, detection := range detections {
+				if len(detection.ToolCalls) != 1 || detection.ToolCalls[0].ID != detection.Name {
+					t.Errorf("the tool calls of %s = %+v, want its own tool call", detection.Name, detection.ToolCalls)
+				}
+			}
+			if !errors.Is(detections[1].Err, test.khanErr) || detections[0].Err != nil {
+				t.Errorf("errors = %v and %v, want nil and %v", detections[0].Err, detections[1].Err, test.khanErr)
+			}
+			if toolCalls := detections.ToolCalls("Khan"); len(toolCalls) != 1 || toolCalls[0].Function.Name != "brave_web_search" {
+				t.Errorf("ToolCalls(Khan) = %+v", toolCalls)
+			}
+			if toolCalls := detections.ToolCalls("Spock"); toolCalls != nil {
+				t.Errorf("ToolCalls(Spock) = %+v, want nil", toolCalls)
+			}
+
+			// NOTE: every detector reports in the stream when it finishes
+			stream := recorder.Body.String()
+			rikerReport, khanReport := strings.Index(stream, "Riker done"), strings.Index(stream, "Khan done")
+			if rikerReport < 0 || khanReport < 0 {
+				t.Fatalf("the detectors are not reported in the stream: %s", stream)
+			}
+			if firstDone := map[bool]string{true: "Riker", false: "Khan"}[rikerReport < khanReport]; firstDone != test.wantFirstDone {
+				t.Errorf("%s is reported first, want %s: %s", firstDone, test.wantFirstDone, stream)
+			}
+		})
+	}
+}
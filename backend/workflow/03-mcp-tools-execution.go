@@ -1,19 +1,100 @@
 package workflow
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"we-are-legion/approval"
+	"we-are-legion/config"
 	"we-are-legion/helpers"
+	"we-are-legion/mcphub"
+	"we-are-legion/tracing"
+	"we-are-legion/usage"
 
+	"github.com/openai/openai-go"
 	"github.com/sea-monkeys/robby"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ExecuteMCPToolCalls(response http.ResponseWriter, flusher http.Flusher, khan *robby.Agent) ([]string, error) {
+// ExecuteMCPToolCalls executes the MCP tool calls detected by Khan,
+// every tool call is sent to the MCP server of the hub providing the tool
+// (or answered by the cache of the hub for the cacheable tools, see config.yml).
+// The results are added to the messages of Khan (like robby does).
+// The tool calls are executed only if they are valid (see ValidateToolCalls) and approved (see ApproveToolCall).
+// A failed tool call is reported in the stream and in the logs, it is not a result (the results are cited as web sources):
+// the results of the other tool calls are kept, it returns an error only if no tool call succeeded.
+func ExecuteMCPToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, hub *mcphub.Hub, khan *robby.Agent, approvals *approval.Request) ([]string, error) {
+	// NOTE: the token usage of the retries of the validation is the one of Khan
+	ctx = usage.WithAgent(ctx, "Khan")
+	ctx, span := tracing.Start(ctx, "mcp.execute_tool_calls",
+		attribute.String(tracing.AttrAgent, "Khan"),
+		attribute.Int("tool_calls", len(khan.ToolCalls)),
+	)
+	defer span.End()
 	helpers.ResponseLabel(response, flusher, "orange", "Executing MCP tool calls...")
-	mcpResults, err := khan.ExecuteMCPToolCalls()
-	if err != nil {
-		helpers.ResponseLabel(response, flusher, "error", "MCP Tool execution failed: "+err.Error())
-	} else {
-		helpers.ResponseLabel(response, flusher, "success", "MCP Tool calls executed successfully")
+
+	mcpResults := []string{}
+	failures := []error{}
+	approvedToolCalls := 0
+	// NOTE: the invalid tool calls are not executed (see config.yml for the retries)
+	for _, toolCall := range ValidateToolCalls(ctx, response, flusher, khan, config.Get().Validation.Retries) {
+		toolName := toolCall.Function.Name
+		if !ApproveToolCall(ctx, response, flusher, approvals, hub.ServerOf(toolName), toolCall) {
+			continue
+		}
+		approvedToolCalls++
+		var args map[string]any
+		if strings.TrimSpace(toolCall.Function.Arguments) != "" {
+			if errArgs := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); errArgs != nil {
+				slog.ErrorContext(ctx, "😡 MCP tool call arguments are not valid JSON", "tool", toolName, "arguments", toolCall.Function.Arguments, "error", errArgs)
+				helpers.ResponseLabel(response, flusher, "error", "MCP tool call failed: "+toolName+" (invalid arguments)")
+				failures = append(failures, fmt.Errorf("%s: %w", toolName, errArgs))
+				continue
+			}
+		}
+		result, cacheHit, errCall := hub.CallToolCached(ctx, toolName, args)
+		if errCall != nil {
+			slog.ErrorContext(ctx, "😡 MCP tool call failed", "tool", toolName, "error", errCall)
+			helpers.ResponseLabel(response, flusher, "error", "MCP tool call failed: "+errCall.Error())
+			failures = append(failures, errCall)
+			continue
+		}
+		if cacheHit {
+			span.AddEvent("cache hit", trace.WithAttributes(attribute.String("tool", toolName)))
+			slog.InfoContext(ctx, "⚡️ MCP cache hit", "tool", toolName, "arguments", toolCall.Function.Arguments)
+			helpers.ResponseLabel(response, flusher, "green", "Cache hit: "+toolName)
+		}
+		khan.Params.Messages = append(khan.Params.Messages, openai.ToolMessage(result, toolCall.ID))
+		mcpResults = append(mcpResults, result)
+	}
+	span.SetAttributes(
+		attribute.Int("approved_tool_calls", approvedToolCalls),
+		attribute.Int("results", len(mcpResults)),
+		attribute.Int("failures", len(failures)),
+	)
+	if approvedToolCalls == 0 {
+		helpers.ResponseLabel(response, flusher, "info", "No MCP tool call executed")
+		return nil, nil
+	}
+
+	if len(mcpResults) == 0 {
+		err := errors.Join(failures...)
+		if err == nil {
+			err = errors.New("no tool responses found")
+		}
+		tracing.SetError(span, err)
+		helpers.ResponseLabel(response, flusher, "error", "MCP Tool execution failed")
+		return nil, err
+	}
+	if len(failures) > 0 {
+		helpers.ResponseLabel(response, flusher, "warning", fmt.Sprintf("%d of %d MCP tool calls failed", len(failures), approvedToolCalls))
+		return mcpResults, nil
 	}
-	return mcpResults, err
+	helpers.ResponseLabel(response, flusher, "success", "MCP Tool calls executed successfully")
+	return mcpResults, nil
 }
@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"we-are-legion/config"
+	"we-are-legion/internal/mcpstub"
+	"we-are-legion/mcphub"
+
+	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// newStubHub returns a hub connected to the stub MCP server (echo, fail, brave_web_search).
+func newStubHub(t *testing.T) *mcphub.Hub {
+	server := httptest.NewServer(mcp.NewStreamableHTTPHandler(func(*http.Request) *mcp.Server { return mcpstub.NewServer() }, nil))
+	t.Cleanup(server.Close)
+	hub := mcphub.NewHub(map[string]config.MCPServerConfig{"stub": {Transport: "http", URL: server.URL}})
+	t.Cleanup(hub.Close)
+	hub.Connect(context.Background())
+	if err := hub.Errors()["stub"]; err != nil {
+		t.Fatal(err)
+	}
+	return hub
+}
+
+func toolCall(id, name, arguments string) openai.ChatCompletionMessageToolCall {
+	return openai.ChatCompletionMessageToolCall{
+		ID:       id,
+		Function: openai.ChatCompletionMessageToolCallFunction{Name: name, Arguments: arguments},
+	}
+}
+
+func TestExecuteMCPToolCalls(t *testing.T) {
+	hub := newStubHub(t)
+	tests := []struct {
+		name        string
+		toolCalls   []openai.ChatCompletionMessageToolCall
+		wantResults []string
+		wantError   string
+		wantLabel   string
+	}{
+		{
+			name:        "all the tool calls succeed",
+			toolCalls:   []openai.ChatCompletionMessageToolCall{toolCall("1", "echo", `{"text":"one"}`), toolCall("2", "echo", `{"text":"two"}`)},
+			wantResults: []string{"one", "two"},
+			wantLabel:   "executed successfully",
+		},
+		{
+			name:        "a failed tool call is not a result",
+			toolCalls:   []openai.ChatCompletionMessageToolCall{toolCall("1", "fail", `{"text":"oops"}`), toolCall("2", "echo", `{"text":"two"}`)},
+			wantResults: []string{"two"},
+			wantLabel:   "1 of 2 MCP tool calls failed",
+		},
+		{
+			name:      "all the tool calls fail",
+			toolCalls: []openai.ChatCompletionMessageToolCall{toolCall("1", "fail", `{"text":"oops"}`)},
+			wantError: "failure: oops",
+			wantLabel: "MCP tool call failed",
+		},
+		{
+			name:      "no tool call",
+			toolCalls: nil,
+			wantLabel: "No MCP tool call executed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			khan := &robby.Agent{Tools: hub.Tools([]string{"stub/*"}), ToolCalls: test.toolCalls}
+			recorder := httptest.NewRecorder()
+
+			results, err := ExecuteMCPToolCalls(context.Background(), recorder, recorder, hub, khan, nil)
+			if !slices.Equal(results, test.wantResults) {
+				t.Errorf("results = %q, want %q", results, test.wantResults)
+			}
+			if test.wantError == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.wantError != "" && (err == nil || !strings.Contains(err.Error(), test.wantError)) {
+				t.Errorf("error = %v, want %q", err, test.wantError)
+			}
+			if !strings.Contains(recorder.Body.String(), test.wantLabel) {
+				t.Errorf("the stream does not contain %q: %s", test.wantLabel, recorder.Body.String())
+			}
+		})
+	}
+}
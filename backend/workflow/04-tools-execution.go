@@ -1,21 +1,69 @@
 package workflow
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"we-are-legion/agents"
+	"we-are-legion/approval"
+	"we-are-legion/config"
 	"we-are-legion/helpers"
+	"we-are-legion/session"
+	"we-are-legion/tracing"
+	"we-are-legion/usage"
 
 	"github.com/openai/openai-go"
 	"github.com/sea-monkeys/robby"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
-func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsCatalog map[string]*agents.AgentConfig, riker *robby.Agent, selectedAgent *agents.AgentConfig) ([]string, error) {
+// ExecuteToolCalls executes the tool calls detected by Riker.
+// It returns the results of the tool calls and the selected agent (it changes if the user wants to speak with another clone).
+// Only the valid and approved tool calls are executed (the tools of Riker belong to the "riker" server, see ApproveToolCall).
+// The messages of the tool calls are added to the conversation of the session with the selected clone.
+func ExecuteToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher,agentsCatalog map[string]*agents.AgentConfig, riker *robby.Agent, selectedAgent *agents.AgentConfig, chatSession *session.Session, approvals *approval.Request) ([]string, *agents.AgentConfig, error) {
+	// NOTE: the token usage of the retries of the validation is the one of Riker
+	ctx = usage.WithAgent(ctx, "Riker")
+	ctx, span := tracing.Start(ctx, "riker.execute_tool_calls",
+		attribute.String(tracing.AttrAgent, "Riker"),
+		attribute.Int("tool_calls", len(riker.ToolCalls)),
+	)
+	defer span.End()
 	helpers.ResponseLabel(response, flusher, "orange", "Executing tool calls...")
 
+	// NOTE: the invalid tool calls are not executed (see config.yml for the retries)
+	validToolCalls := ValidateToolCalls(ctx, response, flusher, riker, config.Get().Validation.Retries)
+
+	approvedToolCalls := []openai.ChatCompletionMessageToolCall{}
+	for _, toolCall := range validToolCalls {
+		if ApproveToolCall(ctx, response, flusher, approvals, "riker", toolCall) {
+			approvedToolCalls = append(approvedToolCalls, toolCall)
+		}
+	}
+	riker.ToolCalls = approvedToolCalls
+	span.SetAttributes(attribute.Int("approved_tool_calls", len(approvedToolCalls)))
+	if len(approvedToolCalls) == 0 {
+		helpers.ResponseLabel(response, flusher, "info", "No tool call executed")
+		riker.Params.Messages = []openai.ChatCompletionMessageParamUnion{}
+		return nil, selectedAgent, nil
+	}
+
+	// NOTE: an unavailable clone (see Catalog) can not be selected, the current clone keeps the conversation
+	selectClone := func(cloneName string) bool {
+		clone, ok := agentsCatalog[cloneName]
+		if !ok {
+			slog.WarnContext(ctx, "🚧 unavailable clone", "clone", cloneName)
+			helpers.ResponseLabel(response, flusher, "warning", cloneName+" is unavailable, you are still speaking with "+selectedAgent.Name)
+			return false
+		}
+		selectedAgent = clone
+		return true
+	}
+
 	// IMPORTANT: 
 	// the job of Riker is only to detect if the user wants to change the current Agent,
 	// and to execute the tool calls.
@@ -27,13 +75,19 @@ func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsC
 		"choose_clone_of_bob": func(args any) (any, error) {
 
 			helpers.ResponseLabel(response, flusher, "yellow", "Selecting Bob clone...")
-			cloneName := args.(map[string]any)["clone_name"].(string)
+			arguments, ok := args.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("invalid arguments of choose_clone_of_bob: %v", args)
+			}
+			cloneName, _ := arguments["clone_name"].(string)
 			cloneName = strings.ToLower(cloneName)
 
 			switch cloneName {
 			case "bill", "milo", "garfield", "bob":
 				// NOTE: change the current selection to the selected clone
-				selectedAgent = agentsCatalog[cloneName]
+				if !selectClone(cloneName) {
+					return fmt.Sprintf("%s is unavailable", cloneName), nil
+				}
 
 				caser := cases.Title(language.English)
 				cloneName = caser.String(cloneName)
@@ -41,8 +95,8 @@ func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsC
 				txtLabel := "Hey, it's " + cloneName + ", " + selectedAgent.Agent.Params.Model
 				helpers.ResponseLabel(response, flusher, "enhancement", txtLabel)
 
-				// NOTE: conversational memory
-				selectedAgent.Agent.Params.Messages = append(selectedAgent.Agent.Params.Messages,
+				// NOTE: conversational memory (of the session)
+				chatSession.AddToHistory(selectedAgent.Name,
 					// IMPORTANT: QUESTION: should I use a system message or a agent message?
 					openai.SystemMessage("You have been selected to speak with the user, your name is: "+selectedAgent.Name),
 					//openai.SystemMessage("use the above result of the tool calls to answer the user question: "),
@@ -63,7 +117,11 @@ func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsC
 		"detect_the_real_topic_in_user_message": func(args any) (any, error) {
 			helpers.ResponseLabel(response, flusher, "step", "Detecting the real topic in user message...")
 
-			topic := args.(map[string]any)["topic_name"].(string)
+			arguments, ok := args.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("invalid arguments of detect_the_real_topic_in_user_message: %v", args)
+			}
+			topic, _ := arguments["topic_name"].(string)
 			// Here you can implement your logic to detect the real topic in the user message
 			// For now, we will just return the user message as the detected topic
 			helpers.ResponseLabel(response, flusher, "white", "Topic: "+topic)
@@ -73,22 +131,26 @@ func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsC
 				// NOTE: change the current selection to the selected clone
 				switch topic {
 				case "docker":
-					selectedAgent = agentsCatalog["bob"]
-					helpers.ResponseLabel(response, flusher, "pink", "You are speaking with Bob")
+					if selectClone("bob") {
+						helpers.ResponseLabel(response, flusher, "pink", "You are speaking with Bob")
+					}
 				case "docker compose":
-					selectedAgent = agentsCatalog["bill"]
-					helpers.ResponseLabel(response, flusher, "orange", "You are speaking with Bill")
+					if selectClone("bill") {
+						helpers.ResponseLabel(response, flusher, "orange", "You are speaking with Bill")
+					}
 				case "docker model runner":
-					selectedAgent = agentsCatalog["garfield"]
-					helpers.ResponseLabel(response, flusher, "red", "You are speaking with Garfield")
+					if selectClone("garfield") {
+						helpers.ResponseLabel(response, flusher, "red", "You are speaking with Garfield")
+					}
 				case "docker bake":
-					selectedAgent = agentsCatalog["milo"]
-					helpers.ResponseLabel(response, flusher, "warning", "You are speaking with Milo")
+					if selectClone("milo") {
+						helpers.ResponseLabel(response, flusher, "warning", "You are speaking with Milo")
+					}
 
 				}
 			}
 
-			selectedAgent.Agent.Params.Messages = append(selectedAgent.Agent.Params.Messages,
+			chatSession.AddToHistory(selectedAgent.Name,
 				// IMPORTANT: QUESTION: should I use a system message or a agent message?
 				openai.AssistantMessage("I understand that you want to talk about: "+topic),
 				//openai.SystemMessage("You have been selected to speak with the user, your name is: "+currentSelection.Name),
@@ -96,27 +158,24 @@ func ExecuteToolCalls(response http.ResponseWriter, flusher http.Flusher,agentsC
 				//openai.UserMessage(userQuestion),
 			)
 
-			fmt.Println("🤖 Detected topic in user message:", topic)
+			slog.InfoContext(ctx, "🤖 detected topic in user message", "topic", topic)
 			return topic, nil
 		},
 	}) // END: execute the tool calls
 
+	span.SetAttributes(attribute.String("selected_agent", selectedAgent.Name))
 	if err != nil {
+		tracing.SetError(span, err)
 		helpers.ResponseLabel(response, flusher, "error", "Tool execution failed: "+err.Error())
 	} else {
 		helpers.ResponseLabel(response, flusher, "success", "Tool calls executed successfully")
 	}
 
-	fmt.Println("")
-
-	// Print the results of the tool calls
-	fmt.Println("🤖 Results of the tool calls execution:")
-	for _, result := range results {
-		fmt.Println(result)
-	}
+	// Log the results of the tool calls
+	slog.InfoContext(ctx, "🤖 tool calls executed", "results", results)
 
 	// NOTE: reset the Riker messages
 	riker.Params.Messages = []openai.ChatCompletionMessageParamUnion{}
 
-	return results, err
+	return results, selectedAgent, err
 }
@@ -1,38 +1,105 @@
 package workflow
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
 	"strings"
 	"we-are-legion/agents"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/rag"
+	"we-are-legion/tracing"
 
-	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func SearchSimilarities(selectedAgent *agents.AgentConfig, userQuestion string) []string {
-	similarities, err := selectedAgent.Agent.RAGMemorySearchSimilaritiesWithText(userQuestion, 0.7)
-	if err != nil {
-		fmt.Println("Error when searching for similarities:", err)
-		// NOTE: do nothing, just continue the conversation
+// SearchSimilarities searches the RAG memory of the selected agent and returns the documents as context items (see AssembleContext).
+// The search is done with the search queries if any (ex: rewritten by Spock), otherwise with the user question.
+// The retrieved chunks are merged into contiguous spans and the near-duplicates are removed.
+// The retrieval parameters are the ones of the agent, merged with the overrides of the request.
+func SearchSimilarities(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, selectedAgent *agents.AgentConfig, retrieval config.RetrievalConfig, userQuestion string, searchQueries ...string) []ContextItem {
+	if len(searchQueries) == 0 {
+		searchQueries = []string{userQuestion}
 	}
-	fmt.Println("🎉 Similarities found:", len(similarities))
-	//for _, similarity := range similarities {
-	//	fmt.Println("-", similarity)
-	//}
-	if len(similarities) > 0 {
-		// NOTE: conversational memory, add the similarities to the Agent's message
-		selectedAgent.Agent.Params.Messages = append(
-			selectedAgent.Agent.Params.Messages,
-			openai.SystemMessage(
-				"Here are some relevant documents found in the RAG memory:\n"+strings.Join(similarities, "\n"),
-			),
-			openai.SystemMessage("Use the above documents to answer the user question: "),
-			openai.UserMessage(userQuestion),
-		)
-	} else {
-		// NOTE: conversational memory, add the question to the Agent's message
-		selectedAgent.Agent.Params.Messages = append(
-			selectedAgent.Agent.Params.Messages, openai.UserMessage(userQuestion),
-		)
+	ctx, span := tracing.Start(ctx, "rag.search_similarities",
+		attribute.String(tracing.AttrAgent, selectedAgent.Name),
+		attribute.String("embedding_model", selectedAgent.Agent.EmbeddingParams.Model),
+		attribute.Int("queries", len(searchQueries)),
+		attribute.Int("top_k", retrieval.TopK),
+		attribute.Float64("similarity_threshold", retrieval.SimilarityThreshold),
+	)
+	defer span.End()
+	similarities := []rag.Similarity{}
+	for _, searchQuery := range searchQueries {
+		results, err := SearchDocuments(ctx, selectedAgent, searchQuery, retrieval)
+		if err != nil {
+			slog.ErrorContext(ctx, "😡 similarity search failed", "query", searchQuery, "error", err)
+			tracing.SetError(span, err)
+			// NOTE: do nothing, just continue the conversation
+		}
+		for _, result := range results {
+			if !slices.ContainsFunc(similarities, func(similarity rag.Similarity) bool {
+				return similarity.ID == result.ID && similarity.Content == result.Content
+			}) {
+				similarities = append(similarities, result)
+			}
+		}
 	}
-	return similarities
+	slog.InfoContext(ctx, "🎉 similarities found", "count", len(similarities))
+	span.SetAttributes(attribute.Int("similarities", len(similarities)))
+	if len(similarities) == 0 {
+		return nil
+	}
+	// NOTE: the similarities of several queries are sorted by score
+	sort.SliceStable(similarities, func(i, j int) bool {
+		return similarities[i].Score > similarities[j].Score
+	})
+	scores := []float64{}
+	for _, similarity := range similarities {
+		scores = append(scores, similarity.Score)
+	}
+	span.SetAttributes(attribute.Float64Slice("similarity_scores", scores), attribute.Float64("top_score", scores[0]))
+
+	contents := []string{}
+	for _, similarity := range similarities {
+		contents = append(contents, similarity.Content)
+	}
+	tokensBefore := rag.EstimateTokens(strings.Join(contents, "\n"))
+	contents = rag.MergeChunks(rag.ChunksOf(similarities), rag.DuplicateThreshold)
+	if retrieval.MaxContextTokens > 0 {
+		contents = rag.LimitTokens(contents, retrieval.MaxContextTokens)
+	}
+	tokensAfter := rag.EstimateTokens(strings.Join(contents, "\n"))
+	span.SetAttributes(attribute.Int("documents", len(contents)), attribute.Int("context_tokens", tokensAfter))
+
+	slog.InfoContext(ctx, "✂️ merged and limited similarities", "count", len(contents), "saved_tokens", tokensBefore-tokensAfter)
+	helpers.ResponseLabel(response, flusher, "white", fmt.Sprintf("%d documents, ~%d tokens saved", len(contents), tokensBefore-tokensAfter))
+
+	items := []ContextItem{}
+	for rank, content := range contents {
+		// NOTE: a merged span starts with the content of its first chunk
+		source := ""
+		for _, similarity := range similarities {
+			if strings.Contains(content, similarity.Content) {
+				source = similarity.Source
+				break
+			}
+		}
+		label := "docs"
+		if source != "" {
+			label += ": " + source
+		}
+		items = append(items, ContextItem{
+			Provenance: ProvenanceDocs,
+			Source:     source,
+			Label:      label,
+			Content:    content,
+			Rank:       rank,
+		})
+	}
+	return items
 }
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"we-are-legion/helpers"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// GetRecentHistory returns the last user and assistant messages of the conversation as a text transcript.
+func GetRecentHistory(messages []openai.ChatCompletionMessageParamUnion, maxMessages int) string {
+	lines := []string{}
+	for i := len(messages) - 1; i >= 0 && len(lines) < maxMessages; i-- {
+		// NOTE: the role is given by the variant of the message (the role field is only set by the JSON encoding)
+		var role string
+		switch {
+		case messages[i].OfUser != nil:
+			role = "user"
+		case messages[i].OfAssistant != nil:
+			role = "assistant"
+		default:
+			continue
+		}
+		content, ok := messages[i].GetContent().AsAny().(*string)
+		if !ok || content == nil || *content == "" {
+			continue
+		}
+		lines = append([]string{role + ": " + *content}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RewriteQuery uses the recent history of the conversation to turn the user question
+// into standalone search queries (ex: "and with profiles?" -> "How to use profiles with Docker Compose?").
+// It returns the user question if there is no history or if the rewriting fails.
+func RewriteQuery(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, spock *robby.Agent, history []openai.ChatCompletionMessageParamUnion, userQuestion string, maxQueries int) []string {
+	transcript := GetRecentHistory(history, 6)
+	if transcript == "" {
+		// NOTE: first message of the conversation, nothing to rewrite
+		return []string{userQuestion}
+	}
+
+	helpers.ResponseLabel(response, flusher, "step", "Rewriting the search query...")
+
+	spock.Params.Messages = []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage(fmt.Sprintf(`
+		Your name is Spock.
+		Rewrite the last question of the user into standalone search queries,
+		replacing the pronouns and the references to the previous messages by what they refer to.
+		Write at most %d search queries, one per line, without numbering and without any other text.
+		If the question is already standalone, write it unchanged.
+		`, maxQueries)),
+		openai.UserMessage("Conversation:\n" + transcript + "\n\nLast question:\n" + userQuestion),
+	}
+	rewrite, err := chatCompletion(ctx, "spock.chat_completion", "Spock", spock)
+	if err != nil {
+		slog.ErrorContext(ctx, "😡 query rewriting failed", "error", err)
+		helpers.ResponseLabel(response, flusher, "error", "Query rewriting failed: "+err.Error())
+		return []string{userQuestion}
+	}
+
+	queries := ParseQueries(rewrite, maxQueries)
+	if len(queries) == 0 {
+		return []string{userQuestion}
+	}
+
+	for _, query := range queries {
+		slog.InfoContext(ctx, "🔎 search query", "query", query)
+		helpers.ResponseLabel(response, flusher, "question", "Search query: "+query)
+	}
+	return queries
+}
+
+// NOTE: a list marker at the beginning of a line ("- ", "* ", "1. " or "1) "), the numbers of the query are kept (ex: "3.5 release notes")
+var listMarkerRegex = regexp.MustCompile(`^\s*(?:[-*]|\d+[.)])\s+`)
+
+// ParseQueries returns the search queries written by Spock (one per line, without the list markers), at most maxQueries.
+func ParseQueries(rewrite string, maxQueries int) []string {
+	queries := []string{}
+	for _, line := range strings.Split(rewrite, "\n") {
+		query := strings.TrimSpace(listMarkerRegex.ReplaceAllString(line, ""))
+		if query != "" && len(queries) < maxQueries {
+			queries = append(queries, query)
+		}
+	}
+	return queries
+}
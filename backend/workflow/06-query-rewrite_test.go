@@ -0,0 +1,62 @@
+package workflow
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/openai/openai-go"
+)
+
+func TestGetRecentHistory(t *testing.T) {
+	conversation := []openai.ChatCompletionMessageParamUnion{
+		openai.SystemMessage("You are Bob"),
+		openai.UserMessage("What is Compose?"),
+		openai.AssistantMessage("A tool to run multi-container applications."),
+		openai.UserMessage(""),
+		openai.ToolMessage("tool result", "call-1"),
+		openai.UserMessage("And with profiles?"),
+	}
+	tests := []struct {
+		name        string
+		messages    []openai.ChatCompletionMessageParamUnion
+		maxMessages int
+		want        string
+	}{
+		{"no history", nil, 6, ""},
+		{"only the persona", conversation[:1], 6, ""},
+		{"user and assistant messages only", conversation, 6,
+			"user: What is Compose?\nassistant: A tool to run multi-container applications.\nuser: And with profiles?"},
+		{"last messages", conversation, 2, "assistant: A tool to run multi-container applications.\nuser: And with profiles?"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := GetRecentHistory(test.messages, test.maxMessages); got != test.want {
+				t.Errorf("GetRecentHistory() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	tests := []struct {
+		name       string
+		rewrite    string
+		maxQueries int
+		want       []string
+	}{
+		{"single query", "How to use profiles with Docker Compose?", 1, []string{"How to use profiles with Docker Compose?"}},
+		{"dashes and stars", "- compose profiles\n* compose watch", 3, []string{"compose profiles", "compose watch"}},
+		{"numbered lists", "1. compose profiles\n  2) compose watch\n", 3, []string{"compose profiles", "compose watch"}},
+		{"numbers of the query are kept", "3.5 release notes\n2024 roadmap\n-1 exit code", 3, []string{"3.5 release notes", "2024 roadmap", "-1 exit code"}},
+		{"empty lines are skipped", "\n\n- compose profiles\n \n", 3, []string{"compose profiles"}},
+		{"at most max queries", "- first\n- second\n- third", 2, []string{"first", "second"}},
+		{"nothing", "  \n", 2, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ParseQueries(test.rewrite, test.maxQueries); !slices.Equal(got, test.want) {
+				t.Errorf("ParseQueries() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
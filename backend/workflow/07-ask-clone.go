@@ -0,0 +1,158 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/approval"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/metrics"
+	"we-are-legion/rag"
+	"we-are-legion/usage"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// SearchDocuments searches the RAG memory of a clone with its retrieval parameters (sorted by score).
+// The searches are counted as hits or misses (see the rag_* metrics), the embedding of the query is added to the token usage of the clone.
+func SearchDocuments(ctx context.Context, clone *agents.AgentConfig, query string, retrieval config.RetrievalConfig) ([]rag.Similarity, error) {
+	topK := retrieval.TopK
+	if topK <= 0 {
+		topK = len(clone.Agent.Store.Records)
+	}
+	similarities, err := rag.SearchTopNSimilarities(usage.WithAgent(ctx, clone.Name), clone.Agent, query, retrieval.SimilarityThreshold, topK)
+	if err == nil {
+		cloneName := strings.ToLower(clone.Name)
+		if len(similarities) > 0 {
+			metrics.RAGSearches.WithLabelValues(cloneName, "hit").Inc()
+		} else {
+			metrics.RAGSearches.WithLabelValues(cloneName, "miss").Inc()
+		}
+		metrics.RAGDocuments.WithLabelValues(cloneName).Add(float64(len(similarities)))
+	}
+	return similarities, err
+}
+
+// AskClone asks a one-off question to a clone, with the documents of its RAG memory.
+// The clone answers with its persona, but the question and the answer are not added to its conversational memory:
+// the completion is done with a copy of the agent.
+func AskClone(ctx context.Context, clone *agents.AgentConfig, question string, retrieval config.RetrievalConfig) (string, []rag.Similarity, error) {
+	if clone == nil {
+		return "", nil, errors.New("unknown clone")
+	}
+	cloneAgent, similarities := newAskCloneAgent(ctx, clone, question, retrieval)
+	cloneAgent.Params.Messages = append(cloneAgent.Params.Messages, openai.UserMessage(question))
+	answer, err := chatCompletion(ctx, "ask_clone.chat_completion", clone.Name, cloneAgent)
+	return answer, similarities, err
+}
+
+// newAskCloneAgent returns a copy of the agent of the clone, with its persona and the documents of its RAG memory found for the question.
+// The question is not added: the caller adds it (after the results of the agent loop of the clone, see AskCloneTool).
+func newAskCloneAgent(ctx context.Context, clone *agents.AgentConfig, question string, retrieval config.RetrievalConfig) (*robby.Agent, []rag.Similarity) {
+	similarities, err := SearchDocuments(ctx, clone, question, retrieval)
+	if err != nil {
+		slog.ErrorContext(ctx, "😡 similarity search failed", "clone", clone.Name, "error", err)
+		// NOTE: do nothing, answer without the documents
+	}
+	contents := rag.MergeChunks(rag.ChunksOf(similarities), rag.DuplicateThreshold)
+	if retrieval.MaxContextTokens > 0 {
+		contents = rag.LimitTokens(contents, retrieval.MaxContextTokens)
+	}
+
+	// NOTE: keep only the persona of the clone (the snapshot of the initialization, see initializeAgent),
+	// the messages of the shared agent are not read (they can be changed by a conversation)
+	messages := slices.Clone(clone.Persona)
+	if len(contents) > 0 {
+		messages = append(messages,
+			openai.SystemMessage("Here are some relevant documents found in the RAG memory:\n"+strings.Join(contents, "\n")),
+			openai.SystemMessage("Use the above documents to answer the user question: "),
+		)
+	}
+
+	cloneAgent := *clone.Agent
+	cloneAgent.Params.Messages = messages
+	cloneAgent.Params.Tools = nil
+	cloneAgent.Tools = nil
+	return &cloneAgent, similarities
+}
+
+// AskCloneTool returns the ask_clone tool of the agent loop: the caller sends a sub-question to another clone
+// (answered with the RAG memory of this clone), the delegation is displayed in the stream.
+// The chain contains the names of the clones already in the delegation (they can not be asked again).
+// Below the max depth, the asked clone runs its own agent loop with the ask_clone tool.
+func AskCloneTool(response http.ResponseWriter, flusher http.Flusher, clones map[string]*agents.AgentConfig, chain []string, approvals *approval.Request, maxDepth int) AgentTool {
+	caller := chain[len(chain)-1]
+	if callerClone, ok := clones[caller]; ok {
+		caller = callerClone.Name
+	}
+	cloneNames := []string{}
+	for cloneName := range clones {
+		if !slices.Contains(chain, cloneName) {
+			cloneNames = append(cloneNames, cloneName)
+		}
+	}
+	sort.Strings(cloneNames)
+
+	return AgentTool{
+		Definition: openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        "ask_clone",
+				Description: openai.String("ask a question to another clone of Bob: bob (Docker), bill (Docker Compose), garfield (Docker Model Runner), milo (Docker Bake)"),
+				Parameters: openai.FunctionParameters{
+					"type": "object",
+					"properties": map[string]any{
+						"clone_name": map[string]any{
+							"type":        "string",
+							"enum":        cloneNames,
+							"description": "The name of the clone to ask.",
+						},
+						"question": map[string]string{
+							"type":        "string",
+							"description": "The question to ask to the clone.",
+						},
+					},
+					"required": []string{"clone_name", "question"},
+				},
+			},
+		},
+		Server: "clones",
+		Execute: func(ctx context.Context, arguments map[string]any) (string, error) {
+			cloneName, _ := arguments["clone_name"].(string)
+			question, _ := arguments["question"].(string)
+			cloneName = strings.ToLower(cloneName)
+			clone, ok := clones[cloneName]
+			if !ok || slices.Contains(chain, cloneName) {
+				return "", fmt.Errorf("%s can not be asked (available clones: %s)", cloneName, strings.Join(cloneNames, ", "))
+			}
+
+			depth := len(chain)
+			slog.InfoContext(ctx, "🗣️ clone delegation", "caller", caller, "clone", clone.Name, "depth", depth, "question", question)
+			helpers.ResponseLabel(response, flusher, "question", fmt.Sprintf("%s asks %s: %s", caller, clone.Name, question))
+
+			cloneAgent, _ := newAskCloneAgent(ctx, clone, question, clone.Retrieval)
+			if depth < maxDepth && len(chain)+1 < len(clones) {
+				// NOTE: the asked clone can ask another clone (not one of the chain)
+				subChain := append(slices.Clone(chain), cloneName)
+				subClone := &agents.AgentConfig{Name: clone.Name, Agent: cloneAgent, Retrieval: clone.Retrieval}
+				RunAgentLoop(ctx, response, flusher, subClone, question,
+					[]AgentTool{AskCloneTool(response, flusher, clones, subChain, approvals, maxDepth)},
+					approvals, config.Get().AgentLoop.MaxSteps)
+			}
+			cloneAgent.Params.Messages = append(cloneAgent.Params.Messages, openai.UserMessage(question))
+			answer, err := chatCompletion(ctx, "ask_clone.chat_completion", clone.Name, cloneAgent)
+			if err != nil {
+				return "", fmt.Errorf("%s failed to answer: %w", clone.Name, err)
+			}
+			helpers.ResponseLabel(response, flusher, "white", fmt.Sprintf("%s answered %s (~%d tokens)", clone.Name, caller, rag.EstimateTokens(answer)))
+			return answer, nil
+		},
+	}
+}
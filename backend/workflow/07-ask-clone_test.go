@@ -0,0 +1,117 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"we-are-legion/agents"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// newClones returns the clones of the ask_clone tool, answering with the fake model.
+// NOTE: the model runner does not return embeddings, the clones answer without the documents of their RAG memory
+func newClones(t *testing.T, url string, names ...string) map[string]*agents.AgentConfig {
+	embeddings := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(embeddings.Close)
+	t.Setenv("DMR_BASE_URL", embeddings.URL)
+
+	clones := map[string]*agents.AgentConfig{}
+	for _, name := range names {
+		agent, err := robby.NewAgent(robby.WithDMRClient(context.Background(), url), robby.WithParams(openai.ChatCompletionNewParams{Model: "fake"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cloneName := strings.ToUpper(name[:1]) + name[1:]
+		clones[name] = &agents.AgentConfig{
+			Name:    cloneName,
+			Agent:   agent,
+			Persona: []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are " + cloneName)},
+		}
+	}
+	return clones
+}
+
+func TestAskCloneTool(t *testing.T) {
+	tests := []struct {
+		name         string
+		chain        []string
+		maxDepth     int
+		cloneName    string
+		wantError    string
+		wantRequests int
+	}{
+		{name: "the clone is in the chain", chain: []string{"bob", "bill"}, maxDepth: 3, cloneName: "bill", wantError: "bill can not be asked (available clones: garfield, milo)"},
+		{name: "the caller asks itself", chain: []string{"bob"}, maxDepth: 3, cloneName: "Bob", wantError: "bob can not be asked"},
+		{name: "unknown clone", chain: []string{"bob"}, maxDepth: 3, cloneName: "riker", wantError: "riker can not be asked"},
+		// NOTE: the agent loop of the asked clone (one completion without tool call) and its answer
+		{name: "below the max depth", chain: []string{"bob"}, maxDepth: 2, cloneName: "Bill", wantRequests: 2},
+		{name: "below the max depth with a chain", chain: []string{"bob", "garfield"}, maxDepth: 3, cloneName: "bill", wantRequests: 2},
+		// NOTE: only the answer of the asked clone
+		{name: "at the max depth", chain: []string{"bob"}, maxDepth: 1, cloneName: "bill", wantRequests: 1},
+		{name: "at the max depth with a chain", chain: []string{"bob", "garfield"}, maxDepth: 2, cloneName: "bill", wantRequests: 1},
+		{name: "no other clone to ask", chain: []string{"bob", "garfield", "milo"}, maxDepth: 5, cloneName: "bill", wantRequests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, requests := newToolCallsModel(t, 0)
+			clones := newClones(t, url, "bob", "bill", "garfield", "milo")
+
+			recorder := httptest.NewRecorder()
+			tool := AskCloneTool(recorder, recorder, clones, test.chain, nil, test.maxDepth)
+			_, err := tool.Execute(context.Background(), map[string]any{"clone_name": test.cloneName, "question": "the sub-question"})
+			if test.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantError) {
+					t.Fatalf("Execute() error = %v, want %q", err, test.wantError)
+				}
+				if len(requests()) != 0 {
+					t.Errorf("the model received %d requests, want none", len(requests()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if got := len(requests()); got != test.wantRequests {
+				t.Errorf("the model received %d requests, want %d", got, test.wantRequests)
+			}
+
+			caller := clones[test.chain[len(test.chain)-1]].Name
+			if body := recorder.Body.String(); !strings.Contains(body, caller+" asks Bill: the sub-question") || !strings.Contains(body, "Bill answered "+caller) {
+				t.Errorf("the delegation is not displayed in the stream:\n%s", body)
+			}
+			// NOTE: the asked clone answers with its persona, not with the messages of the caller
+			for _, messages := range requests() {
+				if len(messages) == 0 || messages[0]["content"] != "You are Bill" {
+					t.Errorf("the asked clone does not answer with its persona: %v", messages)
+				}
+			}
+		})
+	}
+}
+
+func TestAskCloneToolDefinition(t *testing.T) {
+	url, _ := newToolCallsModel(t, 0)
+	clones := newClones(t, url, "bob", "bill", "garfield", "milo")
+
+	tests := []struct {
+		chain []string
+		want  []string
+	}{
+		{[]string{"bob"}, []string{"bill", "garfield", "milo"}},
+		{[]string{"bob", "garfield"}, []string{"bill", "milo"}},
+		{[]string{"bill", "milo", "bob"}, []string{"garfield"}},
+	}
+	for _, test := range tests {
+		tool := AskCloneTool(nil, nil, clones, test.chain, nil, 3)
+		properties := tool.Definition.Function.Parameters["properties"].(map[string]any)
+		cloneNames := properties["clone_name"].(map[string]any)["enum"].([]string)
+		if !slices.Equal(cloneNames, test.want) {
+			t.Errorf("AskCloneTool(%v) enum = %v, want %v", test.chain, cloneNames, test.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"we-are-legion/approval"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+
+	"github.com/openai/openai-go"
+)
+
+// ApproveToolCall applies the approval policy of a tool call of a server (see the approval section of config.yml).
+// With the "ask" policy, the pending tool call and its arguments are sent in the stream,
+// and the request waits for POST /chat/{requestId}/approve or /reject (the tool call is rejected after the timeout).
+func ApproveToolCall(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, approvals *approval.Request, serverName string, toolCall openai.ChatCompletionMessageToolCall) bool {
+	toolName := toolCall.Function.Name
+	policy := config.GetToolPolicy(serverName, toolName)
+	switch policy {
+	case config.PolicyAuto:
+		return true
+	case config.PolicyDeny:
+		slog.WarnContext(ctx, "⛔️ tool call denied by the policy", "server", serverName, "tool", toolName)
+		helpers.ResponseLabel(response, flusher, "error", "Tool call denied: "+toolName)
+		return false
+	}
+
+	// NOTE: "ask" policy (the policies are validated when the configuration is loaded)
+	if approvals == nil {
+		helpers.ResponseLabel(response, flusher, "error", "Tool call needs an approval: "+toolName)
+		return false
+	}
+	toolCallID := toolCall.ID
+	if toolCallID == "" {
+		toolCallID = toolName
+	}
+	approvals.Add(toolCallID)
+	slog.InfoContext(ctx, "✋ waiting for the approval of the tool call", "server", serverName, "tool", toolName, "arguments", toolCall.Function.Arguments)
+	helpers.ResponseLabel(response, flusher, "warning", "Approval required: "+toolName+" "+toolCall.Function.Arguments)
+	helpers.ResponseLabelNewLine(response, flusher, "gray",
+		fmt.Sprintf("POST /chat/%s/approve or /chat/%s/reject (tool_call_id: %s)", approvals.ID, approvals.ID, toolCallID))
+
+	approved, err := approvals.Wait(toolCallID, config.Get().Approval.Timeout)
+	if err != nil {
+		slog.WarnContext(ctx, "😡 tool call not approved", "tool", toolName, "error", err)
+		helpers.ResponseLabel(response, flusher, "error", "Tool call rejected ("+err.Error()+"): "+toolName)
+		return false
+	}
+	if !approved {
+		helpers.ResponseLabel(response, flusher, "error", "Tool call rejected: "+toolName)
+		return false
+	}
+	helpers.ResponseLabel(response, flusher, "success", "Tool call approved: "+toolName)
+	return true
+}
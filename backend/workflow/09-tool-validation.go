@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"we-are-legion/helpers"
+	"we-are-legion/usage"
+
+	"github.com/google/jsonschema-go/jsonschema"
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// ValidateToolCall checks that a tool call uses a tool of the list,
+// and that its arguments are valid JSON matching the parameters schema of the tool.
+func ValidateToolCall(tools []openai.ChatCompletionToolParam, toolCall openai.ChatCompletionMessageToolCall) error {
+	var tool *openai.ChatCompletionToolParam
+	for index := range tools {
+		if tools[index].Function.Name == toolCall.Function.Name {
+			tool = &tools[index]
+			break
+		}
+	}
+	if tool == nil {
+		return fmt.Errorf("unknown tool: %s", toolCall.Function.Name)
+	}
+
+	arguments := toolCall.Function.Arguments
+	if strings.TrimSpace(arguments) == "" {
+		arguments = "{}"
+	}
+	var instance any
+	if err := json.Unmarshal([]byte(arguments), &instance); err != nil {
+		return fmt.Errorf("the arguments are not valid JSON: %w", err)
+	}
+
+	data, err := json.Marshal(tool.Function.Parameters)
+	if err != nil {
+		return nil
+	}
+	var schema jsonschema.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		slog.Warn("😡 invalid schema of the tool", "tool", toolCall.Function.Name, "error", err)
+		return nil // NOTE: the schema can not be used, do not block the tool call
+	}
+	resolved, err := schema.Resolve(nil)
+	if err != nil {
+		slog.Warn("😡 invalid schema of the tool", "tool", toolCall.Function.Name, "error", err)
+		return nil
+	}
+	return resolved.Validate(instance)
+}
+
+// ValidateToolCalls validates the tool calls of a tool agent against the schemas of its tools.
+// The invalid tool calls are reported in the stream as tool errors and removed from the tool calls of the agent.
+// With retries, the validation errors are fed back to the model to detect the invalid tool calls again.
+func ValidateToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, agent *robby.Agent, retries int) []openai.ChatCompletionMessageToolCall {
+	validToolCalls, invalidTools, validationErrors := splitToolCalls(ctx, response, flusher, agent.Tools, agent.ToolCalls)
+
+	for retry := 1; retry <= retries && len(invalidTools) > 0; retry++ {
+		helpers.ResponseLabel(response, flusher, "info", fmt.Sprintf("Retrying the invalid tool calls (%d/%d)...", retry, retries))
+		agent.Params.Messages = append(agent.Params.Messages,
+			openai.SystemMessage("These tool calls are invalid:\n"+strings.Join(validationErrors, "\n")+
+				"\nCall the tools again with arguments matching their schemas."),
+		)
+		toolCalls, err := toolsCompletion(ctx, "tools_completion.retry", usage.AgentOf(ctx), agent)
+		if err != nil {
+			slog.WarnContext(ctx, "😡 no tool calls detected on retry", "error", err)
+			break
+		}
+		// NOTE: keep only the new tool calls of the tools which were invalid
+		retriedToolCalls := []openai.ChatCompletionMessageToolCall{}
+		for _, toolCall := range toolCalls {
+			if invalidTools[toolCall.Function.Name] {
+				retriedToolCalls = append(retriedToolCalls, toolCall)
+			}
+		}
+		var retriedValidToolCalls []openai.ChatCompletionMessageToolCall
+		retriedValidToolCalls, invalidTools, validationErrors = splitToolCalls(ctx, response, flusher, agent.Tools, retriedToolCalls)
+		validToolCalls = append(validToolCalls, retriedValidToolCalls...)
+	}
+
+	agent.ToolCalls = validToolCalls
+	return validToolCalls
+}
+
+// splitToolCalls returns the valid tool calls, the names of the tools of the invalid tool calls and the validation errors.
+func splitToolCalls(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, tools []openai.ChatCompletionToolParam, toolCalls []openai.ChatCompletionMessageToolCall) ([]openai.ChatCompletionMessageToolCall, map[string]bool, []string) {
+	validToolCalls := []openai.ChatCompletionMessageToolCall{}
+	invalidTools := map[string]bool{}
+	validationErrors := []string{}
+	for _, toolCall := range toolCalls {
+		if err := ValidateToolCall(tools, toolCall); err != nil {
+			slog.WarnContext(ctx, "😡 invalid tool call", "tool", toolCall.Function.Name, "arguments", toolCall.Function.Arguments, "error", err)
+			helpers.ResponseLabel(response, flusher, "error", "Invalid tool call "+toolCall.Function.Name+": "+err.Error())
+			invalidTools[toolCall.Function.Name] = true
+			validationErrors = append(validationErrors, fmt.Sprintf("- %s(%s): %v", toolCall.Function.Name, toolCall.Function.Arguments, err))
+			continue
+		}
+		validToolCalls = append(validToolCalls, toolCall)
+	}
+	return validToolCalls, invalidTools, validationErrors
+}
@@ -0,0 +1,109 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+func echoToolCall(id string, arguments string) openai.ChatCompletionMessageToolCall {
+	return openai.ChatCompletionMessageToolCall{
+		ID:       id,
+		Function: openai.ChatCompletionMessageToolCallFunction{Name: "echo", Arguments: arguments},
+	}
+}
+
+func TestValidateToolCall(t *testing.T) {
+	tools := []openai.ChatCompletionToolParam{echoAgentTool().Definition}
+	tests := []struct {
+		name      string
+		toolCall  openai.ChatCompletionMessageToolCall
+		wantError string
+	}{
+		{"valid arguments", echoToolCall("1", `{"text": "hello"}`), ""},
+		{"malformed JSON", echoToolCall("1", `{"text": "hello"`), "not valid JSON"},
+		{"missing required field", echoToolCall("1", `{}`), "text"},
+		{"no arguments", echoToolCall("1", ""), "text"},
+		{"wrong type", echoToolCall("1", `{"text": 42}`), "type"},
+		{"unknown tool", openai.ChatCompletionMessageToolCall{Function: openai.ChatCompletionMessageToolCallFunction{Name: "crash"}}, "unknown tool"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateToolCall(tools, test.toolCall)
+			switch {
+			case test.wantError == "" && err != nil:
+				t.Errorf("ValidateToolCall() error = %v, want no error", err)
+			case test.wantError != "" && (err == nil || !strings.Contains(err.Error(), test.wantError)):
+				t.Errorf("ValidateToolCall() error = %v, want an error containing %q", err, test.wantError)
+			}
+		})
+	}
+}
+
+func TestValidateToolCalls(t *testing.T) {
+	tests := []struct {
+		name          string
+		toolCalls     []openai.ChatCompletionMessageToolCall
+		retries       int
+		modelCalls    int // number of tool calls detected by the fake model (see newToolCallsModel)
+		wantArguments []string
+		wantRequests  int
+		invalid       bool
+	}{
+		{"valid tool calls", []openai.ChatCompletionMessageToolCall{echoToolCall("1", `{"text": "hello"}`)}, 1, 1,
+			[]string{`{"text": "hello"}`}, 0, false},
+		{"invalid tool call without retry", []openai.ChatCompletionMessageToolCall{echoToolCall("1", `{"text": 42}`)}, 0, 1,
+			[]string{}, 0, true},
+		{"the retry fixes the invalid tool call", []openai.ChatCompletionMessageToolCall{echoToolCall("1", `{"text": "hello"}`), echoToolCall("2", `{"text": 42}`)}, 1, 1,
+			[]string{`{"text": "hello"}`, `{"text": "result 1"}`}, 1, true},
+		{"no tool calls on retry", []openai.ChatCompletionMessageToolCall{echoToolCall("1", `{}`)}, 2, 0,
+			[]string{}, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, requests := newToolCallsModel(t, test.modelCalls)
+			agent, err := robby.NewAgent(robby.WithDMRClient(context.Background(), url), robby.WithParams(openai.ChatCompletionNewParams{
+				Model:    "fake",
+				Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage("say hello")},
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			agent.Tools = []openai.ChatCompletionToolParam{echoAgentTool().Definition}
+			agent.ToolCalls = test.toolCalls
+
+			recorder := httptest.NewRecorder()
+			validToolCalls := ValidateToolCalls(context.Background(), recorder, recorder, agent, test.retries)
+			arguments := []string{}
+			for _, toolCall := range validToolCalls {
+				arguments = append(arguments, toolCall.Function.Arguments)
+			}
+			if !slices.Equal(arguments, test.wantArguments) {
+				t.Errorf("valid tool calls = %q, want %q", arguments, test.wantArguments)
+			}
+			if len(agent.ToolCalls) != len(validToolCalls) {
+				t.Errorf("the agent has %d tool calls, want only the %d valid ones", len(agent.ToolCalls), len(validToolCalls))
+			}
+
+			// NOTE: the validation errors are fed back to the model
+			if len(requests()) != test.wantRequests {
+				t.Fatalf("%d requests to the model, want %d", len(requests()), test.wantRequests)
+			}
+			for _, messages := range requests() {
+				feedback := fmt.Sprint(messages[len(messages)-1]["content"])
+				if !strings.Contains(feedback, "These tool calls are invalid") || !strings.Contains(feedback, "- echo(") {
+					t.Errorf("the validation errors are not fed back to the model: %q", feedback)
+				}
+			}
+			if reported := strings.Contains(recorder.Body.String(), "Invalid tool call echo"); reported != test.invalid {
+				t.Errorf("invalid tool call reported in the stream = %v, want %v:\n%s", reported, test.invalid, recorder.Body.String())
+			}
+		})
+	}
+}
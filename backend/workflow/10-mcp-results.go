@@ -0,0 +1,142 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/mcphub"
+	"we-are-legion/rag"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+)
+
+// ProcessMCPResults turns the results of the MCP tool calls into context items for the prompt (instead of the raw JSON):
+//   - the search results are extracted (title, URL, snippet), deduplicated by URL and numbered for the citations,
+//   - the other results are kept as text,
+//   - the items are capped to the token budget, and optionally summarized by the tools model (in a single item).
+//
+// It returns the context items (see AssembleContext) and the search results used as citations.
+func ProcessMCPResults(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, summarizer *robby.Agent, userQuestion string, mcpResults []string, settings config.MCPResultsConfig) ([]ContextItem, []mcphub.WebResult) {
+	citations := []mcphub.WebResult{}
+	others := []string{}
+	seen := map[string]bool{}
+	for _, mcpResult := range mcpResults {
+		webResults := mcphub.ParseWebResults(mcpResult)
+		if webResults == nil {
+			others = append(others, strings.TrimSpace(mcpResult))
+			continue
+		}
+		for _, webResult := range webResults {
+			if seen[webResult.URL] || (settings.MaxResults > 0 && len(citations) >= settings.MaxResults) {
+				continue
+			}
+			seen[webResult.URL] = true
+			citations = append(citations, webResult)
+		}
+	}
+
+	items := []ContextItem{}
+	for index, citation := range citations {
+		items = append(items, ContextItem{
+			Provenance: ProvenanceWeb,
+			Source:     citation.URL,
+			Label:      fmt.Sprintf("web %d: %s", index+1, citation.Title),
+			Content:    "URL: " + citation.URL + "\n" + citation.Snippet,
+			Rank:       index,
+		})
+	}
+	for _, other := range others {
+		// NOTE: a result bigger than the budget is truncated (ex: the content of a file)
+		other = TruncateToTokens(other, settings.MaxTokens)
+		items = append(items, ContextItem{
+			Provenance: ProvenanceWeb,
+			Label:      "web: tool result",
+			Content:    other,
+			Rank:       len(items),
+		})
+	}
+	items = LimitContextItems(items, settings.MaxTokens)
+	if len(items) < len(citations) {
+		citations = citations[:len(items)]
+	}
+
+	// NOTE: without summarizer (Spock is unavailable, see Catalog) the results are not summarized
+	if settings.Summarize && summarizer != nil && len(items) > 0 {
+		helpers.ResponseLabel(response, flusher, "step", "Summarizing the MCP results...")
+		blocks := []string{}
+		for _, item := range items {
+			blocks = append(blocks, "["+item.Label+"]\n"+item.Content)
+		}
+		summarizer.Params.Messages = []openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(`
+			Summarize the following results to answer the question of the user.
+			Keep only the relevant facts, and keep the numbers of the sources, ex: [1].
+			`),
+			openai.UserMessage("Question:\n" + userQuestion + "\n\nResults:\n" + strings.Join(blocks, "\n\n")),
+		}
+		summary, err := chatCompletion(ctx, "mcp.summarize_results", "Spock", summarizer)
+		if err != nil {
+			slog.ErrorContext(ctx, "😡 MCP results summary failed", "error", err)
+			helpers.ResponseLabel(response, flusher, "error", "Summary failed: "+err.Error())
+		} else if strings.TrimSpace(summary) != "" {
+			// NOTE: keep the URLs of the sources with the summary (see KeepCitations)
+			sources := []string{}
+			for index, citation := range citations {
+				sources = append(sources, fmt.Sprintf("[%d] %s", index+1, citation.URL))
+			}
+			items = []ContextItem{{Provenance: ProvenanceWeb, Label: "web: summary", Content: summary + "\n\nSources:\n" + strings.Join(sources, "\n")}}
+		}
+	}
+
+	tokens := 0
+	for _, item := range items {
+		tokens += rag.EstimateTokens(item.Content)
+	}
+	slog.InfoContext(ctx, "🌐 MCP results", "sources", len(citations), "tokens", tokens)
+	helpers.ResponseLabel(response, flusher, "info", fmt.Sprintf("%d web sources, ~%d tokens", len(citations), tokens))
+	return items, citations
+}
+
+// TruncateToTokens truncates a text bigger than the budget of tokens (0: no limit).
+func TruncateToTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 || rag.EstimateTokens(text) <= maxTokens {
+		return text
+	}
+	return strings.ToValidUTF8(text[:max(maxTokens*4-8, 0)], "") + "\n[...]"
+}
+
+// KeepCitations returns the citations with an URL in the kept context items (see AssembleContext).
+// The web results keep their order in the context, the numbers of the citations do not change.
+func KeepCitations(citations []mcphub.WebResult, items []ContextItem) []mcphub.WebResult {
+	kept := []mcphub.WebResult{}
+	for _, citation := range citations {
+		for _, item := range items {
+			if item.Provenance == ProvenanceWeb && strings.Contains(item.Content, citation.URL) {
+				kept = append(kept, citation)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// FormatCitations returns the Markdown list of the sources, added after the answer.
+func FormatCitations(citations []mcphub.WebResult) string {
+	if len(citations) == 0 {
+		return ""
+	}
+	lines := []string{"\n\n**Sources:**"}
+	for index, citation := range citations {
+		title := citation.Title
+		if title == "" {
+			title = citation.URL
+		}
+		lines = append(lines, fmt.Sprintf("%d. [%s](%s)", index+1, title, citation.URL))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,35 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateToTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		want      string
+	}{
+		{"no limit", strings.Repeat("a", 100), 0, strings.Repeat("a", 100)},
+		{"under the budget", strings.Repeat("a", 40), 10, strings.Repeat("a", 40)},
+		{"truncated", strings.Repeat("a", 100), 10, strings.Repeat("a", 32) + "\n[...]"},
+		// NOTE: the cut at 32 bytes is in the middle of the 11th "é" (2 bytes), the partial rune is removed
+		{"multi-byte boundary", "x" + strings.Repeat("é", 50), 10, "x" + strings.Repeat("é", 15) + "\n[...]"},
+		{"emoji boundary", strings.Repeat("🐳", 20), 5, strings.Repeat("🐳", 3) + "\n[...]"},
+		{"tiny budget", strings.Repeat("a", 100), 1, "\n[...]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := TruncateToTokens(test.text, test.maxTokens)
+			if got != test.want {
+				t.Errorf("TruncateToTokens() = %q, want %q", got, test.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("TruncateToTokens() = %q is not valid UTF-8", got)
+			}
+		})
+	}
+}
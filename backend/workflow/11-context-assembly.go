@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/rag"
+
+	"github.com/openai/openai-go"
+)
+
+// Provenances of the context items.
+const (
+	ProvenanceDocs = "docs" // a document of the RAG memory of the clone
+	ProvenanceWeb  = "web"  // a result of a MCP tool (ex: a web search result)
+)
+
+// ContextItem is a piece of context of the prompt, with its provenance and its rank in its own list
+// (the RAG documents are sorted by similarity, the web results keep the order of the search engine).
+type ContextItem struct {
+	Provenance string
+	Source     string // the path of the document, or the URL of the web result
+	Label      string // ex: "docs: compose/profiles.md" or "web 1: Docker Compose"
+	Content    string
+	Rank       int
+	Score      float64 // the score of the reciprocal rank fusion (see AssembleContext)
+}
+
+// AssembleContext ranks the RAG documents and the web results together with a reciprocal rank fusion
+// (score = weight / (60 + rank), the weight of each provenance is in config.yml),
+// and keeps the first items fitting in the budget of tokens.
+func AssembleContext(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, docs []ContextItem, web []ContextItem, settings config.ContextConfig) []ContextItem {
+	items := []ContextItem{}
+	for _, item := range docs {
+		item.Score = settings.DocsWeight / float64(60+item.Rank)
+		items = append(items, item)
+	}
+	for _, item := range web {
+		item.Score = settings.WebWeight / float64(60+item.Rank)
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Score > items[j].Score
+	})
+
+	items = LimitContextItems(items, settings.MaxTokens)
+
+	counts := map[string]int{}
+	tokens := 0
+	for _, item := range items {
+		counts[item.Provenance]++
+		tokens += rag.EstimateTokens(item.Content)
+	}
+	slog.InfoContext(ctx, "🧩 context assembled", "documents", counts[ProvenanceDocs], "web_results", counts[ProvenanceWeb], "tokens", tokens)
+	if len(items) > 0 {
+		helpers.ResponseLabel(response, flusher, "white",
+			fmt.Sprintf("Context: %d documents + %d web results, ~%d tokens", counts[ProvenanceDocs], counts[ProvenanceWeb], tokens))
+	}
+	return items
+}
+
+// LimitContextItems keeps the first context items fitting in the budget of tokens (0: no limit).
+func LimitContextItems(items []ContextItem, maxTokens int) []ContextItem {
+	if maxTokens <= 0 {
+		return items
+	}
+	kept := []ContextItem{}
+	tokens := 0
+	for _, item := range items {
+		tokens += rag.EstimateTokens(item.Content)
+		if tokens > maxTokens {
+			break
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// AddContextToMessages adds the context items (with their provenance) and the user question to the messages of the selected agent.
+func AddContextToMessages(selectedAgent *agents.AgentConfig, items []ContextItem, userQuestion string) {
+	if len(items) == 0 {
+		// NOTE: conversational memory, add the question to the Agent's message
+		selectedAgent.Agent.Params.Messages = append(
+			selectedAgent.Agent.Params.Messages, openai.UserMessage(userQuestion),
+		)
+		return
+	}
+
+	blocks := []string{}
+	for _, item := range items {
+		blocks = append(blocks, "["+item.Label+"]\n"+item.Content)
+	}
+	introduction, instruction := contextInstructions(items)
+
+	// NOTE: conversational memory, add the context to the Agent's message
+	selectedAgent.Agent.Params.Messages = append(
+		selectedAgent.Agent.Params.Messages,
+		openai.SystemMessage(introduction+":\n"+strings.Join(blocks, "\n\n")),
+		openai.SystemMessage(instruction),
+		openai.UserMessage(userQuestion),
+	)
+}
+
+// contextInstructions returns the introduction of the context items and the instruction to answer,
+// they only mention the provenances of the items (the RAG documents, the web results or both).
+func contextInstructions(items []ContextItem) (string, string) {
+	hasDocs, hasWebResults := false, false
+	for _, item := range items {
+		hasDocs = hasDocs || item.Provenance == ProvenanceDocs
+		hasWebResults = hasWebResults || item.Provenance == ProvenanceWeb
+	}
+	switch {
+	case hasDocs && hasWebResults:
+		return "Here are some relevant documents found in the RAG memory (docs) and on the web (web)",
+			"Use the above documents to answer the user question (prefer the curated docs, and use the web results for the recent information), cite the web sources with their number, ex: [1]: "
+	case hasWebResults:
+		return "Here are some relevant results found on the web (web)",
+			"Use the above web results to answer the user question, cite the web sources with their number, ex: [1]: "
+	default:
+		return "Here are some relevant documents found in the RAG memory (docs)",
+			"Use the above documents to answer the user question: "
+	}
+}
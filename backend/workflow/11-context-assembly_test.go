@@ -0,0 +1,94 @@
+package workflow
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"we-are-legion/config"
+)
+
+func contextItems(provenance string, contents ...string) []ContextItem {
+	items := []ContextItem{}
+	for rank, content := range contents {
+		items = append(items, ContextItem{Provenance: provenance, Label: provenance + " " + content, Content: content, Rank: rank + 1})
+	}
+	return items
+}
+
+func TestAssembleContext(t *testing.T) {
+	docs := contextItems(ProvenanceDocs, "d1", "d2", "d3")
+	web := contextItems(ProvenanceWeb, "w1", "w2")
+	tests := []struct {
+		name     string
+		settings config.ContextConfig
+		want     []string
+	}{
+		{"same weights: the ranks are interleaved", config.ContextConfig{DocsWeight: 1, WebWeight: 1}, []string{"d1", "w1", "d2", "w2", "d3"}},
+		{"docs first", config.ContextConfig{DocsWeight: 2, WebWeight: 1}, []string{"d1", "d2", "d3", "w1", "w2"}},
+		{"web first", config.ContextConfig{DocsWeight: 1, WebWeight: 2}, []string{"w1", "w2", "d1", "d2", "d3"}},
+		{"no docs", config.ContextConfig{DocsWeight: 0, WebWeight: 1}, []string{"w1", "w2", "d1", "d2", "d3"}},
+		{"budget of tokens", config.ContextConfig{MaxTokens: 2, DocsWeight: 1, WebWeight: 1}, []string{"d1", "w1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			items := AssembleContext(context.Background(), recorder, recorder, docs, web, test.settings)
+			got := []string{}
+			for _, item := range items {
+				got = append(got, item.Content)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("AssembleContext() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLimitContextItems(t *testing.T) {
+	items := contextItems(ProvenanceDocs, "12345678", "1234", "12345678")
+	tests := []struct {
+		maxTokens int
+		want      int
+	}{
+		{0, 3},
+		{1, 0},
+		{2, 1},
+		{3, 2},
+		{5, 3},
+	}
+	for _, test := range tests {
+		if got := LimitContextItems(items, test.maxTokens); len(got) != test.want {
+			t.Errorf("LimitContextItems(%d) keeps %d items, want %d", test.maxTokens, len(got), test.want)
+		}
+	}
+}
+
+func TestContextInstructions(t *testing.T) {
+	tests := []struct {
+		name         string
+		items        []ContextItem
+		mention      []string
+		notMentioned []string
+	}{
+		{"docs only", contextItems(ProvenanceDocs, "d1"), []string{"RAG memory (docs)"}, []string{"web"}},
+		{"web only", contextItems(ProvenanceWeb, "w1"), []string{"on the web (web)", "cite the web sources"}, []string{"RAG memory", "docs"}},
+		{"docs and web", append(contextItems(ProvenanceDocs, "d1"), contextItems(ProvenanceWeb, "w1")...),
+			[]string{"RAG memory (docs) and on the web (web)", "prefer the curated docs"}, nil},
+	}
+	for _, test := range tests {
+		introduction, instruction := contextInstructions(test.items)
+		text := introduction + "\n" + instruction
+		for _, expected := range test.mention {
+			if !strings.Contains(text, expected) {
+				t.Errorf("%s: %q does not mention %q", test.name, text, expected)
+			}
+		}
+		for _, unexpected := range test.notMentioned {
+			if strings.Contains(text, unexpected) {
+				t.Errorf("%s: %q mentions %q", test.name, text, unexpected)
+			}
+		}
+	}
+}
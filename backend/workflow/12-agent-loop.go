@@ -0,0 +1,200 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"we-are-legion/agents"
+	"we-are-legion/approval"
+	"we-are-legion/config"
+	"we-are-legion/helpers"
+	"we-are-legion/mcphub"
+	"we-are-legion/rag"
+	"we-are-legion/tracing"
+
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AgentTool is a tool of the agent loop of the selected clone.
+// The server is used by the approval policies (see ApproveToolCall).
+type AgentTool struct {
+	Definition openai.ChatCompletionToolParam
+	Server     string
+	Execute    func(ctx context.Context, arguments map[string]any) (string, error)
+}
+
+// DocsSearchTool returns the search_docs tool: the clone searches its own RAG memory.
+func DocsSearchTool(clone *agents.AgentConfig, retrieval config.RetrievalConfig) AgentTool {
+	return AgentTool{
+		Definition: openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        "search_docs",
+				Description: openai.String("search the documents of your RAG memory"),
+				Parameters: openai.FunctionParameters{
+					"type": "object",
+					"properties": map[string]any{
+						"query": map[string]string{
+							"type":        "string",
+							"description": "The search query.",
+						},
+					},
+					"required": []string{"query"},
+				},
+			},
+		},
+		Server: strings.ToLower(clone.Name),
+		Execute: func(ctx context.Context, arguments map[string]any) (string, error) {
+			query, _ := arguments["query"].(string)
+			similarities, err := SearchDocuments(ctx, clone, query, retrieval)
+			if err != nil {
+				return "", err
+			}
+			contents := rag.MergeChunks(rag.ChunksOf(similarities), rag.DuplicateThreshold)
+			if retrieval.MaxContextTokens > 0 {
+				contents = rag.LimitTokens(contents, retrieval.MaxContextTokens)
+			}
+			if len(contents) == 0 {
+				return "No document found.", nil
+			}
+			return strings.Join(contents, "\n"), nil
+		},
+	}
+}
+
+// MCPAgentTools returns the MCP tools of the hub matching all the allowlists as tools of the agent loop.
+// The results of the tools are capped to the budget of the MCP results (see config.yml).
+func MCPAgentTools(hub *mcphub.Hub, allowlists ...[]string) []AgentTool {
+	tools := []AgentTool{}
+	for _, definition := range hub.Tools(allowlists...) {
+		toolName := definition.Function.Name
+		tools = append(tools, AgentTool{
+			Definition: definition,
+			Server:     hub.ServerOf(toolName),
+			Execute: func(ctx context.Context, arguments map[string]any) (string, error) {
+				result, _, err := hub.CallToolCached(ctx, toolName, arguments)
+				return TruncateToTokens(result, config.Get().MCP.Results.MaxTokens), err
+			},
+		})
+	}
+	return tools
+}
+
+// RunAgentLoop lets the selected clone call tools up to max steps times before answering the user question:
+// at every step, the clone detects the tool calls with its own model, the tool calls are validated, approved and executed,
+// and their results are sent back to the clone. Every step is streamed.
+// The loop stops when the clone does not call any tool. The messages of the clone must not contain the user question yet:
+// the results of the tools are appended to them, and the caller adds the user question after them for the final answer
+// (see AddContextToMessages). It returns the number of steps.
+func RunAgentLoop(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, clone *agents.AgentConfig, userQuestion string, tools []AgentTool, approvals *approval.Request, maxSteps int) int {
+	if len(tools) == 0 || maxSteps <= 0 {
+		return 0
+	}
+	ctx, span := tracing.Start(ctx, "agent_loop",
+		attribute.String(tracing.AttrAgent, clone.Name),
+		attribute.Int("tools", len(tools)),
+		attribute.Int("max_steps", maxSteps),
+	)
+	defer span.End()
+	helpers.ResponseLabel(response, flusher, "step", fmt.Sprintf("Agent loop of %s (max %d steps)...", clone.Name, maxSteps))
+
+	toolsByName := map[string]AgentTool{}
+	definitions := []openai.ChatCompletionToolParam{}
+	for _, tool := range tools {
+		toolsByName[tool.Definition.Function.Name] = tool
+		definitions = append(definitions, tool.Definition)
+	}
+
+	// NOTE: the loop works on a copy of the agent, the tool calls protocol is not kept in the conversational memory
+	loopAgent := *clone.Agent
+	loopAgent.Tools = definitions
+	loopAgent.Params.Messages = append([]openai.ChatCompletionMessageParamUnion{}, clone.Agent.Params.Messages...)
+	loopAgent.Params.Messages = append(loopAgent.Params.Messages,
+		openai.SystemMessage("You can call the tools to find the information needed to answer the user question. When you have enough information, answer without calling any tool."),
+		openai.UserMessage(userQuestion),
+	)
+
+	toolResults := []string{}
+	steps := 0
+	pending := false
+	for {
+		toolCalls, err := toolsCompletion(ctx, "agent_loop.tools_completion", clone.Name, &loopAgent)
+		if err != nil || len(toolCalls) == 0 {
+			break
+		}
+		if steps == maxSteps {
+			// NOTE: the clone still calls tools, they are not executed
+			pending = true
+			break
+		}
+		steps++
+
+		toolCallParams := []openai.ChatCompletionMessageToolCallParam{}
+		for _, toolCall := range toolCalls {
+			toolCallParams = append(toolCallParams, toolCall.ToParam())
+		}
+		loopAgent.Params.Messages = append(loopAgent.Params.Messages, openai.ChatCompletionMessageParamUnion{
+			OfAssistant: &openai.ChatCompletionAssistantMessageParam{ToolCalls: toolCallParams},
+		})
+
+		for _, toolCall := range toolCalls {
+			toolName := toolCall.Function.Name
+			slog.InfoContext(ctx, "🔁 agent loop step", "agent", clone.Name, "step_number", steps, "tool", toolName, "arguments", toolCall.Function.Arguments)
+			helpers.ResponseLabel(response, flusher, "step", fmt.Sprintf("Step %d: %s %s", steps, toolName, toolCall.Function.Arguments))
+
+			result, err := executeAgentTool(ctx, response, flusher, toolsByName, definitions, approvals, toolCall)
+			if err != nil {
+				slog.ErrorContext(ctx, "😡 agent loop tool failed", "agent", clone.Name, "tool", toolName, "error", err)
+				helpers.ResponseLabel(response, flusher, "error", toolName+": "+err.Error())
+				result = "Error: " + err.Error()
+			} else {
+				helpers.ResponseLabel(response, flusher, "white", fmt.Sprintf("%s: ~%d tokens", toolName, rag.EstimateTokens(result)))
+				toolResults = append(toolResults, fmt.Sprintf("[%s %s]\n%s", toolName, toolCall.Function.Arguments, result))
+			}
+			loopAgent.Params.Messages = append(loopAgent.Params.Messages, openai.ToolMessage(result, toolCall.ID))
+		}
+	}
+	span.SetAttributes(attribute.Int("steps", steps))
+	if pending {
+		slog.WarnContext(ctx, "🔁 agent loop stopped with pending tool calls", "agent", clone.Name, "steps", steps)
+		helpers.ResponseLabel(response, flusher, "warning", fmt.Sprintf("Agent loop stopped after %d steps", steps))
+	}
+
+	if len(toolResults) > 0 {
+		// NOTE: the user question is added after the results by the caller
+		clone.Agent.Params.Messages = append(clone.Agent.Params.Messages,
+			openai.SystemMessage("Here are the results of the tools you called:\n"+strings.Join(toolResults, "\n\n")),
+		)
+	}
+	return steps
+}
+
+func executeAgentTool(ctx context.Context, response http.ResponseWriter, flusher http.Flusher, toolsByName map[string]AgentTool, definitions []openai.ChatCompletionToolParam, approvals *approval.Request, toolCall openai.ChatCompletionMessageToolCall) (result string, err error) {
+	tool := toolsByName[toolCall.Function.Name]
+	ctx, span := tracing.Start(ctx, "agent_loop.tool",
+		attribute.String("tool", toolCall.Function.Name),
+		attribute.String("server", tool.Server),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("result_tokens", rag.EstimateTokens(result)))
+		tracing.End(span, err)
+	}()
+
+	if err := ValidateToolCall(definitions, toolCall); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if !ApproveToolCall(ctx, response, flusher, approvals, tool.Server, toolCall) {
+		return "", errors.New("the tool call was not approved")
+	}
+	var arguments map[string]any
+	if strings.TrimSpace(toolCall.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+			return "", err
+		}
+	}
+	return tool.Execute(ctx, arguments)
+}
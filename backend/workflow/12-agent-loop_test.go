@@ -0,0 +1,256 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"we-are-legion/agents"
+	"we-are-legion/tracing"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newToolCallsModel returns a fake chat completions endpoint calling the echo tool the given number of times,
+// and the messages of the requests it received.
+func newToolCallsModel(t *testing.T, toolCalls int) (string, func() [][]map[string]any) {
+	var mutex sync.Mutex
+	requests := [][]map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		json.NewDecoder(request.Body).Decode(&body)
+		mutex.Lock()
+		requests = append(requests, body.Messages)
+		count := len(requests)
+		mutex.Unlock()
+
+		message := map[string]any{"role": "assistant", "content": ""}
+		if count <= toolCalls {
+			message["tool_calls"] = []map[string]any{{
+				"id":       fmt.Sprintf("call_%d", count),
+				"type":     "function",
+				"function": map[string]any{"name": "echo", "arguments": fmt.Sprintf(`{"text": "result %d"}`, count)},
+			}}
+		}
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(map[string]any{
+			"id":      "completion",
+			"object":  "chat.completion",
+			"model":   "fake",
+			"choices": []map[string]any{{"index": 0, "finish_reason": "stop", "message": message}},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, func() [][]map[string]any {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests
+	}
+}
+
+func echoAgentTool() AgentTool {
+	return AgentTool{
+		Definition: openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name: "echo",
+				Parameters: openai.FunctionParameters{
+					"type":       "object",
+					"properties": map[string]any{"text": map[string]string{"type": "string"}},
+					"required":   []string{"text"},
+				},
+			},
+		},
+		Server: "stub",
+		Execute: func(ctx context.Context, arguments map[string]any) (string, error) {
+			text, _ := arguments["text"].(string)
+			return text, nil
+		},
+	}
+}
+
+func messageContent(message openai.ChatCompletionMessageParamUnion) string {
+	if content, ok := message.GetContent().AsAny().(*string); ok {
+		return *content
+	}
+	return ""
+}
+
+func TestRunAgentLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		toolCalls int
+		maxSteps  int
+		wantSteps int
+		warning   bool
+	}{
+		{"no tool call", 0, 3, 0, false},
+		{"the clone stops calling tools", 2, 3, 2, false},
+		{"the clone stops calling tools at the last step", 3, 3, 3, false},
+		{"pending tool calls after the last step", 5, 3, 3, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, requests := newToolCallsModel(t, test.toolCalls)
+			agent, err := robby.NewAgent(robby.WithDMRClient(context.Background(), url), robby.WithParams(openai.ChatCompletionNewParams{
+				Model: "fake",
+				Messages: []openai.ChatCompletionMessageParamUnion{
+					openai.SystemMessage("You are Bob"),
+					openai.UserMessage("previous question"),
+					openai.AssistantMessage("previous answer"),
+				},
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clone := &agents.AgentConfig{Name: "Bob", Agent: agent}
+
+			recorder := httptest.NewRecorder()
+			steps := RunAgentLoop(context.Background(), recorder, recorder, clone, "the question", []AgentTool{echoAgentTool()}, nil, test.maxSteps)
+			if steps != test.wantSteps {
+				t.Errorf("RunAgentLoop() = %d steps, want %d", steps, test.wantSteps)
+			}
+			if warning := strings.Contains(recorder.Body.String(), "Agent loop stopped"); warning != test.warning {
+				t.Errorf("warning = %v, want %v:\n%s", warning, test.warning, recorder.Body.String())
+			}
+
+			// NOTE: the clone calls the tools to answer the user question (after the history of the conversation)
+			for _, messages := range requests() {
+				questions := []string{}
+				for _, message := range messages {
+					if message["role"] == "user" {
+						questions = append(questions, fmt.Sprint(message["content"]))
+					}
+				}
+				if len(questions) != 2 || questions[1] != "the question" {
+					t.Errorf("the questions sent to the model are %q, want the previous question and the question", questions)
+				}
+			}
+
+			// NOTE: the history is kept, the results of the tools are added before the question
+			AddContextToMessages(clone, nil, "the question")
+			messages := agent.Params.Messages
+			wantMessages := 4
+			if test.wantSteps > 0 {
+				wantMessages = 5
+			}
+			if len(messages) != wantMessages {
+				t.Fatalf("the clone has %d messages, want %d", len(messages), wantMessages)
+			}
+			if messageContent(messages[1]) != "previous question" || messageContent(messages[2]) != "previous answer" {
+				t.Errorf("the history of the conversation has changed: %v", messages)
+			}
+			if messages[len(messages)-1].OfUser == nil || messageContent(messages[len(messages)-1]) != "the question" {
+				t.Errorf("the last message is not the question: %v", messages[len(messages)-1])
+			}
+			if test.wantSteps > 0 {
+				results := messageContent(messages[3])
+				if messages[3].OfSystem == nil || !strings.Contains(results, fmt.Sprintf("result %d", test.wantSteps)) {
+					t.Errorf("the results of the tools are not before the question: %q", results)
+				}
+			}
+		})
+	}
+}
+
+// newSpanRecorder records the spans of the test in memory (the tracer provider is restored at the end of the test).
+func newSpanRecorder(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func TestAgentLoopSpans(t *testing.T) {
+	exporter := newSpanRecorder(t)
+	url, _ := newToolCallsModel(t, 2)
+	agent, err := robby.NewAgent(robby.WithDMRClient(context.Background(), url), robby.WithParams(openai.ChatCompletionNewParams{
+		Model:    "fake",
+		Messages: []openai.ChatCompletionMessageParamUnion{openai.SystemMessage("You are Bob")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	RunAgentLoop(context.Background(), recorder, recorder, &agents.AgentConfig{Name: "Bob", Agent: agent}, "the question", []AgentTool{echoAgentTool()}, nil, 3)
+
+	spans := exporter.GetSpans()
+	var loopSpan tracetest.SpanStub
+	counts := map[string]int{}
+	for _, span := range spans {
+		counts[span.Name]++
+		if span.Name == "agent_loop" {
+			loopSpan = span
+		}
+	}
+	// NOTE: 2 steps, then a last completion without tool calls
+	wantCounts := map[string]int{"agent_loop": 1, "agent_loop.tools_completion": 3, "agent_loop.tool": 2}
+	if len(counts) != len(wantCounts) {
+		t.Errorf("spans = %v, want %v", counts, wantCounts)
+	}
+	for name, count := range wantCounts {
+		if counts[name] != count {
+			t.Errorf("%d %s spans, want %d", counts[name], name, count)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		attributes []attribute.KeyValue
+	}{
+		{"agent_loop", []attribute.KeyValue{
+			attribute.String(tracing.AttrAgent, "Bob"), attribute.Int("tools", 1), attribute.Int("max_steps", 3), attribute.Int("steps", 2),
+		}},
+		{"agent_loop.tools_completion", []attribute.KeyValue{
+			attribute.String(tracing.AttrAgent, "Bob"), attribute.String(tracing.AttrModel, "fake"), attribute.Int("tools", 1),
+		}},
+		{"agent_loop.tool", []attribute.KeyValue{
+			attribute.String("tool", "echo"), attribute.String("server", "stub"), attribute.Int("result_tokens", 2),
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, span := range spans {
+				if span.Name != test.name {
+					continue
+				}
+				for _, want := range test.attributes {
+					if !spanHasAttribute(span, want) {
+						t.Errorf("the %s span has no attribute %s=%s: %v", span.Name, want.Key, want.Value.Emit(), span.Attributes)
+					}
+				}
+				// NOTE: the spans of the steps are the children of the span of the loop
+				if span.Name != "agent_loop" && span.Parent.SpanID() != loopSpan.SpanContext.SpanID() {
+					t.Errorf("the parent of the %s span is not the agent_loop span", span.Name)
+				}
+				if span.Status.Code != codes.Unset {
+					t.Errorf("the %s span has the status %v", span.Name, span.Status)
+				}
+			}
+		})
+	}
+	if loopSpan.Parent.IsValid() {
+		t.Errorf("the agent_loop span has a parent: %v", loopSpan.Parent)
+	}
+}
+
+func spanHasAttribute(span tracetest.SpanStub, want attribute.KeyValue) bool {
+	for _, attribute := range span.Attributes {
+		if attribute == want {
+			return true
+		}
+	}
+	return false
+}
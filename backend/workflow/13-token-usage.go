@@ -0,0 +1,109 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"we-are-legion/rag"
+	"we-are-legion/tracing"
+	"we-are-legion/usage"
+
+	"github.com/openai/openai-go"
+	"github.com/sea-monkeys/robby"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NOTE: robby does not return the usage field of the completions,
+// the tokens of the completions are estimated (~4 characters per token, see rag.EstimateTokens).
+
+// toolsCompletion detects the tool calls with the agent: the completion is traced and its token usage is recorded (see usage.Add).
+// The prompt contains the messages and the definitions of the tools.
+func toolsCompletion(ctx context.Context, spanName string, agentName string, agent *robby.Agent) ([]openai.ChatCompletionMessageToolCall, error) {
+	toolsJSON, _ := json.Marshal(agent.Tools)
+	promptTokens := rag.EstimateMessagesTokens(agent.Params.Messages) + rag.EstimateTokens(string(toolsJSON))
+	_, span := tracing.Start(ctx, spanName,
+		attribute.String(tracing.AttrAgent, agentName),
+		attribute.String(tracing.AttrModel, agent.Params.Model),
+		attribute.Int("tools", len(agent.Tools)),
+		attribute.Int(tracing.AttrInputTokens, promptTokens),
+	)
+
+	toolCalls, err := agent.ToolsCompletion()
+
+	completionTokens := 0
+	for _, toolCall := range toolCalls {
+		completionTokens += rag.EstimateTokens(toolCall.Function.Name + toolCall.Function.Arguments)
+	}
+	usage.Add(ctx, usage.Record{
+		Agent:            agentName,
+		Model:            agent.Params.Model,
+		Kind:             usage.KindTools,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Estimated:        true,
+	})
+	span.SetAttributes(attribute.Int("tool_calls", len(toolCalls)), attribute.Int(tracing.AttrOutputTokens, completionTokens))
+	// NOTE: "no tool calls detected" is not an error (see robby.ToolsCompletion)
+	if len(toolCalls) == 0 {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return toolCalls, err
+}
+
+// chatCompletion generates an answer with the agent (not streamed): the completion is traced and its token usage is recorded (see usage.Add).
+func chatCompletion(ctx context.Context, spanName string, agentName string, agent *robby.Agent) (string, error) {
+	promptTokens := rag.EstimateMessagesTokens(agent.Params.Messages)
+	_, span := tracing.Start(ctx, spanName,
+		attribute.String(tracing.AttrAgent, agentName),
+		attribute.String(tracing.AttrModel, agent.Params.Model),
+		attribute.Int(tracing.AttrInputTokens, promptTokens),
+	)
+
+	answer, err := agent.ChatCompletion()
+
+	RecordChatUsage(ctx, agentName, agent.Params.Model, promptTokens, answer)
+	span.SetAttributes(attribute.Int(tracing.AttrOutputTokens, rag.EstimateTokens(answer)))
+	tracing.End(span, err)
+	return answer, err
+}
+
+// RecordChatUsage records the token usage of a chat completion (ex: the streamed answer of the selected clone).
+func RecordChatUsage(ctx context.Context, agentName string, model string, promptTokens int, answer string) {
+	usage.Add(ctx, usage.Record{
+		Agent:            agentName,
+		Model:            model,
+		Kind:             usage.KindChat,
+		PromptTokens:     promptTokens,
+		CompletionTokens: rag.EstimateTokens(answer),
+		Estimated:        true,
+	})
+}
+
+// FormatUsage returns the text of the usage event sent at the end of the stream, ex:
+// "1234 tokens (1100 prompt + 134 completion) · Bob: 900 · Riker: 334".
+func FormatUsage(summary usage.Summary) string {
+	agentNames := []string{}
+	for agentName := range summary.ByAgent {
+		agentNames = append(agentNames, agentName)
+	}
+	sort.SliceStable(agentNames, func(i, j int) bool {
+		if summary.ByAgent[agentNames[i]].TotalTokens != summary.ByAgent[agentNames[j]].TotalTokens {
+			return summary.ByAgent[agentNames[i]].TotalTokens > summary.ByAgent[agentNames[j]].TotalTokens
+		}
+		return agentNames[i] < agentNames[j]
+	})
+
+	parts := []string{fmt.Sprintf("%d tokens (%d prompt + %d completion)", summary.TotalTokens, summary.PromptTokens, summary.CompletionTokens)}
+	for _, agentName := range agentNames {
+		parts = append(parts, fmt.Sprintf("%s: %d", agentName, summary.ByAgent[agentName].TotalTokens))
+	}
+	text := strings.Join(parts, " · ")
+	if summary.EstimatedCalls > 0 {
+		text = "~" + text
+	}
+	return text
+}
@@ -0,0 +1,41 @@
+package workflow
+
+import (
+	"testing"
+	"we-are-legion/usage"
+)
+
+func TestFormatUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		summary usage.Summary
+		want    string
+	}{
+		{"no call", usage.Summary{}, "0 tokens (0 prompt + 0 completion)"},
+		{"agents ordered by tokens", usage.Summary{
+			Usage: usage.Usage{Calls: 3, PromptTokens: 1100, CompletionTokens: 134, TotalTokens: 1234},
+			ByAgent: map[string]usage.Usage{
+				"Riker": {TotalTokens: 334},
+				"Bob":   {TotalTokens: 900},
+			},
+		}, "1234 tokens (1100 prompt + 134 completion) · Bob: 900 · Riker: 334"},
+		{"same tokens ordered by name", usage.Summary{
+			Usage: usage.Usage{Calls: 2, PromptTokens: 20, TotalTokens: 20},
+			ByAgent: map[string]usage.Usage{
+				"Spock": {TotalTokens: 10},
+				"Khan":  {TotalTokens: 10},
+			},
+		}, "20 tokens (20 prompt + 0 completion) · Khan: 10 · Spock: 10"},
+		{"estimated", usage.Summary{
+			Usage:   usage.Usage{Calls: 1, EstimatedCalls: 1, PromptTokens: 40, CompletionTokens: 2, TotalTokens: 42},
+			ByAgent: map[string]usage.Usage{"Bob": {TotalTokens: 42}},
+		}, "~42 tokens (40 prompt + 2 completion) · Bob: 42"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FormatUsage(test.summary); got != test.want {
+				t.Errorf("FormatUsage() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
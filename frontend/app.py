@@ -2,6 +2,7 @@ import streamlit as st
 import requests
 import os
 import re
+import uuid
 from datetime import datetime
 
 #PAGE_TITLE = os.environ.get('PAGE_TITLE', 'Web Chat Bot demo')
@@ -84,6 +85,7 @@ st.markdown("""
     .label-warning { background-color: #ffd33d; color: #24292e; }
     .label-info { background-color: #0366d6; }
     .label-step { background-color: #6f42c1; }
+    .label-usage { background-color: #f6f8fa; color: #586069; border: 1px solid #d1d5da; }
     
     /* Additional semantic colors */
     .label-bug { background-color: #d73a49; }
@@ -133,12 +135,12 @@ def convert_tags_to_labels(text):
     result = re.sub(pattern, replace_tag, text)
     return result
 
-def stream_response(message, session_id):
-    """Stream the message response from the backend"""
+def stream_response(message, session_id, request_id):
+    """Stream the message response from the backend (the web search toggle is a setting of the session)"""
     try:
         with requests.post(
             BACKEND_SERVICE_URL+"/chat",
-            json={"message": message, "sessionId": session_id},
+            json={"message": message, "sessionId": session_id, "requestId": request_id},
             headers={"Content-Type": "application/json"},
             stream=True
         ) as response:
@@ -223,6 +225,30 @@ def clear_conversation_history(session_id):
         st.error(f"Error clearing history: {str(e)}")
 
 
+def get_web_search(session_id):
+    """Get the web search toggle of the session (None: not set, the backend uses its default value)"""
+    try:
+        response = requests.get(f"{BACKEND_SERVICE_URL}/sessions/{session_id}")
+        if response.status_code == 200:
+            return response.json().get("web_search")
+    except requests.exceptions.RequestException:
+        pass
+    return None
+
+def set_web_search(session_id):
+    """Store the web search toggle in the session of the backend (it is used by the next messages)"""
+    try:
+        response = requests.put(
+            f"{BACKEND_SERVICE_URL}/sessions/{session_id}",
+            json={"web_search": st.session_state[f"web_search_{session_id}"]},
+            headers={"Content-Type": "application/json"}
+        )
+        if response.status_code != 200:
+            st.error("Failed to update the web search setting")
+    except requests.exceptions.RequestException as e:
+        st.error(f"Error updating the web search setting: {str(e)}")
+
+
 def increment_input_key():
     """Increment the input key to reset the input field"""
     st.session_state.input_key += 1
@@ -239,6 +265,18 @@ session_id = st.text_input(
 )
 st.session_state.session_id = session_id
 
+# Web search toggle of the session (the MCP tools are only used if it is checked)
+# NOTE: the toggle is stored in the session of the backend when it is changed,
+# until then the backend uses its default value (mcp.web_search of config.yml)
+st.checkbox(
+    "🔎 Web search",
+    value=bool(get_web_search(session_id)),
+    key=f"web_search_{session_id}",
+    on_change=set_web_search,
+    args=(session_id,),
+    help="Allow the backend to use the MCP tools (ex: Brave search) to answer"
+)
+
 #models_info = st.text_input("Models", value=f"📕 {LLM_CHAT} - 🌐 {LLM_EMBEDDINGS}")
 
 # Form to send a message
@@ -268,7 +306,9 @@ if submit_button and message and len(message.strip()) > 0:
     })
     
     # Stream the response from the backend
-    response = stream_response(message, st.session_state.session_id)
+    # NOTE: the id of the request is kept to cancel it (DELETE /cancel/{requestId})
+    st.session_state.request_id = uuid.uuid4().hex
+    response = stream_response(message, st.session_state.session_id, st.session_state.request_id)
     
     # Add the response to the history
     st.session_state.messages.append({
@@ -285,9 +325,11 @@ if submit_button and message and len(message.strip()) > 0:
 # Handle the message submission and cancellation
 if cancel_button:
     try:
-        response = requests.delete(f"{BACKEND_SERVICE_URL}/cancel")
+        response = requests.delete(f"{BACKEND_SERVICE_URL}/cancel/{st.session_state.get('request_id', '')}")
         if response.status_code == 200:
             st.success("Request cancelled successfully")
+        elif response.status_code == 404:
+            st.info("No active request to cancel")
         else:
             st.error("Failed to cancel request")
     except requests.exceptions.RequestException as e: